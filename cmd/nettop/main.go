@@ -56,7 +56,19 @@ func yamlMarshalUsingJSON(content interface{}) ([]byte, error) {
 func writeContent(outputFile, outputFormat string, content interface{}) error {
 	var buf []byte
 	var err error
-	if outputFormat == yamlFormat {
+	if stringer, ok := content.(fmt.Stringer); ok && outputFormat == textFormat {
+		buf = []byte(stringer.String())
+	} else if markdowner, ok := content.(interface{ Markdown() string }); ok && outputFormat == markdownFormat {
+		buf = []byte(markdowner.Markdown())
+	} else if csvWriter, ok := content.(interface{ CSV() string }); ok && outputFormat == csvFormat {
+		buf = []byte(csvWriter.CSV())
+	} else if htmlWriter, ok := content.(interface{ HTML() string }); ok && outputFormat == htmlFormat {
+		buf = []byte(htmlWriter.HTML())
+	} else if conns, ok := content.([]*analyzer.Connections); ok && outputFormat == dotFormat {
+		buf = []byte(analyzer.DOTFromConnections(conns))
+	} else if conns, ok := content.([]*analyzer.Connections); ok && outputFormat == mermaidFormat {
+		buf = []byte(analyzer.MermaidFromConnections(conns))
+	} else if outputFormat == yamlFormat {
 		buf, err = yamlMarshalUsingJSON(content)
 	} else {
 		const indent = "    "
@@ -90,33 +102,162 @@ func getVerbosity(args *inArgs) analyzer.Verbosity {
 // (or NetworkPolicies to allow only this connectivity)
 func detectTopology(args *inArgs) error {
 	logger := analyzer.NewDefaultLoggerWithVerbosity(getVerbosity(args))
-	synth := analyzer.NewPoliciesSynthesizer(analyzer.WithLogger(logger), analyzer.WithDNSPort(*args.DNSPort))
-
-	var content interface{}
-	if args.SynthNetpols != nil && *args.SynthNetpols {
-		policies, synthesisErr := synth.PoliciesFromFolderPaths(args.DirPaths)
-		if synthesisErr != nil {
-			logger.Errorf(synthesisErr, "error synthesizing policies")
-			return synthesisErr
-		}
-		content = analyzer.NetpolListFromNetpolSlice(policies)
-	} else {
-		var err error
-		content, err = synth.ConnectionsFromFolderPaths(args.DirPaths)
-		if err != nil {
+	synth := analyzer.NewPoliciesSynthesizer(synthesizerOptions(args, logger)...)
+	switch args.Command {
+	case explainCommand:
+		return explainWorkload(synth, args, logger)
+	case diffCommand:
+		return diffRevisions(synth, args, logger)
+	case gapsCommand:
+		return findPolicyGaps(synth, args, logger)
+	}
+
+	content, err := synthesizeContent(synth, args)
+	if err != nil {
+		if *args.OutputKind == connectionsOutputKind {
 			logger.Errorf(err, "error extracting connections")
-			return err
+		} else {
+			logger.Errorf(err, "error synthesizing policies")
 		}
+		return err
+	}
+
+	if err = writeContent(*args.OutputFile, *args.OutputFormat, content); err != nil {
+		logger.Errorf(err, "error writing results")
+		return err
+	}
+
+	return nil
+}
+
+// explainWorkload outputs how the connectivity of the workload given in the arguments was determined
+func explainWorkload(synth *analyzer.PoliciesSynthesizer, args *inArgs, logger analyzer.Logger) error {
+	namespace, kind, name := args.WorkloadRef[0], args.WorkloadRef[1], args.WorkloadRef[2]
+	explanation, err := synth.ExplainWorkloadFromFolderPaths(args.DirPaths, namespace, kind, name)
+	if err != nil {
+		logger.Errorf(err, "error explaining workload")
+		return err
+	}
+
+	if err = writeContent(*args.OutputFile, *args.OutputFormat, explanation); err != nil {
+		logger.Errorf(err, "error writing results")
+		return err
+	}
+	return nil
+}
+
+// diffRevisions outputs the differences between the connectivity of the base and the head revisions given in the arguments
+func diffRevisions(synth *analyzer.PoliciesSynthesizer, args *inArgs, logger analyzer.Logger) error {
+	diff, err := synth.DiffFromFolderPaths(args.BaseDirPaths, args.HeadDirPaths)
+	if err != nil {
+		logger.Errorf(err, "error comparing revisions")
+		return err
 	}
 
-	if err := writeContent(*args.OutputFile, *args.OutputFormat, content); err != nil {
+	if err = writeContent(*args.OutputFile, *args.OutputFormat, diff); err != nil {
 		logger.Errorf(err, "error writing results")
 		return err
 	}
+	return nil
+}
+
+// findPolicyGaps outputs the gaps between the discovered connectivity and the NetworkPolicies found in the input directories
+func findPolicyGaps(synth *analyzer.PoliciesSynthesizer, args *inArgs, logger analyzer.Logger) error {
+	report, err := synth.PolicyGapsFromFolderPaths(args.DirPaths)
+	if err != nil {
+		logger.Errorf(err, "error analyzing existing policies")
+		return err
+	}
 
+	if err = writeContent(*args.OutputFile, *args.OutputFormat, report); err != nil {
+		logger.Errorf(err, "error writing results")
+		return err
+	}
 	return nil
 }
 
+func synthesizerOptions(args *inArgs, logger analyzer.Logger) []analyzer.PoliciesSynthesizerOption {
+	options := []analyzer.PoliciesSynthesizerOption{analyzer.WithLogger(logger), analyzer.WithDNSPort(*args.DNSPort),
+		analyzer.WithProbeSourceCIDRs(args.ProbeCIDRs)}
+	if *args.IngressNs != "" || len(args.IngressPods) > 0 {
+		options = append(options, analyzer.WithIngressSource(analyzer.IngressSource{Namespace: *args.IngressNs, PodLabels: args.IngressPods}))
+	}
+	if *args.DNSVisible {
+		options = append(options, analyzer.WithCiliumDNSVisibility())
+	}
+	if *args.BaselineANP {
+		options = append(options, analyzer.WithBaselineAdminNetworkPolicy())
+	}
+	if *args.ANPAllowDNS {
+		options = append(options, analyzer.WithAdminNetworkPolicyAllowingDNS())
+	}
+	if *args.MonitoringNs != "" {
+		options = append(options, analyzer.WithAdminNetworkPolicyAllowingMonitoring(*args.MonitoringNs))
+	}
+	if *args.MinSelectors {
+		options = append(options, analyzer.WithMinimalPodSelectors())
+	}
+	if len(args.IgnoredLbls) > 0 {
+		options = append(options, analyzer.WithIgnoredPodLabels(args.IgnoredLbls))
+	}
+	if *args.Provenance {
+		options = append(options, analyzer.WithProvenanceAnnotations())
+	}
+	for _, gwClass := range args.GwClasses {
+		options = append(options, analyzer.WithGatewayClassIngressSource(gwClass, analyzer.IngressSource{}))
+	}
+	return options
+}
+
+// synthesizeContent returns the output content of the requested kind
+func synthesizeContent(synth *analyzer.PoliciesSynthesizer, args *inArgs) (interface{}, error) {
+	switch *args.OutputKind {
+	case netpolsOutputKind:
+		policies, err := synth.PoliciesFromFolderPaths(args.DirPaths)
+		if err != nil {
+			return nil, err
+		}
+		if adminPolicies := synth.AdminPolicies(); len(adminPolicies) > 0 {
+			return analyzer.PolicyListFromSlices(policies, adminPolicies), nil
+		}
+		return analyzer.NetpolListFromNetpolSlice(policies), nil
+	case istioOutputKind:
+		policies, err := synth.AuthorizationPoliciesFromFolderPaths(args.DirPaths)
+		if err != nil {
+			return nil, err
+		}
+		return analyzer.AuthorizationPolicyListFromSlice(policies), nil
+	case ciliumOutputKind:
+		policies, err := synth.CiliumPoliciesFromFolderPaths(args.DirPaths)
+		if err != nil {
+			return nil, err
+		}
+		return analyzer.CiliumPolicyListFromSlice(policies), nil
+	case calicoOutputKind:
+		policies, err := synth.CalicoPoliciesFromFolderPaths(args.DirPaths)
+		if err != nil {
+			return nil, err
+		}
+		return analyzer.CalicoPolicyListFromSlice(policies), nil
+	case matrixOutputKind:
+		return synth.ConnectivityMatrixFromFolderPaths(args.DirPaths)
+	case egressFirewallKind, egressNetpolKind:
+		firewalls, err := synth.EgressFirewallsFromFolderPaths(args.DirPaths)
+		if err != nil {
+			return nil, err
+		}
+		if *args.OutputKind == egressNetpolKind {
+			return analyzer.EgressNetworkPolicyListFromEgressFirewalls(firewalls), nil
+		}
+		return analyzer.EgressFirewallListFromSlice(firewalls), nil
+	default:
+		if *args.OutputFormat == htmlFormat {
+			return synth.TopologyReportFromFolderPaths(args.DirPaths)
+		}
+		return synth.ConnectionsFromFolderPaths(args.DirPaths)
+	}
+}
+
 // The actual main function
 // Takes command-line flags and returns an error rather than exiting, so it can be more easily used in testing
 func _main(cmdlineArgs []string) error {
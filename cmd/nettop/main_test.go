@@ -155,6 +155,223 @@ var (
 			false,
 			[]string{"openshift", "expected_netpol_output.yaml"},
 		},
+		{
+			"RolloutsKnativeAndDeploymentConfigs",
+			[][]string{{"rollouts_knative_dc"}},
+			yamlFormat,
+			true,
+			[]string{"-v"},
+			false,
+			[]string{"rollouts_knative_dc", "expected_netpol_output.yaml"},
+		},
+		{
+			"IstioAuthorizationPolicies",
+			[][]string{{"bookinfo"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "istio"},
+			false,
+			[]string{"bookinfo", "expected_authz_output.yaml"},
+		},
+		{
+			"CiliumPolicies",
+			[][]string{{"cilium_l7"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "cilium", "-ingressnamespace", "ingress-nginx", "-ingresspodlabel", "app.kubernetes.io/name=ingress-nginx"},
+			false,
+			[]string{"cilium_l7", "expected_cilium_output.yaml"},
+		},
+		{
+			"CalicoPolicies",
+			[][]string{{"ingress_sources"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "calico", "-ingressnamespace", "ingress-nginx", "-ingresspodlabel", "app.kubernetes.io/name=ingress-nginx",
+				"-gatewayclass", "eg"},
+			false,
+			[]string{"ingress_sources", "expected_calico_output.yaml"},
+		},
+		{
+			"AdminNetworkPolicies",
+			[][]string{{"k8s_guestbook"}},
+			yamlFormat,
+			true,
+			[]string{"-baselineadminpolicy", "-adminallowdns", "-monitoringnamespace", "monitoring"},
+			false,
+			[]string{"k8s_guestbook", "expected_admin_netpol_output.yaml"},
+		},
+		{
+			"EgressFirewalls",
+			[][]string{{"istio_mesh"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "egressfirewall"},
+			false,
+			[]string{"istio_mesh", "expected_egressfirewall_output.yaml"},
+		},
+		{
+			"EgressNetworkPolicies",
+			[][]string{{"istio_mesh"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "egressnetworkpolicy"},
+			false,
+			[]string{"istio_mesh", "expected_egressnetworkpolicy_output.yaml"},
+		},
+		{
+			"MinimalPodSelectors",
+			[][]string{{"bookinfo"}},
+			yamlFormat,
+			true,
+			[]string{"-minimalselectors"},
+			false,
+			[]string{"bookinfo", "expected_netpol_minimal_selectors_output.yaml"},
+		},
+		{
+			"MultiTenantNetpols",
+			[][]string{{"multi_tenant"}},
+			yamlFormat,
+			true,
+			nil,
+			false,
+			[]string{"multi_tenant", "expected_netpol_output.yaml"},
+		},
+		{
+			"MultiTenantAuthorizationPolicies",
+			[][]string{{"multi_tenant"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "istio"},
+			false,
+			[]string{"multi_tenant", "expected_authz_output.yaml"},
+		},
+		{
+			"WorkloadSelectorsNetpols",
+			[][]string{{"workload_selectors"}},
+			yamlFormat,
+			true,
+			nil,
+			false,
+			[]string{"workload_selectors", "expected_netpol_output.yaml"},
+		},
+		{
+			"WorkloadSelectorsCalicoPolicies",
+			[][]string{{"workload_selectors"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "calico"},
+			false,
+			[]string{"workload_selectors", "expected_calico_output.yaml"},
+		},
+		{
+			"ConnectivityMatrixText",
+			[][]string{{"k8s_guestbook"}},
+			textFormat,
+			false,
+			[]string{"-output-kind", "matrix"},
+			false,
+			[]string{"k8s_guestbook", "expected_matrix_output.txt"},
+		},
+		{
+			"ConnectivityMatrixCSV",
+			[][]string{{"ingress_sources"}},
+			csvFormat,
+			false,
+			[]string{"-output-kind", "matrix", "-ingressnamespace", "ingress-nginx", "-ingresspodlabel", "app.kubernetes.io/name=ingress-nginx"},
+			false,
+			[]string{"ingress_sources", "expected_matrix_output.csv"},
+		},
+		{
+			"ConnectivityMatrixMarkdown",
+			[][]string{{"k8s_wordpress_example"}},
+			markdownFormat,
+			false,
+			[]string{"-output-kind", "matrix"},
+			false,
+			[]string{"k8s_wordpress_example", "expected_matrix_output.md"},
+		},
+		{
+			"TextFormatWithoutMatrix",
+			[][]string{{"bookinfo"}},
+			textFormat,
+			true,
+			nil,
+			true,
+			nil,
+		},
+		{
+			"TopologyDOT",
+			[][]string{{"k8s_guestbook"}},
+			dotFormat,
+			false,
+			nil,
+			false,
+			[]string{"k8s_guestbook", "expected_output.dot"},
+		},
+		{
+			"TopologyMermaid",
+			[][]string{{"ingress_sources"}},
+			mermaidFormat,
+			false,
+			[]string{"-ingressnamespace", "ingress-nginx", "-ingresspodlabel", "app.kubernetes.io/name=ingress-nginx"},
+			false,
+			[]string{"ingress_sources", "expected_output.mmd"},
+		},
+		{
+			"TopologyHTMLReport",
+			[][]string{{"k8s_guestbook"}},
+			htmlFormat,
+			false,
+			nil,
+			false,
+			[]string{"k8s_guestbook", "expected_output.html"},
+		},
+		{
+			"DOTFormatWithNetpols",
+			[][]string{{"k8s_guestbook"}},
+			dotFormat,
+			true,
+			nil,
+			true,
+			nil,
+		},
+		{
+			"BadOutputKind",
+			[][]string{{"bookinfo"}},
+			yamlFormat,
+			false,
+			[]string{"-output-kind", "kyverno"},
+			true,
+			nil,
+		},
+		{
+			"IstioMesh",
+			[][]string{{"istio_mesh"}},
+			yamlFormat,
+			true,
+			nil,
+			false,
+			[]string{"istio_mesh", "expected_netpol_output.yaml"},
+		},
+		{
+			"IngressSources",
+			[][]string{{"ingress_sources"}},
+			yamlFormat,
+			true,
+			[]string{"-ingressnamespace", "ingress-nginx", "-ingresspodlabel", "app.kubernetes.io/name=ingress-nginx", "-gatewayclass", "eg"},
+			false,
+			[]string{"ingress_sources", "expected_netpol_output.yaml"},
+		},
+		{
+			"BadIngressPodLabel",
+			[][]string{{"ingress_sources"}},
+			yamlFormat,
+			true,
+			[]string{"-ingresspodlabel", "ingress-nginx"},
+			true,
+			nil,
+		},
 		{
 			"SpecifyDNSPort",
 			[][]string{{"acs-security-demos"}},
@@ -200,6 +417,15 @@ var (
 			true,
 			nil,
 		},
+		{
+			"BadProbeCIDR",
+			[][]string{{"bookinfo"}},
+			jsonFormat,
+			true,
+			[]string{"-probecidr", "10.0.0.0/33"},
+			true,
+			nil,
+		},
 		{
 			"noDirPath",
 			nil,
@@ -275,6 +501,70 @@ func TestAll(t *testing.T) {
 	}
 }
 
+func TestExplainCommand(t *testing.T) {
+	dirPath := pathInTestsDir([]string{"explain"})
+	for _, format := range []string{textFormat, jsonFormat, yamlFormat} {
+		outFileName, err := getTempOutputFile()
+		require.Nil(t, err)
+		err = _main([]string{explainCommand, "-dirpath", dirPath, "-workload", "shop/Deployment/checkout", "-format", format,
+			"-outputfile", outFileName})
+		require.Nil(t, err)
+		output, err := os.ReadFile(outFileName)
+		require.Nil(t, err)
+		require.Contains(t, string(output), "inventory")
+		os.Remove(outFileName)
+	}
+
+	require.NotNil(t, _main([]string{explainCommand, "-dirpath", dirPath, "-workload", "shop/checkout"}))
+	require.NotNil(t, _main([]string{explainCommand, "-dirpath", dirPath, "-workload", "shop/Deployment/missing"}))
+	require.NotNil(t, _main([]string{"-dirpath", dirPath, "-format", textFormat}))
+}
+
+func TestDiffCommand(t *testing.T) {
+	baseDir := pathInTestsDir([]string{"connectivity_diff", "base"})
+	headDir := pathInTestsDir([]string{"connectivity_diff", "head"})
+	expectedOutputs := map[string]string{
+		textFormat:     "expected_diff_output.txt",
+		markdownFormat: "expected_diff_output.md",
+		jsonFormat:     "expected_diff_output.json",
+	}
+	for format, expectedOutput := range expectedOutputs {
+		outFileName, err := getTempOutputFile()
+		require.Nil(t, err)
+		err = _main([]string{diffCommand, "-base", baseDir, "-head", headDir, "-format", format, "-outputfile", outFileName})
+		require.Nil(t, err)
+		res, err := compareFiles(pathInTestsDir([]string{"connectivity_diff", expectedOutput}), outFileName)
+		require.Nil(t, err)
+		require.True(t, res)
+		os.Remove(outFileName)
+	}
+
+	require.NotNil(t, _main([]string{diffCommand, "-base", baseDir}))
+	require.NotNil(t, _main([]string{diffCommand, "-base", baseDir, "-head", headDir, "-format", "html"}))
+	require.NotNil(t, _main([]string{"-dirpath", baseDir, "-format", markdownFormat}))
+}
+
+func TestGapsCommand(t *testing.T) {
+	dirPath := pathInTestsDir([]string{"policy_gaps"})
+	expectedOutputs := map[string]string{
+		textFormat:     "expected_gaps_output.txt",
+		markdownFormat: "expected_gaps_output.md",
+	}
+	for format, expectedOutput := range expectedOutputs {
+		outFileName, err := getTempOutputFile()
+		require.Nil(t, err)
+		err = _main([]string{gapsCommand, "-dirpath", dirPath, "-format", format, "-outputfile", outFileName})
+		require.Nil(t, err)
+		res, err := compareFiles(pathInTestsDir([]string{"policy_gaps", expectedOutput}), outFileName)
+		require.Nil(t, err)
+		require.True(t, res)
+		os.Remove(outFileName)
+	}
+
+	require.NotNil(t, _main([]string{gapsCommand}))
+	require.NotNil(t, _main([]string{gapsCommand, "-dirpath", dirPath, "-format", "csv"}))
+}
+
 func getTempOutputFile() (string, error) {
 	outFile, err := os.CreateTemp(os.TempDir(), "cta_temp")
 	if err != nil {
@@ -9,6 +9,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
+	"slices"
+	"strings"
 
 	"github.com/np-guard/cluster-topology-analyzer/v2/pkg/analyzer"
 )
@@ -24,29 +27,144 @@ func (dp *pathList) Set(path string) error {
 	return nil
 }
 
+type stringList []string
+
+func (sl *stringList) String() string {
+	return fmt.Sprintln(*sl)
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+type cidrList []string
+
+func (cl *cidrList) String() string {
+	return fmt.Sprintln(*cl)
+}
+
+func (cl *cidrList) Set(cidr string) error {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return err
+	}
+	*cl = append(*cl, cidr)
+	return nil
+}
+
+type labelMap map[string]string
+
+func (lm *labelMap) String() string {
+	return fmt.Sprintln(*lm)
+}
+
+func (lm *labelMap) Set(label string) error {
+	key, value, found := strings.Cut(label, "=")
+	if !found || key == "" {
+		return fmt.Errorf("bad label %s; must be in the form key=value", label)
+	}
+	if *lm == nil {
+		*lm = labelMap{}
+	}
+	(*lm)[key] = value
+	return nil
+}
+
+const (
+	jsonFormat     = "json"
+	yamlFormat     = "yaml"
+	textFormat     = "text"
+	markdownFormat = "md"
+	csvFormat      = "csv"
+	dotFormat      = "dot"
+	mermaidFormat  = "mermaid"
+	htmlFormat     = "html"
+)
+
 const (
-	jsonFormat = "json"
-	yamlFormat = "yaml"
+	explainCommand = "explain"
+	diffCommand    = "diff"
+	gapsCommand    = "gaps"
+)
+
+const (
+	connectionsOutputKind = "connections"
+	netpolsOutputKind     = "netpols"
+	istioOutputKind       = "istio"
+	ciliumOutputKind      = "cilium"
+	calicoOutputKind      = "calico"
+	egressFirewallKind    = "egressfirewall"
+	egressNetpolKind      = "egressnetworkpolicy"
+	matrixOutputKind      = "matrix"
 )
 
 type inArgs struct {
+	Command      string // empty, unless a command (e.g., "explain") is given as the first argument
 	DirPaths     pathList
+	BaseDirPaths pathList
+	HeadDirPaths pathList
 	OutputFile   *string
 	OutputFormat *string
 	DNSPort      *int
+	ProbeCIDRs   cidrList
+	IngressNs    *string
+	IngressPods  labelMap
+	GwClasses    stringList
 	SynthNetpols *bool
+	OutputKind   *string
+	DNSVisible   *bool
+	BaselineANP  *bool
+	ANPAllowDNS  *bool
+	MonitoringNs *string
+	MinSelectors *bool
+	IgnoredLbls  stringList
+	Provenance   *bool
+	Workload     *string
+	WorkloadRef  []string // the namespace, kind and name of the workload to explain
 	Quiet        *bool
 	Verbose      *bool
 }
 
 func parseInArgs(cmdlineArgs []string) (*inArgs, error) {
 	args := inArgs{}
+	if len(cmdlineArgs) > 0 && slices.Contains([]string{explainCommand, diffCommand, gapsCommand}, cmdlineArgs[0]) {
+		args.Command, cmdlineArgs = cmdlineArgs[0], cmdlineArgs[1:]
+	}
 	flagset := flag.NewFlagSet("cluster-topology-analyzer", flag.ContinueOnError)
 	flagset.Var(&args.DirPaths, "dirpath", "input directory path")
+	flagset.Var(&args.BaseDirPaths, "base", "input directory path of the base revision (diff command only)")
+	flagset.Var(&args.HeadDirPaths, "head", "input directory path of the head revision (diff command only)")
 	args.OutputFile = flagset.String("outputfile", "", "file path to store results")
-	args.OutputFormat = flagset.String("format", jsonFormat, "output format; must be either \"json\" or \"yaml\"")
+	args.OutputFormat = flagset.String("format", jsonFormat, "output format: \"json\" or \"yaml\"; "+
+		"connections also \"dot\", \"mermaid\" or \"html\"; matrix also \"text\", \"csv\" or \"md\"; "+
+		"explain also \"text\" (its default); diff and gaps also \"text\" (their default) or \"md\"")
 	args.SynthNetpols = flagset.Bool("netpols", false, "whether to synthesize NetworkPolicies to allow only the discovered connections")
+	args.OutputKind = flagset.String("output-kind", connectionsOutputKind,
+		"kind of output; must be either \"connections\", \"netpols\" (K8s NetworkPolicies), \"istio\" (Istio AuthorizationPolicies), "+
+			"\"cilium\" (CiliumNetworkPolicies), \"calico\" (Calico NetworkPolicies and a GlobalNetworkPolicy), "+
+			"\"egressfirewall\" (OVN-Kubernetes EgressFirewalls), \"egressnetworkpolicy\" (OpenShift SDN EgressNetworkPolicies) "+
+			"or \"matrix\" (the ports on which each workload may connect to each workload under the synthesized NetworkPolicies)")
+	args.DNSVisible = flagset.Bool("dnsvisibility", false,
+		"whether synthesized CiliumNetworkPolicies should pass DNS traffic through Cilium's DNS proxy")
+	args.BaselineANP = flagset.Bool("baselineadminpolicy", false,
+		"whether to replace the per-namespace default-deny NetworkPolicies with a single BaselineAdminNetworkPolicy")
+	args.ANPAllowDNS = flagset.Bool("adminallowdns", false, "whether to add an AdminNetworkPolicy which always allows egress DNS traffic")
+	args.MonitoringNs = flagset.String("monitoringnamespace", "",
+		"namespace of the monitoring pods; adds an AdminNetworkPolicy which always allows ingress traffic from this namespace")
+	args.MinSelectors = flagset.Bool("minimalselectors", false,
+		"whether synthesized policies should select pods using the smallest label subset that tells the workloads apart")
+	flagset.Var(&args.IgnoredLbls, "ignorepodlabel", "volatile pod label (key) to leave out of pod selectors in synthesized policies "+
+		"(can be specified multiple times)")
+	args.Provenance = flagset.Bool("provenance", false,
+		"whether synthesized NetworkPolicies should carry annotations explaining why each of their rules is required")
+	args.Workload = flagset.String("workload", "", "the workload to explain, in the form namespace/kind/name (explain command only)")
 	args.DNSPort = flagset.Int("dnsport", analyzer.DefaultDNSPort, "DNS port to be used in egress rules of synthesized NetworkPolicies")
+	flagset.Var(&args.ProbeCIDRs, "probecidr", "CIDR from which kubelet probes should be allowed in synthesized NetworkPolicies")
+	args.IngressNs = flagset.String("ingressnamespace", "", "namespace of the ingress-controller pods, which are the only source of "+
+		"traffic to services exposed by Ingress, Route or Gateway API resources in synthesized NetworkPolicies")
+	flagset.Var(&args.IngressPods, "ingresspodlabel", "label (key=value) of the ingress-controller pods (can be specified multiple times)")
+	flagset.Var(&args.GwClasses, "gatewayclass", "GatewayClass whose Gateways run dedicated pods in their own namespace, "+
+		"labeled with the Gateway's name (can be specified multiple times)")
 	args.Quiet = flagset.Bool("q", false, "runs quietly, reports only severe errors and results")
 	args.Verbose = flagset.Bool("v", false, "runs with more informative messages printed to log")
 	err := flagset.Parse(cmdlineArgs)
@@ -54,18 +172,98 @@ func parseInArgs(cmdlineArgs []string) (*inArgs, error) {
 		return nil, err
 	}
 
-	if len(args.DirPaths) == 0 {
+	if err = validateInArgs(&args, flagset); err != nil {
 		flagset.PrintDefaults()
-		return nil, fmt.Errorf("missing parameter: dirpath")
+		return nil, err
 	}
+	return &args, nil
+}
+
+func validateInArgs(args *inArgs, flagset *flag.FlagSet) error {
 	if *args.Quiet && *args.Verbose {
-		flagset.PrintDefaults()
-		return nil, fmt.Errorf("-q and -v cannot be specified together")
+		return fmt.Errorf("-q and -v cannot be specified together")
+	}
+	switch args.Command {
+	case explainCommand:
+		return parseExplainArgs(args, flagset)
+	case diffCommand:
+		return parseDiffArgs(args, flagset)
+	case gapsCommand:
+		return parseGapsArgs(args, flagset)
+	}
+
+	if len(args.DirPaths) == 0 {
+		return fmt.Errorf("missing parameter: dirpath")
+	}
+	switch *args.OutputKind {
+	case connectionsOutputKind:
+		if *args.SynthNetpols {
+			*args.OutputKind = netpolsOutputKind
+			break
+		}
+		if !slices.Contains([]string{jsonFormat, yamlFormat, dotFormat, mermaidFormat, htmlFormat}, *args.OutputFormat) {
+			return fmt.Errorf("wrong output format %s; must be either json, yaml, dot, mermaid or html", *args.OutputFormat)
+		}
+		return nil
+	case matrixOutputKind:
+		if !slices.Contains([]string{jsonFormat, yamlFormat, textFormat, csvFormat, markdownFormat}, *args.OutputFormat) {
+			return fmt.Errorf("wrong output format %s; must be either json, yaml, text, csv or md", *args.OutputFormat)
+		}
+		return nil
+	case netpolsOutputKind, istioOutputKind, ciliumOutputKind, calicoOutputKind, egressFirewallKind, egressNetpolKind:
+	default:
+		return fmt.Errorf("wrong output kind %s; must be either connections, netpols, istio, cilium, calico, egressfirewall, "+
+			"egressnetworkpolicy or matrix", *args.OutputKind)
 	}
+
 	if *args.OutputFormat != jsonFormat && *args.OutputFormat != yamlFormat {
-		flagset.PrintDefaults()
-		return nil, fmt.Errorf("wrong output format %s; must be either json or yaml", *args.OutputFormat)
+		return fmt.Errorf("wrong output format %s; must be either json or yaml", *args.OutputFormat)
 	}
+	return nil
+}
 
-	return &args, nil
+// setCommandOutputFormat sets the output format of a command to text, unless specified, and checks that it is one of the given formats
+func setCommandOutputFormat(args *inArgs, flagset *flag.FlagSet, formats ...string) error {
+	formatSet := false
+	flagset.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+	if !formatSet {
+		*args.OutputFormat = textFormat
+	}
+	if !slices.Contains(formats, *args.OutputFormat) {
+		return fmt.Errorf("wrong output format %s; must be either %s", *args.OutputFormat, strings.Join(formats, ", "))
+	}
+	return nil
+}
+
+// parseExplainArgs validates the arguments of the explain command
+func parseExplainArgs(args *inArgs, flagset *flag.FlagSet) error {
+	if len(args.DirPaths) == 0 {
+		return fmt.Errorf("missing parameter: dirpath")
+	}
+	if err := setCommandOutputFormat(args, flagset, textFormat, jsonFormat, yamlFormat); err != nil {
+		return err
+	}
+
+	const workloadRefParts = 3
+	args.WorkloadRef = strings.Split(*args.Workload, "/")
+	if len(args.WorkloadRef) != workloadRefParts || slices.Contains(args.WorkloadRef[1:], "") {
+		return fmt.Errorf("bad workload %q; must be in the form namespace/kind/name", *args.Workload)
+	}
+	return nil
+}
+
+// parseDiffArgs validates the arguments of the diff command
+func parseDiffArgs(args *inArgs, flagset *flag.FlagSet) error {
+	if len(args.BaseDirPaths) == 0 || len(args.HeadDirPaths) == 0 {
+		return fmt.Errorf("missing parameter: both base and head must be specified")
+	}
+	return setCommandOutputFormat(args, flagset, textFormat, markdownFormat, jsonFormat, yamlFormat)
+}
+
+// parseGapsArgs validates the arguments of the gaps command
+func parseGapsArgs(args *inArgs, flagset *flag.FlagSet) error {
+	if len(args.DirPaths) == 0 {
+		return fmt.Errorf("missing parameter: dirpath")
+	}
+	return setCommandOutputFormat(args, flagset, textFormat, markdownFormat, jsonFormat, yamlFormat)
 }
@@ -0,0 +1,193 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	core "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	adminPolicyAPIVersion      = "policy.networking.k8s.io/v1alpha1"
+	adminPolicyKind            = "AdminNetworkPolicy"
+	baselineAdminPolicyKind    = "BaselineAdminNetworkPolicy"
+	baselineAdminPolicyName    = "default" // the BaselineAdminNetworkPolicy is a singleton, which must be named "default"
+	adminPolicyActionAllow     = "Allow"
+	adminPolicyActionDeny      = "Deny"
+	adminDNSPolicyPriority     = 10
+	adminMonitorPolicyPriority = 11
+	anyIPv6CIDR                = "::/0"
+)
+
+// AdminPolicy is an AdminNetworkPolicy or a BaselineAdminNetworkPolicy (policy.networking.k8s.io/v1alpha1),
+// limited to the fields used by the analyzer. Both are cluster-scoped.
+type AdminPolicy struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AdminPolicySpec `json:"spec"`
+}
+
+// AdminPolicySpec is the spec of an admin policy. Priority is only relevant for AdminNetworkPolicies (lower is evaluated first).
+type AdminPolicySpec struct {
+	Priority *int32             `json:"priority,omitempty"`
+	Subject  AdminPolicySubject `json:"subject"`
+	Ingress  []AdminPolicyRule  `json:"ingress,omitempty"`
+	Egress   []AdminPolicyRule  `json:"egress,omitempty"`
+}
+
+// AdminPolicySubject selects the pods to which an admin policy applies by their namespace
+type AdminPolicySubject struct {
+	Namespaces *metaV1.LabelSelector `json:"namespaces,omitempty"`
+}
+
+// AdminPolicyRule applies an action to traffic from (ingress) or to (egress) the given peers on the given ports
+type AdminPolicyRule struct {
+	Name   string            `json:"name"`
+	Action string            `json:"action"`
+	From   []AdminPolicyPeer `json:"from,omitempty"`
+	To     []AdminPolicyPeer `json:"to,omitempty"`
+	Ports  []AdminPolicyPort `json:"ports,omitempty"` // empty means all ports
+}
+
+// AdminPolicyPeer selects namespaces, pods (in selected namespaces) or networks (egress only)
+type AdminPolicyPeer struct {
+	Namespaces *metaV1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *AdminPolicyPods      `json:"pods,omitempty"`
+	Networks   []string              `json:"networks,omitempty"`
+}
+
+// AdminPolicyPods selects pods by their labels and by the labels of their namespace
+//
+//nolint:tagliatelle // the AdminNetworkPolicy API uses camelCase field names
+type AdminPolicyPods struct {
+	NamespaceSelector metaV1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       metaV1.LabelSelector `json:"podSelector"`
+}
+
+// AdminPolicyPort is either a port number (with a protocol) or a named port
+//
+//nolint:tagliatelle // the AdminNetworkPolicy API uses camelCase field names
+type AdminPolicyPort struct {
+	PortNumber *AdminPolicyPortNumber `json:"portNumber,omitempty"`
+	NamedPort  string                 `json:"namedPort,omitempty"`
+}
+
+// AdminPolicyPortNumber is a port number and a protocol
+type AdminPolicyPortNumber struct {
+	Protocol core.Protocol `json:"protocol"`
+	Port     int32         `json:"port"`
+}
+
+// PolicyList is a list of K8s NetworkPolicies and admin policies, which can be applied using kubectl
+type PolicyList struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []any `json:"items"`
+}
+
+// PolicyListFromSlices converts slices of K8s NetworkPolicies and of admin policies to a list containing all the policies
+func PolicyListFromSlices(netpols []*network.NetworkPolicy, adminPolicies []*AdminPolicy) PolicyList {
+	items := []any{}
+	for _, policy := range adminPolicies {
+		items = append(items, *policy)
+	}
+	for _, netpol := range netpols {
+		items = append(items, *netpol)
+	}
+	return PolicyList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+// synthAdminPolicies generates the admin policies, requested by the synthesizer's options, for the namespaces of the given resources
+func (ps *PoliciesSynthesizer) synthAdminPolicies(resources []*Resource) []*AdminPolicy {
+	if !ps.baselineAdminPolicy && !ps.adminAllowDNS && ps.monitoringNamespace == "" {
+		return nil
+	}
+
+	subject := AdminPolicySubject{Namespaces: workloadNamespacesSelector(resources)}
+	policies := []*AdminPolicy{}
+	if ps.adminAllowDNS {
+		policies = append(policies, ps.adminDNSPolicy(subject))
+	}
+	if ps.monitoringNamespace != "" {
+		policies = append(policies, ps.adminMonitoringPolicy(subject))
+	}
+	if ps.baselineAdminPolicy {
+		policies = append(policies, baselineDenyPolicy(subject))
+	}
+	return policies
+}
+
+// workloadNamespacesSelector returns a namespace selector, selecting the namespaces of the given resources
+func workloadNamespacesSelector(resources []*Resource) *metaV1.LabelSelector {
+	requirement := metaV1.LabelSelectorRequirement{
+		Key: namespaceNameLabel, Operator: metaV1.LabelSelectorOpIn, Values: sortedWorkloadNamespaces(resources),
+	}
+	return &metaV1.LabelSelector{MatchExpressions: []metaV1.LabelSelectorRequirement{requirement}}
+}
+
+// adminDNSPolicy generates an AdminNetworkPolicy, which always allows egress DNS traffic to kube-dns
+func (ps *PoliciesSynthesizer) adminDNSPolicy(subject AdminPolicySubject) *AdminPolicy {
+	kubeDNS := AdminPolicyPods{
+		NamespaceSelector: metaV1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "kube-system"}},
+		PodSelector:       metaV1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+	}
+	ports := []AdminPolicyPort{
+		{PortNumber: &AdminPolicyPortNumber{Protocol: core.ProtocolUDP, Port: ps.dnsPort.IntVal}},
+		{PortNumber: &AdminPolicyPortNumber{Protocol: core.ProtocolTCP, Port: ps.dnsPort.IntVal}},
+	}
+	if ps.dnsPort.Type == intstr.String {
+		ports = []AdminPolicyPort{{NamedPort: ps.dnsPort.StrVal}}
+	}
+	priority := int32(adminDNSPolicyPriority)
+	return &AdminPolicy{
+		TypeMeta:   metaV1.TypeMeta{Kind: adminPolicyKind, APIVersion: adminPolicyAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{Name: "allow-dns"},
+		Spec: AdminPolicySpec{
+			Priority: &priority,
+			Subject:  subject,
+			Egress:   []AdminPolicyRule{{Name: "allow-dns", Action: adminPolicyActionAllow, To: []AdminPolicyPeer{{Pods: &kubeDNS}}, Ports: ports}},
+		},
+	}
+}
+
+// adminMonitoringPolicy generates an AdminNetworkPolicy, which always allows ingress traffic from the monitoring namespace
+func (ps *PoliciesSynthesizer) adminMonitoringPolicy(subject AdminPolicySubject) *AdminPolicy {
+	monitoring := metaV1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: ps.monitoringNamespace}}
+	priority := int32(adminMonitorPolicyPriority)
+	return &AdminPolicy{
+		TypeMeta:   metaV1.TypeMeta{Kind: adminPolicyKind, APIVersion: adminPolicyAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{Name: "allow-monitoring"},
+		Spec: AdminPolicySpec{
+			Priority: &priority,
+			Subject:  subject,
+			Ingress: []AdminPolicyRule{
+				{Name: "allow-monitoring", Action: adminPolicyActionAllow, From: []AdminPolicyPeer{{Namespaces: &monitoring}}},
+			},
+		},
+	}
+}
+
+// baselineDenyPolicy generates a BaselineAdminNetworkPolicy, which denies all traffic not allowed by NetworkPolicies.
+// Note that unlike the per-namespace default-deny NetworkPolicy it replaces, it cannot deny ingress traffic from outside
+// the cluster: the v1alpha1 API only supports "networks" peers in egress rules, so ingress is only denied from pods.
+// Such traffic is only denied to the pods which are selected by one of the generated NetworkPolicies.
+func baselineDenyPolicy(subject AdminPolicySubject) *AdminPolicy {
+	allNamespaces := AdminPolicyPeer{Namespaces: &metaV1.LabelSelector{}}
+	allNetworks := AdminPolicyPeer{Networks: []string{anyIPv4CIDR, anyIPv6CIDR}}
+	return &AdminPolicy{
+		TypeMeta:   metaV1.TypeMeta{Kind: baselineAdminPolicyKind, APIVersion: adminPolicyAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{Name: baselineAdminPolicyName},
+		Spec: AdminPolicySpec{
+			Subject: subject,
+			Ingress: []AdminPolicyRule{{Name: "default-deny-ingress", Action: adminPolicyActionDeny, From: []AdminPolicyPeer{allNamespaces}}},
+			Egress: []AdminPolicyRule{
+				{Name: "default-deny-egress", Action: adminPolicyActionDeny, To: []AdminPolicyPeer{allNamespaces, allNetworks}},
+			},
+		},
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	authzPolicyAPIVersion  = "security.istio.io/v1"
+	authzPolicyKind        = "AuthorizationPolicy"
+	authzPolicyActionAllow = "ALLOW"
+
+	DefaultIstioTrustDomain = "cluster.local" // DefaultIstioTrustDomain is the default trust domain of Istio workload identities
+	defaultServiceAccount   = "default"
+)
+
+// AuthorizationPolicy is an Istio AuthorizationPolicy (security.istio.io/v1), limited to the fields used by the analyzer
+type AuthorizationPolicy struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AuthorizationPolicySpec `json:"spec"`
+}
+
+// AuthorizationPolicySpec is the spec of an Istio AuthorizationPolicy.
+// An empty spec denies all requests to the workloads in the policy's namespace.
+type AuthorizationPolicySpec struct {
+	Selector *metaV1.LabelSelector `json:"selector,omitempty"` // only MatchLabels is used, as in Istio's WorkloadSelector
+	Action   string                `json:"action,omitempty"`
+	Rules    []AuthorizationRule   `json:"rules,omitempty"`
+}
+
+// AuthorizationRule matches requests from a list of sources to a list of operations
+type AuthorizationRule struct {
+	From []AuthorizationRuleFrom `json:"from,omitempty"` // empty means any source
+	To   []AuthorizationRuleTo   `json:"to,omitempty"`   // empty means any operation
+}
+
+// AuthorizationRuleFrom holds the source of requests matched by an AuthorizationRule
+type AuthorizationRuleFrom struct {
+	Source AuthorizationSource `json:"source"`
+}
+
+// AuthorizationSource specifies the identities (principals) or namespaces of request sources
+type AuthorizationSource struct {
+	Principals []string `json:"principals,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// AuthorizationRuleTo holds the operation of requests matched by an AuthorizationRule
+type AuthorizationRuleTo struct {
+	Operation AuthorizationOperation `json:"operation"`
+}
+
+// AuthorizationOperation specifies the destination ports of requests
+type AuthorizationOperation struct {
+	Ports []string `json:"ports,omitempty"`
+}
+
+// AuthorizationPolicyList is a list of Istio AuthorizationPolicies, which can be applied using kubectl
+type AuthorizationPolicyList struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []AuthorizationPolicy `json:"items"`
+}
+
+// AuthorizationPolicyListFromSlice converts a slice of Istio AuthorizationPolicies to a list containing all the policies in the slice
+func AuthorizationPolicyListFromSlice(policies []*AuthorizationPolicy) AuthorizationPolicyList {
+	items := []AuthorizationPolicy{}
+	for _, policy := range policies {
+		items = append(items, *policy)
+	}
+	return AuthorizationPolicyList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+func (policy *AuthorizationPolicy) addRule(rule *AuthorizationRule) {
+	for i := range policy.Spec.Rules {
+		if reflect.DeepEqual(policy.Spec.Rules[i], *rule) {
+			return
+		}
+	}
+	policy.Spec.Rules = append(policy.Spec.Rules, *rule)
+}
+
+// addPortsRule adds a rule allowing requests from the given sources to the given ports, unless no port is given
+// (e.g., none of the target ports could be resolved) or none of the ports can be authorized (see toAuthzRuleTo)
+func (policy *AuthorizationPolicy) addPortsRule(from []AuthorizationRuleFrom, ports []network.NetworkPolicyPort) {
+	if len(ports) == 0 {
+		return
+	}
+	if to, ok := toAuthzRuleTo(ports); ok {
+		policy.addRule(&AuthorizationRule{From: from, To: to})
+	}
+}
+
+// synthAuthzPolicies generates an ALLOW AuthorizationPolicy for each workload, allowing only the requests of the given connections,
+// and an allow-nothing AuthorizationPolicy for each workload namespace
+func (ps *PoliciesSynthesizer) synthAuthzPolicies(resources []*Resource, connections []*Connections) []*AuthorizationPolicy {
+	policies := map[string]*AuthorizationPolicy{}
+	selectors := ps.podSelectors(resources)
+	policyNames := workloadPolicyNames(resources)
+	for _, conn := range connections {
+		if conn.Target == nil || conn.Target.Resource.Name == "" {
+			continue
+		}
+		targetID := workloadIdentity(conn.Target)
+		policy := findOrAddAuthzPolicy(conn.Target, policies, selectors[targetID], policyNames[targetID])
+		if conn.Source != nil && conn.Source.Resource.Name != "" {
+			ports := toNetpolPorts(connectionPorts(conn), conn.Target, false)
+			source := AuthorizationSource{Principals: []string{ps.workloadPrincipal(conn.Source)}}
+			policy.addPortsRule([]AuthorizationRuleFrom{{Source: source}}, ports)
+			continue
+		}
+
+		if conn.Link.Resource.ExposeExternally {
+			ports := toNetpolPorts(conn.Link.Resource.Network, conn.Target, false)
+			policy.addPortsRule(nil, ports) // allowing requests from all sources
+			continue
+		}
+		sources, portsPerSource := exposedPortsBySource(conn.Link.Resource.Network)
+		for i := range sources {
+			policy.addPortsRule(ps.ingressSourceAuthzFrom(&sources[i], resources), toNetpolPorts(portsPerSource[i], conn.Target, false))
+		}
+	}
+
+	retSlice := []*AuthorizationPolicy{}
+	for _, policy := range policies {
+		retSlice = append(retSlice, policy)
+	}
+	sort.Slice(retSlice, func(i, j int) bool {
+		if retSlice[i].Name != retSlice[j].Name {
+			return retSlice[i].Name < retSlice[j].Name
+		}
+		return retSlice[i].Namespace < retSlice[j].Namespace
+	})
+	return append(retSlice, getNsAllowNothingAuthzPolicies(resources)...)
+}
+
+// findOrAddAuthzPolicy returns the AuthorizationPolicy of the given workload, selecting the workload's pods using the given selector.
+// As Istio's WorkloadSelector only supports matching labels, all the workload's labels are used if the selector is nil
+// or has match expressions. A new policy is named using the given base name.
+func findOrAddAuthzPolicy(resource *Resource, policies map[string]*AuthorizationPolicy,
+	selector *metaV1.LabelSelector, policyName string) *AuthorizationPolicy {
+	if policy, found := policies[workloadIdentity(resource)]; found {
+		return policy
+	}
+	selectorLabels := resource.Resource.Labels
+	if selector != nil && len(selector.MatchExpressions) == 0 {
+		selectorLabels = selector.MatchLabels
+	}
+	if policyName == "" {
+		policyName = resource.Resource.Name
+	}
+	policy := AuthorizationPolicy{
+		TypeMeta: metaV1.TypeMeta{
+			Kind:       authzPolicyKind,
+			APIVersion: authzPolicyAPIVersion,
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      policyName + "-authz",
+			Namespace: resource.Resource.Namespace,
+		},
+		Spec: AuthorizationPolicySpec{
+			Selector: &metaV1.LabelSelector{MatchLabels: selectorLabels},
+			Action:   authzPolicyActionAllow,
+		},
+	}
+	policies[workloadIdentity(resource)] = &policy
+	return &policy
+}
+
+// ingressSourceAuthzFrom returns the sources of an AuthorizationRule, allowing requests from the given ingress source.
+// Istio cannot match requests by the labels of their source pods, so if the ingress source has pod labels,
+// requests are allowed from the principals of the scanned workloads whose pods have these labels. If no such workload
+// was scanned (e.g., an ingress controller deployed separately), the rule is widened to all the pods in the ingress-source
+// namespace (or to all sources, if the ingress source has no namespace).
+func (ps *PoliciesSynthesizer) ingressSourceAuthzFrom(source *IngressSource, resources []*Resource) []AuthorizationRuleFrom {
+	principals := []string{}
+	if len(source.PodLabels) > 0 {
+		for _, res := range resources {
+			if (source.Namespace == "" || namespaceOrDefault(source.Namespace) == namespaceOrDefault(res.Resource.Namespace)) &&
+				k8slabels.SelectorFromSet(source.PodLabels).Matches(k8slabels.Set(res.Resource.Labels)) {
+				principals = appendUnique(principals, ps.workloadPrincipal(res))
+			}
+		}
+	}
+	switch {
+	case len(principals) > 0:
+		return []AuthorizationRuleFrom{{Source: AuthorizationSource{Principals: principals}}}
+	case source.Namespace != "":
+		return []AuthorizationRuleFrom{{Source: AuthorizationSource{Namespaces: []string{source.Namespace}}}}
+	}
+	return nil // any source
+}
+
+// workloadPrincipal returns the Istio identity of the given workload, based on its namespace and ServiceAccount
+func (ps *PoliciesSynthesizer) workloadPrincipal(resource *Resource) string {
+	namespace := namespaceOrDefault(resource.Resource.Namespace)
+	serviceAccount := resource.Resource.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = defaultServiceAccount
+	}
+	return fmt.Sprintf("%s/ns/%s/sa/%s", ps.istioTrustDomain, namespace, serviceAccount)
+}
+
+// toAuthzRuleTo returns the operations allowing requests to the given ports (all ports, if none is given).
+// Istio only authorizes TCP traffic, and only by port number, so non-TCP ports and named ports which were not resolved
+// to container port numbers are skipped. The returned bool is false if all the given ports were skipped.
+func toAuthzRuleTo(ports []network.NetworkPolicyPort) ([]AuthorizationRuleTo, bool) {
+	if len(ports) == 0 {
+		return nil, true
+	}
+	operation := AuthorizationOperation{}
+	for i := range ports {
+		if *ports[i].Protocol != core.ProtocolTCP || ports[i].Port.Type != intstr.Int {
+			continue
+		}
+		operation.Ports = appendUnique(operation.Ports, ports[i].Port.String())
+	}
+	if len(operation.Ports) == 0 {
+		return nil, false
+	}
+	return []AuthorizationRuleTo{{Operation: operation}}, true
+}
+
+// Generate an allow-nothing AuthorizationPolicy for each namespace of the given resources
+func getNsAllowNothingAuthzPolicies(resources []*Resource) []*AuthorizationPolicy {
+	policies := []*AuthorizationPolicy{}
+	for _, namespace := range workloadNamespaces(resources) {
+		policyName := "allow-nothing-in-namespace"
+		if namespace != "" {
+			policyName += "-" + namespace
+		}
+		policies = append(policies, &AuthorizationPolicy{
+			TypeMeta:   metaV1.TypeMeta{Kind: authzPolicyKind, APIVersion: authzPolicyAPIVersion},
+			ObjectMeta: metaV1.ObjectMeta{Name: policyName, Namespace: namespace},
+			Spec:       AuthorizationPolicySpec{}, // an empty spec denies all requests
+		})
+	}
+	return policies
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	calicoGroup               = "projectcalico.org"
+	calicoCRDGroup            = "crd.projectcalico.org" // the group of the CRDs backing Calico's API (without the API server)
+	calicoAPIVersion          = calicoGroup + "/v3"
+	calicoPolicyKind          = "NetworkPolicy"
+	calicoGlobalPolicyKind    = "GlobalNetworkPolicy"
+	calicoNamespaceLabel      = "projectcalico.org/namespace" // the label Calico puts on endpoints, holding their namespace
+	calicoActionAllow         = "Allow"
+	calicoActionDeny          = "Deny"
+	calicoAllSelector         = "all()"
+	calicoBaselinePolicyName  = "default-deny"
+	calicoWorkloadPolicyOrder = 100.0
+	calicoBaselinePolicyOrder = 10000.0 // evaluated after all workload policies
+)
+
+// CalicoPolicy is a Calico NetworkPolicy or GlobalNetworkPolicy (projectcalico.org/v3), limited to the fields used by the analyzer
+type CalicoPolicy struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              CalicoPolicySpec `json:"spec"`
+}
+
+// CalicoPolicySpec is the spec of a Calico policy. Policies with a lower order are evaluated first.
+type CalicoPolicySpec struct {
+	Order    float64      `json:"order"`
+	Selector string       `json:"selector"`
+	Types    []string     `json:"types"`
+	Ingress  []CalicoRule `json:"ingress,omitempty"`
+	Egress   []CalicoRule `json:"egress,omitempty"`
+}
+
+// CalicoRule applies an action to traffic with the given protocol, source and destination
+type CalicoRule struct {
+	Action      string            `json:"action"`
+	Protocol    string            `json:"protocol,omitempty"`    // empty means any protocol
+	Source      *CalicoEntityRule `json:"source,omitempty"`      // nil means any source
+	Destination *CalicoEntityRule `json:"destination,omitempty"` // nil means any destination
+}
+
+// CalicoEntityRule matches endpoints by selectors, networks by CIDRs, and ports
+//
+//nolint:tagliatelle // Calico's API uses camelCase field names
+type CalicoEntityRule struct {
+	Selector          string               `json:"selector,omitempty"`
+	NamespaceSelector string               `json:"namespaceSelector,omitempty"`
+	Nets              []string             `json:"nets,omitempty"`
+	Ports             []intstr.IntOrString `json:"ports,omitempty"`
+}
+
+// CalicoPolicyList is a list of Calico policies, which can be applied using kubectl (with the Calico API server installed)
+type CalicoPolicyList struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []CalicoPolicy `json:"items"`
+}
+
+// CalicoPolicyListFromSlice converts a slice of Calico policies to a list containing all the policies in the slice
+func CalicoPolicyListFromSlice(policies []*CalicoPolicy) CalicoPolicyList {
+	items := []CalicoPolicy{}
+	for _, policy := range policies {
+		items = append(items, *policy)
+	}
+	return CalicoPolicyList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+// synthCalicoPolicies generates a Calico NetworkPolicy for each workload, allowing only the given connections,
+// and a single GlobalNetworkPolicy, explicitly denying all other traffic of the workload namespaces
+func (ps *PoliciesSynthesizer) synthCalicoPolicies(resources []*Resource, connections []*Connections) []*CalicoPolicy {
+	deployConnectivity := determineConnectivityPerDeployment(resources, connections, ps.probeSourcePeers(), ps.podSelectors(resources))
+	policies := make([]*CalicoPolicy, 0, len(deployConnectivity)+1)
+	for _, deployConn := range deployConnectivity {
+		ps.addExternalAndDNSEgressRules(deployConn)
+		policies = append(policies, ps.buildCalicoPolicy(deployConn))
+	}
+	return append(policies, ps.getCalicoBaselinePolicy(resources))
+}
+
+func (ps *PoliciesSynthesizer) buildCalicoPolicy(deployConn *deploymentConnectivity) *CalicoPolicy {
+	policy := CalicoPolicy{
+		TypeMeta: metaV1.TypeMeta{Kind: calicoPolicyKind, APIVersion: calicoAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      deployConn.policyName + "-netpol",
+			Namespace: deployConn.Resource.Resource.Namespace,
+		},
+		Spec: CalicoPolicySpec{
+			Order:    ps.calicoWorkloadOrder,
+			Selector: toCalicoSelector(deployConn.podSelector),
+			Types:    []string{string(network.PolicyTypeIngress), string(network.PolicyTypeEgress)},
+		},
+	}
+	for i := range deployConn.ingressConns {
+		rule := &deployConn.ingressConns[i]
+		policy.Spec.Ingress = append(policy.Spec.Ingress, toCalicoRules(rule.From, rule.Ports, true)...)
+	}
+	for i := range deployConn.egressConns {
+		rule := &deployConn.egressConns[i]
+		policy.Spec.Egress = append(policy.Spec.Egress, toCalicoRules(rule.To, rule.Ports, false)...)
+	}
+	return &policy
+}
+
+// toCalicoRules converts a NetworkPolicy rule into Calico Allow rules - one for each peer and protocol
+func toCalicoRules(peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort, ingress bool) []CalicoRule {
+	peerRules := []*CalicoEntityRule{nil} // a nil entity rule matches all peers
+	if len(peers) > 0 {
+		peerRules = []*CalicoEntityRule{}
+		for i := range peers {
+			peerRules = append(peerRules, toCalicoEntityRule(&peers[i]))
+		}
+	}
+
+	protocols, portsPerProtocol := calicoPortsByProtocol(ports)
+	rules := []CalicoRule{}
+	for _, peerRule := range peerRules {
+		for i, protocol := range protocols {
+			rule := CalicoRule{Action: calicoActionAllow, Protocol: protocol}
+			var portsRule *CalicoEntityRule
+			if len(portsPerProtocol[i]) > 0 {
+				portsRule = &CalicoEntityRule{Ports: portsPerProtocol[i]}
+			}
+			if ingress {
+				rule.Source = peerRule
+				rule.Destination = portsRule
+			} else {
+				rule.Destination = mergeCalicoEntityRules(peerRule, portsRule)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// calicoPortsByProtocol groups the given NetworkPolicy ports by their protocol (Calico rules have a single protocol).
+// If no ports are given, a single empty protocol (any protocol) is returned.
+func calicoPortsByProtocol(ports []network.NetworkPolicyPort) ([]string, [][]intstr.IntOrString) {
+	if len(ports) == 0 {
+		return []string{""}, [][]intstr.IntOrString{nil}
+	}
+	protocols := []string{}
+	portsPerProtocol := [][]intstr.IntOrString{}
+	for i := range ports {
+		protocol := string(core.ProtocolTCP)
+		if ports[i].Protocol != nil {
+			protocol = string(*ports[i].Protocol)
+		}
+		idx := slices.Index(protocols, protocol)
+		if idx < 0 {
+			protocols = append(protocols, protocol)
+			portsPerProtocol = append(portsPerProtocol, nil)
+			idx = len(protocols) - 1
+		}
+		if ports[i].Port != nil {
+			portsPerProtocol[idx] = append(portsPerProtocol[idx], *ports[i].Port)
+		}
+	}
+	return protocols, portsPerProtocol
+}
+
+// toCalicoEntityRule converts a NetworkPolicy peer into an equivalent Calico entity rule
+func toCalicoEntityRule(peer *network.NetworkPolicyPeer) *CalicoEntityRule {
+	if peer.IPBlock != nil {
+		return &CalicoEntityRule{Nets: []string{peer.IPBlock.CIDR}}
+	}
+	entityRule := CalicoEntityRule{}
+	if peer.PodSelector != nil {
+		entityRule.Selector = toCalicoSelector(peer.PodSelector)
+	}
+	if peer.NamespaceSelector != nil {
+		entityRule.NamespaceSelector = toCalicoSelector(peer.NamespaceSelector)
+	}
+	return &entityRule
+}
+
+func mergeCalicoEntityRules(peerRule, portsRule *CalicoEntityRule) *CalicoEntityRule {
+	if peerRule == nil {
+		return portsRule
+	}
+	if portsRule == nil {
+		return peerRule
+	}
+	merged := *peerRule
+	merged.Ports = portsRule.Ports
+	return &merged
+}
+
+// toCalicoSelector converts a label selector into a Calico selector expression (e.g., "app == 'web' && tier in {'back', 'front'}")
+func toCalicoSelector(selector *metaV1.LabelSelector) string {
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		return calicoAllSelector
+	}
+	terms := make([]string, 0, len(selector.MatchLabels))
+	for key, value := range selector.MatchLabels {
+		terms = append(terms, fmt.Sprintf("%s == '%s'", key, value))
+	}
+	slices.Sort(terms)
+	for i := range selector.MatchExpressions {
+		terms = append(terms, toCalicoSelectorTerm(&selector.MatchExpressions[i]))
+	}
+	return strings.Join(terms, " && ")
+}
+
+func toCalicoSelectorTerm(requirement *metaV1.LabelSelectorRequirement) string {
+	quoted := make([]string, 0, len(requirement.Values))
+	for _, value := range requirement.Values {
+		quoted = append(quoted, fmt.Sprintf("'%s'", value))
+	}
+	values := strings.Join(quoted, ", ")
+	switch requirement.Operator {
+	case metaV1.LabelSelectorOpIn:
+		return fmt.Sprintf("%s in {%s}", requirement.Key, values)
+	case metaV1.LabelSelectorOpNotIn:
+		return fmt.Sprintf("%s not in {%s}", requirement.Key, values)
+	case metaV1.LabelSelectorOpDoesNotExist:
+		return fmt.Sprintf("!has(%s)", requirement.Key)
+	default:
+		return fmt.Sprintf("has(%s)", requirement.Key)
+	}
+}
+
+// getCalicoBaselinePolicy generates a GlobalNetworkPolicy, which is evaluated after all workload policies (given a higher order),
+// and explicitly denies all traffic to and from the endpoints in the namespaces of the given resources
+func (ps *PoliciesSynthesizer) getCalicoBaselinePolicy(resources []*Resource) *CalicoPolicy {
+	namespaces := sortedWorkloadNamespaces(resources)
+	quoted := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		quoted = append(quoted, fmt.Sprintf("'%s'", namespace))
+	}
+
+	return &CalicoPolicy{
+		TypeMeta:   metaV1.TypeMeta{Kind: calicoGlobalPolicyKind, APIVersion: calicoAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{Name: calicoBaselinePolicyName},
+		Spec: CalicoPolicySpec{
+			Order:    ps.calicoBaselineOrder,
+			Selector: fmt.Sprintf("%s in {%s}", calicoNamespaceLabel, strings.Join(quoted, ", ")),
+			Types:    []string{string(network.PolicyTypeIngress), string(network.PolicyTypeEgress)},
+			Ingress:  []CalicoRule{{Action: calicoActionDeny}},
+			Egress:   []CalicoRule{{Action: calicoActionDeny}},
+		},
+	}
+}
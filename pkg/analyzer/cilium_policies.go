@@ -0,0 +1,310 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ciliumPolicyAPIVersion = "cilium.io/v2"
+	ciliumPolicyKind       = "CiliumNetworkPolicy"
+
+	ciliumNamespaceLabel       = "k8s:io.kubernetes.pod.namespace"     // the label Cilium puts on endpoints, holding their namespace
+	ciliumNamespaceLabelPrefix = "k8s:io.cilium.k8s.namespace.labels." // the prefix of endpoint labels, holding their namespace's labels
+
+	ciliumEntityAll     = "all"     // all endpoints, including those outside the cluster
+	ciliumEntityCluster = "cluster" // all endpoints inside the cluster
+	ciliumProtocolAny   = "ANY"
+)
+
+// CiliumNetworkPolicy is a Cilium network policy (cilium.io/v2), limited to the fields used by the analyzer
+type CiliumNetworkPolicy struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              CiliumPolicySpec `json:"spec"`
+}
+
+// CiliumPolicySpec is the spec of a CiliumNetworkPolicy, selecting the endpoints to which its rules apply
+//
+//nolint:tagliatelle // Cilium's API uses camelCase field names
+type CiliumPolicySpec struct {
+	EndpointSelector metaV1.LabelSelector `json:"endpointSelector"`
+	Ingress          []CiliumIngressRule  `json:"ingress,omitempty"`
+	Egress           []CiliumEgressRule   `json:"egress,omitempty"`
+}
+
+// CiliumIngressRule allows traffic from the given endpoints, entities or CIDRs to the given ports.
+// An empty rule allows nothing, but enables default-deny for ingress traffic to the selected endpoints.
+//
+//nolint:tagliatelle // Cilium's API uses camelCase field names
+type CiliumIngressRule struct {
+	FromEndpoints []metaV1.LabelSelector `json:"fromEndpoints,omitempty"`
+	FromEntities  []string               `json:"fromEntities,omitempty"`
+	FromCIDR      []string               `json:"fromCIDR,omitempty"`
+	ToPorts       []CiliumPortRule       `json:"toPorts,omitempty"` // empty means all ports
+}
+
+// CiliumEgressRule allows traffic to the given endpoints, entities, CIDRs or DNS names on the given ports.
+// An empty rule allows nothing, but enables default-deny for egress traffic from the selected endpoints.
+//
+//nolint:tagliatelle // Cilium's API uses camelCase field names
+type CiliumEgressRule struct {
+	ToEndpoints []metaV1.LabelSelector `json:"toEndpoints,omitempty"`
+	ToEntities  []string               `json:"toEntities,omitempty"`
+	ToCIDR      []string               `json:"toCIDR,omitempty"`
+	ToFQDNs     []CiliumFQDNSelector   `json:"toFQDNs,omitempty"`
+	ToPorts     []CiliumPortRule       `json:"toPorts,omitempty"` // empty means all ports
+}
+
+// CiliumFQDNSelector matches DNS names, either exactly or using a pattern with "*" wildcards
+//
+//nolint:tagliatelle // Cilium's API uses camelCase field names
+type CiliumFQDNSelector struct {
+	MatchName    string `json:"matchName,omitempty"`
+	MatchPattern string `json:"matchPattern,omitempty"`
+}
+
+// CiliumPortRule holds a list of ports and optional L7 rules, further restricting the traffic on these ports
+type CiliumPortRule struct {
+	Ports []CiliumPortProtocol `json:"ports"`
+	Rules *CiliumL7Rules       `json:"rules,omitempty"`
+}
+
+// CiliumPortProtocol is a port (number or name) and a protocol
+type CiliumPortProtocol struct {
+	Port     string `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// CiliumL7Rules holds HTTP or DNS rules. Traffic matching these rules is handled by Cilium's L7 proxy.
+type CiliumL7Rules struct {
+	HTTP []CiliumHTTPRule `json:"http,omitempty"`
+	DNS  []CiliumDNSRule  `json:"dns,omitempty"`
+}
+
+// CiliumHTTPRule matches HTTP requests whose path matches the given regular expression
+type CiliumHTTPRule struct {
+	Path string `json:"path,omitempty"`
+}
+
+// CiliumDNSRule matches DNS queries for names which match the given pattern
+//
+//nolint:tagliatelle // Cilium's API uses camelCase field names
+type CiliumDNSRule struct {
+	MatchPattern string `json:"matchPattern"`
+}
+
+// CiliumNetworkPolicyList is a list of CiliumNetworkPolicies, which can be applied using kubectl
+type CiliumNetworkPolicyList struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []CiliumNetworkPolicy `json:"items"`
+}
+
+// CiliumPolicyListFromSlice converts a slice of CiliumNetworkPolicies to a list containing all the policies in the slice
+func CiliumPolicyListFromSlice(policies []*CiliumNetworkPolicy) CiliumNetworkPolicyList {
+	items := []CiliumNetworkPolicy{}
+	for _, policy := range policies {
+		items = append(items, *policy)
+	}
+	return CiliumNetworkPolicyList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+// ciliumPeer holds the Cilium selectors, equivalent to a NetworkPolicy peer
+type ciliumPeer struct {
+	endpoints []metaV1.LabelSelector
+	entities  []string
+	cidrs     []string
+}
+
+// synthCiliumPolicies generates a CiliumNetworkPolicy for each workload, allowing only the given connections,
+// and a default-deny CiliumNetworkPolicy for each workload namespace
+func (ps *PoliciesSynthesizer) synthCiliumPolicies(resources []*Resource, connections []*Connections) []*CiliumNetworkPolicy {
+	deployConnectivity := determineConnectivityPerDeployment(resources, connections, ps.probeSourcePeers(), ps.podSelectors(resources))
+	policies := make([]*CiliumNetworkPolicy, 0, len(deployConnectivity))
+	for _, deployConn := range deployConnectivity {
+		policies = append(policies, ps.buildCiliumPolicy(deployConn))
+	}
+	return append(policies, getNsDefaultDenyCiliumPolicies(resources)...)
+}
+
+func (ps *PoliciesSynthesizer) buildCiliumPolicy(deployConn *deploymentConnectivity) *CiliumNetworkPolicy {
+	namespace := deployConn.Resource.Resource.Namespace
+	policy := CiliumNetworkPolicy{
+		TypeMeta:   metaV1.TypeMeta{Kind: ciliumPolicyKind, APIVersion: ciliumPolicyAPIVersion},
+		ObjectMeta: metaV1.ObjectMeta{Name: deployConn.policyName + "-netpol", Namespace: namespace},
+		Spec:       CiliumPolicySpec{EndpointSelector: *getDeployConnSelector(deployConn)},
+	}
+
+	for i := range deployConn.ingressConns {
+		rule := &deployConn.ingressConns[i]
+		toPorts := toCiliumPortRules(rule.Ports, deployConn.ingressHTTPPaths[i])
+		if len(rule.From) == 0 {
+			policy.Spec.Ingress = append(policy.Spec.Ingress, CiliumIngressRule{FromEntities: []string{ciliumEntityAll}, ToPorts: toPorts})
+		}
+		for j := range rule.From {
+			peer := toCiliumPeer(&rule.From[j], namespace)
+			policy.Spec.Ingress = append(policy.Spec.Ingress,
+				CiliumIngressRule{FromEndpoints: peer.endpoints, FromEntities: peer.entities, FromCIDR: peer.cidrs, ToPorts: toPorts})
+		}
+	}
+
+	for i := range deployConn.egressConns {
+		rule := &deployConn.egressConns[i]
+		toPorts := toCiliumPortRules(rule.Ports, nil)
+		if len(rule.To) == 0 {
+			policy.Spec.Egress = append(policy.Spec.Egress, CiliumEgressRule{ToEntities: []string{ciliumEntityAll}, ToPorts: toPorts})
+		}
+		for j := range rule.To {
+			peer := toCiliumPeer(&rule.To[j], namespace)
+			policy.Spec.Egress = append(policy.Spec.Egress,
+				CiliumEgressRule{ToEndpoints: peer.endpoints, ToEntities: peer.entities, ToCIDR: peer.cidrs, ToPorts: toPorts})
+		}
+	}
+
+	hasFQDNRules := false
+	for _, dest := range deployConn.externalDests {
+		rule := toCiliumExternalEgressRule(dest)
+		hasFQDNRules = hasFQDNRules || len(rule.ToFQDNs) > 0
+		policy.Spec.Egress = append(policy.Spec.Egress, rule)
+	}
+	if len(policy.Spec.Egress) > 0 { // add a rule to allow egress DNS traffic
+		policy.Spec.Egress = append(policy.Spec.Egress, ps.ciliumDNSEgressRule(hasFQDNRules))
+	}
+
+	return &policy
+}
+
+// toCiliumPeer converts a NetworkPolicy peer of a policy in the given namespace into the equivalent Cilium selectors
+func toCiliumPeer(peer *network.NetworkPolicyPeer, namespace string) ciliumPeer {
+	if peer.IPBlock != nil {
+		return ciliumPeer{cidrs: []string{peer.IPBlock.CIDR}}
+	}
+	if peer.PodSelector == nil && peer.NamespaceSelector != nil && len(peer.NamespaceSelector.MatchLabels) == 0 {
+		return ciliumPeer{entities: []string{ciliumEntityCluster}}
+	}
+
+	selector := metaV1.LabelSelector{MatchLabels: map[string]string{}}
+	if peer.PodSelector != nil {
+		maps.Copy(selector.MatchLabels, peer.PodSelector.MatchLabels)
+		selector.MatchExpressions = slices.Clone(peer.PodSelector.MatchExpressions)
+	}
+	switch {
+	case peer.NamespaceSelector == nil: // pods in the policy's namespace
+		if namespace != "" {
+			selector.MatchLabels[ciliumNamespaceLabel] = namespace
+		}
+	case len(peer.NamespaceSelector.MatchLabels) == 0: // pods in all namespaces
+		selector.MatchExpressions = append(selector.MatchExpressions,
+			metaV1.LabelSelectorRequirement{Key: ciliumNamespaceLabel, Operator: metaV1.LabelSelectorOpExists})
+	default:
+		for key, value := range peer.NamespaceSelector.MatchLabels {
+			if key == namespaceNameLabel {
+				selector.MatchLabels[ciliumNamespaceLabel] = value
+			} else {
+				selector.MatchLabels[ciliumNamespaceLabelPrefix+key] = value
+			}
+		}
+	}
+	return ciliumPeer{endpoints: []metaV1.LabelSelector{selector}}
+}
+
+// toCiliumPortRules converts NetworkPolicy ports into Cilium port rules.
+// If httpPaths is not nil, only HTTP requests with matching paths are allowed to the given TCP ports.
+// Other ports (of other protocols, or all ports) are put in a separate port rule, as HTTP rules only apply to specific TCP ports.
+func toCiliumPortRules(ports []network.NetworkPolicyPort, httpPaths []string) []CiliumPortRule {
+	if len(ports) == 0 {
+		return nil
+	}
+	httpRule, otherRule := CiliumPortRule{}, CiliumPortRule{}
+	for i := range ports {
+		protocol := core.ProtocolTCP
+		if ports[i].Protocol != nil {
+			protocol = *ports[i].Protocol
+		}
+		portStr := "0" // all ports
+		if ports[i].Port != nil {
+			portStr = ports[i].Port.String()
+		}
+		portProtocol := CiliumPortProtocol{Port: portStr, Protocol: string(protocol)}
+		if len(httpPaths) > 0 && protocol == core.ProtocolTCP && ports[i].Port != nil {
+			httpRule.Ports = append(httpRule.Ports, portProtocol)
+		} else {
+			otherRule.Ports = append(otherRule.Ports, portProtocol)
+		}
+	}
+
+	portRules := []CiliumPortRule{}
+	if len(httpRule.Ports) > 0 {
+		httpRule.Rules = &CiliumL7Rules{}
+		for _, path := range httpPaths {
+			httpRule.Rules.HTTP = append(httpRule.Rules.HTTP, CiliumHTTPRule{Path: path})
+		}
+		portRules = append(portRules, httpRule)
+	}
+	if len(otherRule.Ports) > 0 {
+		portRules = append(portRules, otherRule)
+	}
+	return portRules
+}
+
+// toCiliumExternalEgressRule allows egress to the addresses of the given external destination,
+// or to its DNS name if its addresses are unknown
+func toCiliumExternalEgressRule(dest *ExternalDestination) CiliumEgressRule {
+	rule := CiliumEgressRule{ToPorts: toCiliumPortRules(toNetpolExternalPorts(dest), nil)}
+	switch {
+	case len(dest.Addresses) > 0:
+		rule.ToCIDR = dest.Addresses
+	case strings.Contains(dest.Host, "*"):
+		rule.ToFQDNs = []CiliumFQDNSelector{{MatchPattern: dest.Host}}
+	default:
+		rule.ToFQDNs = []CiliumFQDNSelector{{MatchName: dest.Host}}
+	}
+	return rule
+}
+
+// ciliumDNSEgressRule allows egress DNS traffic. If DNS visibility is required (toFQDNs rules only work with DNS visibility),
+// DNS traffic is only allowed to kube-dns, and passes through Cilium's DNS proxy.
+func (ps *PoliciesSynthesizer) ciliumDNSEgressRule(hasFQDNRules bool) CiliumEgressRule {
+	if !ps.ciliumDNSVisibility && !hasFQDNRules {
+		dnsPort := CiliumPortProtocol{Port: ps.dnsPort.String(), Protocol: string(core.ProtocolUDP)}
+		return CiliumEgressRule{ToEntities: []string{ciliumEntityCluster}, ToPorts: []CiliumPortRule{{Ports: []CiliumPortProtocol{dnsPort}}}}
+	}
+	kubeDNS := metaV1.LabelSelector{MatchLabels: map[string]string{ciliumNamespaceLabel: "kube-system", "k8s:k8s-app": "kube-dns"}}
+	dnsPortRule := CiliumPortRule{
+		Ports: []CiliumPortProtocol{{Port: ps.dnsPort.String(), Protocol: ciliumProtocolAny}},
+		Rules: &CiliumL7Rules{DNS: []CiliumDNSRule{{MatchPattern: "*"}}},
+	}
+	return CiliumEgressRule{ToEndpoints: []metaV1.LabelSelector{kubeDNS}, ToPorts: []CiliumPortRule{dnsPortRule}}
+}
+
+// Generate a default-deny CiliumNetworkPolicy for each namespace of the given resources
+func getNsDefaultDenyCiliumPolicies(resources []*Resource) []*CiliumNetworkPolicy {
+	policies := []*CiliumNetworkPolicy{}
+	for _, namespace := range workloadNamespaces(resources) {
+		policyName := "default-deny-in-namespace"
+		if namespace != "" {
+			policyName += "-" + namespace
+		}
+		policies = append(policies, &CiliumNetworkPolicy{
+			TypeMeta:   metaV1.TypeMeta{Kind: ciliumPolicyKind, APIVersion: ciliumPolicyAPIVersion},
+			ObjectMeta: metaV1.ObjectMeta{Name: policyName, Namespace: namespace},
+			Spec: CiliumPolicySpec{
+				EndpointSelector: metaV1.LabelSelector{},  // select all endpoints in the namespace
+				Ingress:          []CiliumIngressRule{{}}, // deny all ingress
+				Egress:           []CiliumEgressRule{{}},  // deny all egress
+			},
+		})
+	}
+	return policies
+}
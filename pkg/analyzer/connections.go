@@ -8,21 +8,29 @@ package analyzer
 
 import (
 	"fmt"
+	"slices"
 )
 
 // This function is at the core of the topology analysis
 // For each resource, it finds other resources that may use it and compiles a list of connections holding these dependencies
-func discoverConnections(resources []*Resource, links []*Service, logger Logger) []*Connections {
+// It also returns warnings about Service target ports which do not match the ports of the selected workloads
+func discoverConnections(resources []*Resource, links []*Service, logger Logger) ([]*Connections, []FileProcessingError) {
 	connections := []*Connections{}
+	warnings := []FileProcessingError{}
 	for _, destRes := range resources {
 		deploymentServices := findServices(destRes, links)
 		logger.Debugf("services matched to %v: %v", destRes.Resource.Name, deploymentServices)
 		for _, svc := range deploymentServices {
-			srcRes := findSource(resources, svc)
-			for _, r := range srcRes {
+			warnings = append(warnings, checkTargetPorts(destRes, svc, logger)...)
+			srcRes := []*Resource{}
+			srcOrigins := [][]AddressOrigin{}
+			if svc.reachesWorkload(destRes) {
+				srcRes, srcOrigins = findSource(resources, svc)
+			}
+			for i, r := range srcRes {
 				if !r.equals(destRes) {
 					logger.Debugf("source: %s target: %s link: %s", r.Resource.Name, destRes.Resource.Name, svc.Resource.Name)
-					connections = append(connections, &Connections{Source: r, Target: destRes, Link: svc})
+					connections = append(connections, &Connections{Source: r, Target: destRes, Link: svc, Origins: srcOrigins[i]})
 				}
 			}
 			if len(srcRes) == 0 || svcHasExposedPorts(svc) { // found no sources, but some ports need to be exposed
@@ -30,7 +38,21 @@ func discoverConnections(resources []*Resource, links []*Service, logger Logger)
 			}
 		}
 	}
-	return connections
+	return connections, warnings
+}
+
+// checkTargetPorts returns a warning for each port of the given service whose target port
+// cannot be found among the container ports of the given (selected) workload
+func checkTargetPorts(workload *Resource, svc *Service, logger Logger) []FileProcessingError {
+	warnings := []FileProcessingError{}
+	for i := range svc.Resource.Network {
+		port := &svc.Resource.Network[i]
+		if targetPort, ok := workload.resolveTargetPort(port); !ok {
+			warning := targetPortNotFound(svc.Resource.Name, targetPort.String(), workload.Resource.Name, svc.Resource.FilePath)
+			warnings = appendAndLogNewError(warnings, warning, logger)
+		}
+	}
+	return warnings
 }
 
 func svcHasExposedPorts(svc *Service) bool {
@@ -78,13 +100,16 @@ func findServices(resource *Resource, links []*Service) []*Service {
 	return matchedSvc
 }
 
-// findSource returns a list of resources that are likely trying to connect to the given service
-func findSource(resources []*Resource, service *Service) []*Resource {
+// findSource returns a list of resources that are likely trying to connect to the given service,
+// and for each such resource, the origins of the addresses in its manifest which refer to the service
+func findSource(resources []*Resource, service *Service) ([]*Resource, [][]AddressOrigin) {
 	tRes := []*Resource{}
+	tOrigins := [][]AddressOrigin{}
 	for _, resource := range resources {
 		serviceAddresses := getPossibleServiceAddresses(service, resource)
 		foundSrc := *resource // We copy the resource so we can specify the ports used by the source found
 		matched := false
+		origins := []AddressOrigin{}
 		for _, envVal := range resource.Resource.NetworkAddrs {
 			match, port := envValueMatchesService(envVal, service, serviceAddresses)
 			if match {
@@ -92,13 +117,24 @@ func findSource(resources []*Resource, service *Service) []*Resource {
 				if port.Port > 0 {
 					foundSrc.Resource.UsedPorts = append(foundSrc.Resource.UsedPorts, port)
 				}
+				origins = appendNewOrigins(origins, resource.addressOrigins(envVal))
 			}
 		}
 		if matched {
 			tRes = append(tRes, &foundSrc)
+			tOrigins = append(tOrigins, origins)
+		}
+	}
+	return tRes, tOrigins
+}
+
+func appendNewOrigins(origins, newOrigins []AddressOrigin) []AddressOrigin {
+	for _, origin := range newOrigins {
+		if !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
 		}
 	}
-	return tRes
+	return origins
 }
 
 func getPossibleServiceAddresses(service *Service, resource *Resource) []string {
@@ -110,6 +146,7 @@ func getPossibleServiceAddresses(service *Service, resource *Resource) []string
 	if service.Resource.Namespace == resource.Resource.Namespace { // both service and resource live in the same namespace
 		svcAddresses = append(svcAddresses, service.Resource.Name)
 	}
+	svcAddresses = append(svcAddresses, service.hostAliases...)
 
 	return svcAddresses
 }
@@ -0,0 +1,434 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	exposedOutsideCluster = "outside the cluster"
+	exposedInsideCluster  = "inside the cluster"
+)
+
+// ConnectivityDiff lists the differences between the connectivity of two revisions of an application's manifests.
+// Workloads and Services are identified by their kind, namespace and name, so moving resources between files
+// or reordering them does not count as a change.
+type ConnectivityDiff struct {
+	AddedConnections   []ConnectionChange `json:"added_connections,omitempty"`
+	RemovedConnections []ConnectionChange `json:"removed_connections,omitempty"`
+	ChangedPorts       []ConnectionChange `json:"changed_ports,omitempty"`
+	ExposedServices    []ExposureChange   `json:"exposed_services,omitempty"`   // Service ports which are exposed only in the new revision
+	UnexposedServices  []ExposureChange   `json:"unexposed_services,omitempty"` // Service ports which are exposed only in the old revision
+	PolicyChanges      []PolicyChange     `json:"policy_changes,omitempty"`
+}
+
+// ConnectionChange is a connection between two workloads which was added, removed or whose ports were changed
+type ConnectionChange struct {
+	Source   string   `json:"source"`
+	Target   string   `json:"target"`
+	Service  string   `json:"service"`
+	OldPorts []string `json:"old_ports,omitempty"`
+	NewPorts []string `json:"new_ports,omitempty"`
+}
+
+// ExposureChange lists the ports of a Service which became exposed (or are no longer exposed) to traffic from outside the application
+type ExposureChange struct {
+	Service  string   `json:"service"`
+	Exposure string   `json:"exposure"` // either "outside the cluster" or "inside the cluster"
+	Ports    []string `json:"ports,omitempty"`
+}
+
+// PolicyChange is a NetworkPolicy which was added, removed or changed
+type PolicyChange struct {
+	Policy              string   `json:"policy"`
+	Change              string   `json:"change"` // either "added", "removed" or "changed"
+	OldPodSelector      string   `json:"old_pod_selector,omitempty"`
+	NewPodSelector      string   `json:"new_pod_selector,omitempty"`
+	AddedIngressRules   []string `json:"added_ingress_rules,omitempty"`
+	RemovedIngressRules []string `json:"removed_ingress_rules,omitempty"`
+	AddedEgressRules    []string `json:"added_egress_rules,omitempty"`
+	RemovedEgressRules  []string `json:"removed_egress_rules,omitempty"`
+}
+
+const (
+	policyAdded   = "added"
+	policyRemoved = "removed"
+	policyChanged = "changed"
+)
+
+// IsEmpty returns true if there are no differences
+func (diff *ConnectivityDiff) IsEmpty() bool {
+	return len(diff.AddedConnections) == 0 && len(diff.RemovedConnections) == 0 && len(diff.ChangedPorts) == 0 &&
+		len(diff.ExposedServices) == 0 && len(diff.UnexposedServices) == 0 && len(diff.PolicyChanges) == 0
+}
+
+// DiffConnections compares the connections discovered in two revisions of an application's manifests,
+// and returns the added and removed connections, the connections whose ports were changed,
+// and the Services which became exposed (or are no longer exposed).
+// Use DiffNetworkPolicies() to fill in the changes in the NetworkPolicies synthesized for the two revisions.
+func DiffConnections(oldConns, newConns []*Connections) *ConnectivityDiff {
+	diff := ConnectivityDiff{}
+	oldPorts, oldKeys := connectionPortsByKey(oldConns)
+	newPorts, newKeys := connectionPortsByKey(newConns)
+	for _, key := range newKeys {
+		change := connectionChangeFromKey(key)
+		oldConnPorts, found := oldPorts[key]
+		switch {
+		case !found:
+			change.NewPorts = newPorts[key]
+			diff.AddedConnections = append(diff.AddedConnections, change)
+		case !slices.Equal(oldConnPorts, newPorts[key]):
+			change.OldPorts = oldConnPorts
+			change.NewPorts = newPorts[key]
+			diff.ChangedPorts = append(diff.ChangedPorts, change)
+		}
+	}
+	for _, key := range oldKeys {
+		if _, found := newPorts[key]; !found {
+			change := connectionChangeFromKey(key)
+			change.OldPorts = oldPorts[key]
+			diff.RemovedConnections = append(diff.RemovedConnections, change)
+		}
+	}
+
+	oldExposures := exposedServices(oldConns)
+	newExposures := exposedServices(newConns)
+	diff.ExposedServices = exposureDifference(newExposures, oldExposures)
+	diff.UnexposedServices = exposureDifference(oldExposures, newExposures)
+	return &diff
+}
+
+// DiffFromFolderPaths compares the connectivity of the K8s resources under the provided base directories (or their subdirectories)
+// with the connectivity of the K8s resources under the provided head directories, including the changes in the NetworkPolicies
+// synthesized for the two revisions.
+func (ps *PoliciesSynthesizer) DiffFromFolderPaths(baseDirPaths, headDirPaths []string) (*ConnectivityDiff, error) {
+	var diff *ConnectivityDiff
+	baseResources, baseConns, errs := ps.extractConnectionsFromFolderPaths(baseDirPaths)
+	if !stopProcessing(ps.stopOnError, errs) {
+		headResources, headConns, headErrs := ps.extractConnectionsFromFolderPaths(headDirPaths)
+		errs = append(errs, headErrs...)
+		if !stopProcessing(ps.stopOnError, errs) {
+			diff = DiffConnections(baseConns, headConns)
+			diff.PolicyChanges = DiffNetworkPolicies(ps.synthNetpols(baseResources, baseConns), ps.synthNetpols(headResources, headConns))
+		}
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// the key of a connection between workloads, identifying its source, target and link regardless of the files defining them
+type connectionKey struct {
+	source, target, service string
+}
+
+func connectionChangeFromKey(key connectionKey) ConnectionChange {
+	return ConnectionChange{Source: key.source, Target: key.target, Service: key.service}
+}
+
+// connectionPortsByKey returns the ports of each given connection between workloads, and the (sorted) keys of these connections
+func connectionPortsByKey(conns []*Connections) (map[connectionKey][]string, []connectionKey) {
+	portsByKey := map[connectionKey][]string{}
+	keys := []connectionKey{}
+	for _, conn := range conns {
+		if conn.Source == nil {
+			continue // source-less connections are compared as exposed services
+		}
+		key := connectionKey{workloadIdentity(conn.Source), workloadIdentity(conn.Target), serviceIdentity(conn.Link)}
+		if _, found := portsByKey[key]; !found {
+			keys = append(keys, key)
+		}
+		portsByKey[key] = mergePortStrings(portsByKey[key], connectionPorts(conn))
+	}
+	slices.SortFunc(keys, func(key1, key2 connectionKey) int {
+		return strings.Compare(key1.source+" "+key1.target+" "+key1.service, key2.source+" "+key2.target+" "+key2.service)
+	})
+	return portsByKey, keys
+}
+
+func mergePortStrings(portStrings []string, ports []SvcNetworkAttr) []string {
+	for i := range ports {
+		portString := fmt.Sprintf("%s/%d", protocolOrDefault(ports[i].Protocol), ports[i].Port)
+		if !slices.Contains(portStrings, portString) {
+			portStrings = append(portStrings, portString)
+		}
+	}
+	slices.Sort(portStrings)
+	return portStrings
+}
+
+// the key of a Service exposure, identifying the Service and whether it is exposed outside the cluster or only inside it
+type exposureKey struct {
+	service, exposure string
+}
+
+// exposedServices returns the ports exposed by each Service linked by the given connections, keyed by the Service and the exposure
+func exposedServices(conns []*Connections) map[exposureKey][]string {
+	exposures := map[exposureKey][]string{}
+	for _, conn := range conns {
+		svc := conn.Link
+		for i := range svc.Resource.Network {
+			port := &svc.Resource.Network[i]
+			key := exposureKey{service: serviceIdentity(svc), exposure: exposedInsideCluster}
+			switch {
+			case svc.Resource.ExposeExternally:
+				key.exposure = exposedOutsideCluster
+			case !port.exposeToCluster:
+				continue
+			}
+			exposures[key] = mergePortStrings(exposures[key], []SvcNetworkAttr{*port})
+		}
+	}
+	return exposures
+}
+
+// exposureDifference returns the exposed Service ports in the given exposures, which are not in the other given exposures
+func exposureDifference(exposures, otherExposures map[exposureKey][]string) []ExposureChange {
+	changes := []ExposureChange{}
+	for key, ports := range exposures {
+		if newlyExposed := stringsDifference(ports, otherExposures[key]); len(newlyExposed) > 0 {
+			changes = append(changes, ExposureChange{Service: key.service, Exposure: key.exposure, Ports: newlyExposed})
+		}
+	}
+	slices.SortFunc(changes, func(change1, change2 ExposureChange) int {
+		return strings.Compare(change1.Service+change1.Exposure, change2.Service+change2.Exposure)
+	})
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// DiffNetworkPolicies compares the NetworkPolicies synthesized for two revisions of an application's manifests.
+// Policies are identified by their namespace and name, and rules are compared regardless of their order.
+func DiffNetworkPolicies(oldPolicies, newPolicies []*network.NetworkPolicy) []PolicyChange {
+	oldByName := map[string]*network.NetworkPolicy{}
+	for _, policy := range oldPolicies {
+		oldByName[namespacedName(policy.Namespace, policy.Name)] = policy
+	}
+	newByName := map[string]*network.NetworkPolicy{}
+	for _, policy := range newPolicies {
+		newByName[namespacedName(policy.Namespace, policy.Name)] = policy
+	}
+
+	changes := []PolicyChange{}
+	for name, newPolicy := range newByName {
+		oldPolicy, found := oldByName[name]
+		if !found {
+			changes = append(changes, PolicyChange{Policy: name, Change: policyAdded})
+			continue
+		}
+		change := PolicyChange{Policy: name, Change: policyChanged}
+		oldSelector := metaV1.FormatLabelSelector(&oldPolicy.Spec.PodSelector)
+		newSelector := metaV1.FormatLabelSelector(&newPolicy.Spec.PodSelector)
+		if oldSelector != newSelector {
+			change.OldPodSelector = oldSelector
+			change.NewPodSelector = newSelector
+		}
+		oldIngress, newIngress := ingressRuleStrings(oldPolicy), ingressRuleStrings(newPolicy)
+		change.AddedIngressRules = stringsDifference(newIngress, oldIngress)
+		change.RemovedIngressRules = stringsDifference(oldIngress, newIngress)
+		oldEgress, newEgress := egressRuleStrings(oldPolicy), egressRuleStrings(newPolicy)
+		change.AddedEgressRules = stringsDifference(newEgress, oldEgress)
+		change.RemovedEgressRules = stringsDifference(oldEgress, newEgress)
+		if change.OldPodSelector != "" || len(change.AddedIngressRules) > 0 || len(change.RemovedIngressRules) > 0 ||
+			len(change.AddedEgressRules) > 0 || len(change.RemovedEgressRules) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	for name := range oldByName {
+		if _, found := newByName[name]; !found {
+			changes = append(changes, PolicyChange{Policy: name, Change: policyRemoved})
+		}
+	}
+	slices.SortFunc(changes, func(change1, change2 PolicyChange) int { return strings.Compare(change1.Policy, change2.Policy) })
+	return changes
+}
+
+func ingressRuleStrings(policy *network.NetworkPolicy) []string {
+	rules := make([]string, 0, len(policy.Spec.Ingress))
+	for i := range policy.Spec.Ingress {
+		rules = append(rules, "from "+peersString(policy.Spec.Ingress[i].From)+", "+portsString(policy.Spec.Ingress[i].Ports))
+	}
+	return rules
+}
+
+func egressRuleStrings(policy *network.NetworkPolicy) []string {
+	rules := make([]string, 0, len(policy.Spec.Egress))
+	for i := range policy.Spec.Egress {
+		rules = append(rules, "to "+peersString(policy.Spec.Egress[i].To)+", "+portsString(policy.Spec.Egress[i].Ports))
+	}
+	return rules
+}
+
+// stringsDifference returns the (sorted) strings in the given strings, which are not in the other given strings
+func stringsDifference(strs, otherStrs []string) []string {
+	var difference []string
+	for _, str := range strs {
+		if !slices.Contains(otherStrs, str) && !slices.Contains(difference, str) {
+			difference = append(difference, str)
+		}
+	}
+	slices.Sort(difference)
+	return difference
+}
+
+// serviceIdentity identifies a Service by its namespace and name
+func serviceIdentity(svc *Service) string {
+	return "Service " + namespacedName(svc.Resource.Namespace, svc.Resource.Name)
+}
+
+// String returns a human-readable form of the differences
+func (diff *ConnectivityDiff) String() string {
+	if diff.IsEmpty() {
+		return "No connectivity changes"
+	}
+	var sb strings.Builder
+	writeConnectionChanges(&sb, "Added connections", "+", diff.AddedConnections)
+	writeConnectionChanges(&sb, "Removed connections", "-", diff.RemovedConnections)
+	writeConnectionChanges(&sb, "Connections with changed ports", "~", diff.ChangedPorts)
+	writeExposureChanges(&sb, "Newly exposed services", "+", diff.ExposedServices)
+	writeExposureChanges(&sb, "Services no longer exposed", "-", diff.UnexposedServices)
+	if len(diff.PolicyChanges) > 0 {
+		sb.WriteString("NetworkPolicy changes:\n")
+		for i := range diff.PolicyChanges {
+			change := &diff.PolicyChanges[i]
+			fmt.Fprintf(&sb, "  %s %s (%s)\n", policyChangeMark(change.Change), change.Policy, change.Change)
+			for _, detail := range change.details() {
+				fmt.Fprintf(&sb, "      %s\n", detail)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n\n")
+}
+
+func writeConnectionChanges(sb *strings.Builder, title, mark string, changes []ConnectionChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "%s:\n", title)
+	for i := range changes {
+		fmt.Fprintf(sb, "  %s %s -> %s via %s: %s\n", mark, changes[i].Source, changes[i].Target, changes[i].Service, changes[i].portsString())
+	}
+	sb.WriteString("\n")
+}
+
+func writeExposureChanges(sb *strings.Builder, title, mark string, changes []ExposureChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "%s:\n", title)
+	for i := range changes {
+		fmt.Fprintf(sb, "  %s %s, %s: %s\n", mark, changes[i].Service, changes[i].Exposure, strings.Join(changes[i].Ports, ", "))
+	}
+	sb.WriteString("\n")
+}
+
+func (change *ConnectionChange) portsString() string {
+	switch {
+	case change.OldPorts == nil:
+		return strings.Join(change.NewPorts, ", ")
+	case change.NewPorts == nil:
+		return strings.Join(change.OldPorts, ", ")
+	}
+	return strings.Join(change.OldPorts, ", ") + " => " + strings.Join(change.NewPorts, ", ")
+}
+
+func policyChangeMark(change string) string {
+	switch change {
+	case policyAdded:
+		return "+"
+	case policyRemoved:
+		return "-"
+	}
+	return "~"
+}
+
+// details returns the changes in the pod selector and in the rules of a changed policy
+func (change *PolicyChange) details() []string {
+	details := []string{}
+	if change.OldPodSelector != "" {
+		details = append(details, fmt.Sprintf("pod selector: %s => %s", change.OldPodSelector, change.NewPodSelector))
+	}
+	for _, rule := range change.AddedIngressRules {
+		details = append(details, "+ ingress "+rule)
+	}
+	for _, rule := range change.RemovedIngressRules {
+		details = append(details, "- ingress "+rule)
+	}
+	for _, rule := range change.AddedEgressRules {
+		details = append(details, "+ egress "+rule)
+	}
+	for _, rule := range change.RemovedEgressRules {
+		details = append(details, "- egress "+rule)
+	}
+	return details
+}
+
+// Markdown returns the differences as Markdown tables, suitable for a pull-request comment
+func (diff *ConnectivityDiff) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("### Connectivity changes\n\n")
+	if diff.IsEmpty() {
+		sb.WriteString("No connectivity changes\n")
+		return sb.String()
+	}
+	writeConnectionChangesTable(&sb, "Added connections", diff.AddedConnections)
+	writeConnectionChangesTable(&sb, "Removed connections", diff.RemovedConnections)
+	writeConnectionChangesTable(&sb, "Connections with changed ports", diff.ChangedPorts)
+	writeExposureChangesTable(&sb, "Newly exposed services", diff.ExposedServices)
+	writeExposureChangesTable(&sb, "Services no longer exposed", diff.UnexposedServices)
+	if len(diff.PolicyChanges) > 0 {
+		sb.WriteString("#### NetworkPolicy changes\n\n| Policy | Change | Details |\n| --- | --- | --- |\n")
+		for i := range diff.PolicyChanges {
+			change := &diff.PolicyChanges[i]
+			fmt.Fprintf(&sb, "| %s | %s | %s |\n", markdownCell(change.Policy), change.Change, markdownCell(strings.Join(change.details(), "\n")))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeConnectionChangesTable(sb *strings.Builder, title string, changes []ConnectionChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "#### %s\n\n| Source | Target | Service | Ports |\n| --- | --- | --- | --- |\n", title)
+	for i := range changes {
+		fmt.Fprintf(sb, "| %s | %s | %s | %s |\n", markdownCell(changes[i].Source), markdownCell(changes[i].Target),
+			markdownCell(changes[i].Service), markdownCell(changes[i].portsString()))
+	}
+	sb.WriteString("\n")
+}
+
+func writeExposureChangesTable(sb *strings.Builder, title string, changes []ExposureChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "#### %s\n\n| Service | Exposure | Ports |\n| --- | --- | --- |\n", title)
+	for i := range changes {
+		fmt.Fprintf(sb, "| %s | %s | %s |\n", markdownCell(changes[i].Service), changes[i].Exposure,
+			markdownCell(strings.Join(changes[i].Ports, ", ")))
+	}
+	sb.WriteString("\n")
+}
+
+// markdownCell escapes the given text, so it can be put in a single cell of a Markdown table
+func markdownCell(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "|", `\|`), "\n", "<br>")
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	ocnetworkv1 "github.com/openshift/api/network/v1"
+	"golang.org/x/net/publicsuffix"
+	core "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	egressFirewallAPIVersion      = "k8s.ovn.org/v1"
+	egressFirewallKind            = "EgressFirewall"
+	egressFirewallName            = "default" // OVN-Kubernetes only enforces an EgressFirewall named "default" in each namespace
+	egressNetworkPolicyAPIVersion = "network.openshift.io/v1"
+	egressNetworkPolicyKind       = "EgressNetworkPolicy"
+	egressFirewallAllow           = "Allow"
+	egressFirewallDeny            = "Deny"
+)
+
+// urlSchemePorts holds the default ports of URL schemes, for inferring the ports of external destinations written as URLs
+var urlSchemePorts = map[string]int{"http": 80, "https": 443}
+
+// EgressFirewall is an OVN-Kubernetes EgressFirewall (k8s.ovn.org/v1), controlling the egress traffic
+// from the pods of a namespace to destinations outside the cluster
+type EgressFirewall struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              EgressFirewallSpec `json:"spec"`
+}
+
+// EgressFirewallSpec holds an ordered list of egress rules. The first matching rule applies.
+type EgressFirewallSpec struct {
+	Egress []EgressFirewallRule `json:"egress"`
+}
+
+// EgressFirewallRule allows or denies egress traffic to a destination, optionally only on the given ports
+type EgressFirewallRule struct {
+	Type  string                    `json:"type"`
+	To    EgressFirewallDestination `json:"to"`
+	Ports []EgressFirewallPort      `json:"ports,omitempty"` // empty means all ports
+}
+
+// EgressFirewallDestination is either a CIDR or a DNS name
+//
+//nolint:tagliatelle // the EgressFirewall API uses camelCase field names
+type EgressFirewallDestination struct {
+	CIDRSelector string `json:"cidrSelector,omitempty"`
+	DNSName      string `json:"dnsName,omitempty"`
+}
+
+// EgressFirewallPort is a port number and a protocol
+type EgressFirewallPort struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+}
+
+// EgressFirewallList is a list of EgressFirewalls, which can be applied using kubectl
+type EgressFirewallList struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []EgressFirewall `json:"items"`
+}
+
+// EgressFirewallListFromSlice converts a slice of EgressFirewalls to a list containing all the EgressFirewalls in the slice
+func EgressFirewallListFromSlice(firewalls []*EgressFirewall) EgressFirewallList {
+	items := []EgressFirewall{}
+	for _, firewall := range firewalls {
+		items = append(items, *firewall)
+	}
+	return EgressFirewallList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+// EgressNetworkPolicyListFromEgressFirewalls converts a slice of EgressFirewalls (used with OVN-Kubernetes)
+// into a list of the equivalent OpenShift EgressNetworkPolicies (used with OpenShift SDN).
+// As EgressNetworkPolicies cannot restrict ports, and only support IPv4 CIDRs and non-wildcard DNS names,
+// rules with ports are allowed on all ports, and rules with unsupported destinations are dropped.
+func EgressNetworkPolicyListFromEgressFirewalls(firewalls []*EgressFirewall) ocnetworkv1.EgressNetworkPolicyList {
+	items := []ocnetworkv1.EgressNetworkPolicy{}
+	for _, firewall := range firewalls {
+		policy := ocnetworkv1.EgressNetworkPolicy{
+			TypeMeta:   metaV1.TypeMeta{Kind: egressNetworkPolicyKind, APIVersion: egressNetworkPolicyAPIVersion},
+			ObjectMeta: metaV1.ObjectMeta{Name: firewall.Name, Namespace: firewall.Namespace},
+			Spec:       ocnetworkv1.EgressNetworkPolicySpec{Egress: []ocnetworkv1.EgressNetworkPolicyRule{}},
+		}
+		for i := range firewall.Spec.Egress {
+			rule := &firewall.Spec.Egress[i]
+			if !supportedByEgressNetworkPolicy(&rule.To) {
+				continue
+			}
+			sdnRule := ocnetworkv1.EgressNetworkPolicyRule{
+				Type: ocnetworkv1.EgressNetworkPolicyRuleType(rule.Type),
+				To:   ocnetworkv1.EgressNetworkPolicyPeer{CIDRSelector: rule.To.CIDRSelector, DNSName: rule.To.DNSName},
+			}
+			if !slices.Contains(policy.Spec.Egress, sdnRule) {
+				policy.Spec.Egress = append(policy.Spec.Egress, sdnRule)
+			}
+		}
+		items = append(items, policy)
+	}
+	return ocnetworkv1.EgressNetworkPolicyList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}, Items: items}
+}
+
+func supportedByEgressNetworkPolicy(dest *EgressFirewallDestination) bool {
+	if dest.DNSName != "" {
+		return !strings.Contains(dest.DNSName, "*")
+	}
+	ip, _, err := net.ParseCIDR(dest.CIDRSelector)
+	return err == nil && ip.To4() != nil
+}
+
+// synthEgressFirewalls generates an EgressFirewall for each workload namespace, allowing egress only to the external
+// destinations which the namespace's workloads connect to, and denying all other egress traffic to outside the cluster.
+// A warning is returned for each namespace whose EgressFirewall allows no egress traffic to outside the cluster.
+func synthEgressFirewalls(resources []*Resource, logger Logger) ([]*EgressFirewall, []FileProcessingError) {
+	namespaces := sortedWorkloadNamespaces(resources)
+	firewalls := map[string]*EgressFirewall{}
+	for _, namespace := range namespaces {
+		firewalls[namespace] = &EgressFirewall{
+			TypeMeta:   metaV1.TypeMeta{Kind: egressFirewallKind, APIVersion: egressFirewallAPIVersion},
+			ObjectMeta: metaV1.ObjectMeta{Name: egressFirewallName, Namespace: namespace},
+		}
+	}
+	for _, res := range resources {
+		firewall := firewalls[namespaceOrDefault(res.Resource.Namespace)]
+		for _, dests := range [][]ExternalDestination{res.Resource.ExternalDestinations, res.Resource.InferredExternalDestinations} {
+			for i := range dests {
+				for _, rule := range toEgressFirewallRules(&dests[i]) {
+					firewall.addRule(rule)
+				}
+			}
+		}
+	}
+
+	retSlice := make([]*EgressFirewall, 0, len(namespaces))
+	warnings := []FileProcessingError{}
+	for _, namespace := range namespaces {
+		firewall := firewalls[namespace]
+		if len(firewall.Spec.Egress) == 0 {
+			warnings = appendAndLogNewError(warnings, noExternalDestinations(namespace), logger)
+		}
+		for _, cidr := range []string{anyIPv4CIDR, anyIPv6CIDR} { // deny all other egress traffic to outside the cluster
+			firewall.addRule(EgressFirewallRule{Type: egressFirewallDeny, To: EgressFirewallDestination{CIDRSelector: cidr}})
+		}
+		retSlice = append(retSlice, firewall)
+	}
+	return retSlice, warnings
+}
+
+// inferExternalDestinations records the external destinations of the addresses in each workload's manifest,
+// which refer to no Service and to no ServiceEntry host. To avoid mistaking values such as file or class names
+// (e.g., "config.json" or "com.example.Main") for hosts, an address is only assumed to be outside the cluster if it is
+// written as a URL with a scheme or includes a port, and its host is either an IP address or a DNS name under a public
+// (ICANN) suffix, which is not a cluster-local name (e.g., "name.namespace" of a scanned namespace).
+// As egress is only allowed to the port of such a destination, its port must be known - either written explicitly,
+// or implied by an "http" or "https" scheme.
+// It should only be called after applyIstioRouting()
+func (ra *resourceAccumulator) inferExternalDestinations() {
+	namespaces := ra.scannedNamespaces()
+	for _, res := range ra.workloads {
+		for _, addr := range res.Resource.NetworkAddrs {
+			dest, ok := externalDestinationOf(addr, res.Resource.URLSchemes[addr], namespaces)
+			if !ok || ra.refersToService(res, addr) || ra.refersToServiceEntry(dest.Host) ||
+				slices.ContainsFunc(res.Resource.InferredExternalDestinations,
+					func(known ExternalDestination) bool { return reflect.DeepEqual(known, dest) }) {
+				continue
+			}
+			res.Resource.InferredExternalDestinations = append(res.Resource.InferredExternalDestinations, dest)
+		}
+	}
+}
+
+// refersToService checks whether the given address in the given workload's manifest refers to any Service
+func (ra *resourceAccumulator) refersToService(res *Resource, addr string) bool {
+	return slices.ContainsFunc(ra.services, func(svc *Service) bool {
+		match, _ := envValueMatchesService(addr, svc, getPossibleServiceAddresses(svc, res))
+		return match
+	})
+}
+
+// refersToServiceEntry checks whether the given host matches the hosts of any ServiceEntry
+// (whether inside or outside the mesh), so its destinations are already determined by the ServiceEntry
+func (ra *resourceAccumulator) refersToServiceEntry(host string) bool {
+	return slices.ContainsFunc(ra.serviceEntries, func(se *istioServiceEntry) bool { return se.matchesHost(host) })
+}
+
+// externalDestinationOf returns the destination of the given address ("host" or "host:port"), unless it may not be
+// outside the cluster or its port is unknown (given the scheme of the address if written as a URL,
+// and the namespaces of the scanned resources)
+func externalDestinationOf(addr, scheme string, namespaces map[string]bool) (ExternalDestination, bool) {
+	host, portStr, hasPort := strings.Cut(addr, ":")
+	dest := ExternalDestination{Host: host, Addresses: []string{}, Port: urlSchemePorts[scheme], Protocol: core.ProtocolTCP}
+	if hasPort {
+		dest.Port, _ = strconv.Atoi(portStr) // a named port cannot be resolved
+	}
+	if scheme == "" && !hasPort {
+		return dest, false // a bare name (e.g., "config.json") is too weak an evidence for a connection
+	}
+	if dest.Port == 0 {
+		return dest, false // egress to an unknown port of a guessed host is not allowed
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		dest.Addresses = appendCIDR(dest.Addresses, host)
+		return dest, true
+	}
+
+	if suffix, icann := publicsuffix.PublicSuffix(host); !icann || suffix == host {
+		return dest, false // not under a real top-level domain, e.g., "svc.other-ns", "db.corp.internal" or "4.13.28"
+	}
+	labels := strings.Split(host, ".")
+	clusterLocal := len(labels) == 2 && namespaces[labels[1]]
+	return dest, !clusterLocal
+}
+
+func (firewall *EgressFirewall) addRule(rule EgressFirewallRule) {
+	for i := range firewall.Spec.Egress {
+		if reflect.DeepEqual(firewall.Spec.Egress[i], rule) {
+			return
+		}
+	}
+	firewall.Spec.Egress = append(firewall.Spec.Egress, rule)
+}
+
+// toEgressFirewallRules returns rules allowing egress to the CIDRs of the given external destination,
+// or to its DNS name if its CIDRs are unknown
+func toEgressFirewallRules(dest *ExternalDestination) []EgressFirewallRule {
+	var ports []EgressFirewallPort
+	if dest.Port != 0 {
+		ports = []EgressFirewallPort{{Protocol: string(protocolOrDefault(dest.Protocol)), Port: dest.Port}}
+	}
+	if len(dest.Addresses) == 0 {
+		return []EgressFirewallRule{{Type: egressFirewallAllow, To: EgressFirewallDestination{DNSName: dest.Host}, Ports: ports}}
+	}
+	rules := make([]EgressFirewallRule, 0, len(dest.Addresses))
+	for _, cidr := range dest.Addresses {
+		rules = append(rules, EgressFirewallRule{Type: egressFirewallAllow, To: EgressFirewallDestination{CIDRSelector: cidr}, Ports: ports})
+	}
+	return rules
+}
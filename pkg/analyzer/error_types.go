@@ -9,6 +9,7 @@ package analyzer
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // FileProcessingError holds all information about a single error/warning that occurred during
@@ -40,6 +41,44 @@ type ConfigMapKeyNotFoundError struct {
 	cfgMapName, cfgMapKey, resourceName string
 }
 
+// TargetPortNotFoundError is the error emitted when a Service's target port is not declared by a workload it selects
+type TargetPortNotFoundError struct {
+	svcName, targetPort, resourceName string
+}
+
+// HostNetworkWorkloadError is the error emitted when a workload's pods use the host network, so NetworkPolicies do not apply to them
+type HostNetworkWorkloadError struct {
+	resourceName string
+}
+
+// ReferenceNotGrantedError is the error emitted when a Gateway API route refers to a Service in another namespace,
+// but no ReferenceGrant permits this reference
+type ReferenceNotGrantedError struct {
+	routeKind, routeName, svcName string
+}
+
+// NoExternalDestinationsError is the error emitted when no external destination is found for the workloads of a namespace,
+// so the namespace's EgressFirewall denies all egress traffic to outside the cluster
+type NoExternalDestinationsError struct {
+	namespace string
+}
+
+// WorkloadNotFoundError is the error emitted when the workload to explain cannot be found among the scanned resources
+type WorkloadNotFoundError struct {
+	namespace, kind, name string
+}
+
+// InvalidOptionError is the error emitted when a PoliciesSynthesizer option is given an invalid value, which is then ignored
+type InvalidOptionError struct {
+	option, reason string
+}
+
+// UnsupportedAPIVersionError is the error emitted when a resource has a supported kind, but its API group or version is not supported
+type UnsupportedAPIVersionError struct {
+	kind, apiVersion     string
+	supportedAPIVersions []string
+}
+
 // FailedScanningResource is the error emitted when a known resource cannot be properly deciphered
 type FailedScanningResource struct {
 	resourceType string
@@ -72,6 +111,38 @@ func (err *ConfigMapKeyNotFoundError) Error() string {
 	return fmt.Sprintf("configmap %s does not have key %s (referenced by %s)", err.cfgMapName, err.cfgMapKey, err.resourceName)
 }
 
+func (err *TargetPortNotFoundError) Error() string {
+	return fmt.Sprintf("service %s targets port %s, which is not declared by the containers of %s",
+		err.svcName, err.targetPort, err.resourceName)
+}
+
+func (err *HostNetworkWorkloadError) Error() string {
+	return fmt.Sprintf("workload %s uses the host network; NetworkPolicies do not apply to its pods", err.resourceName)
+}
+
+func (err *ReferenceNotGrantedError) Error() string {
+	return fmt.Sprintf("%s %s refers to Service %s, but no ReferenceGrant permits this reference; the Service is not exposed",
+		err.routeKind, err.routeName, err.svcName)
+}
+
+func (err *NoExternalDestinationsError) Error() string {
+	return fmt.Sprintf("found no external destinations for the workloads in namespace %s; "+
+		"its EgressFirewall denies all egress traffic to outside the cluster", err.namespace)
+}
+
+func (err *WorkloadNotFoundError) Error() string {
+	return fmt.Sprintf("could not find workload %s %s/%s", err.kind, err.namespace, err.name)
+}
+
+func (err *InvalidOptionError) Error() string {
+	return fmt.Sprintf("invalid %s option: %s", err.option, err.reason)
+}
+
+func (err *UnsupportedAPIVersionError) Error() string {
+	return fmt.Sprintf("apiVersion %s of kind %s is not supported (supported apiVersions: %s)",
+		err.apiVersion, err.kind, strings.Join(err.supportedAPIVersions, ", "))
+}
+
 func (err *FailedScanningResource) Error() string {
 	return fmt.Sprintf("error scanning %s resource: %v", err.resourceType, err.origErr)
 }
@@ -164,6 +235,30 @@ func configMapKeyNotFound(cfgMapName, cfgMapKey, resourceName string) *FileProce
 	return &FileProcessingError{&ConfigMapKeyNotFoundError{cfgMapName, cfgMapKey, resourceName}, "", 0, -1, false, false}
 }
 
+func targetPortNotFound(svcName, targetPort, resourceName, filePath string) *FileProcessingError {
+	return &FileProcessingError{&TargetPortNotFoundError{svcName, targetPort, resourceName}, filePath, 0, -1, false, false}
+}
+
+func hostNetworkWorkload(resourceName, filePath string) *FileProcessingError {
+	return &FileProcessingError{&HostNetworkWorkloadError{resourceName}, filePath, 0, -1, false, false}
+}
+
+func referenceNotGranted(routeKind, routeName, svcName, filePath string) *FileProcessingError {
+	return &FileProcessingError{&ReferenceNotGrantedError{routeKind, routeName, svcName}, filePath, 0, -1, false, false}
+}
+
+func noExternalDestinations(namespace string) *FileProcessingError {
+	return &FileProcessingError{&NoExternalDestinationsError{namespace}, "", 0, -1, false, false}
+}
+
+func workloadNotFound(namespace, kind, name string) *FileProcessingError {
+	return &FileProcessingError{&WorkloadNotFoundError{namespace, kind, name}, "", 0, -1, true, true}
+}
+
+func invalidOption(option, reason string) *FileProcessingError {
+	return &FileProcessingError{&InvalidOptionError{option, reason}, "", 0, -1, false, true}
+}
+
 func failedScanningResource(resourceType, filePath string, err error) *FileProcessingError {
 	return &FileProcessingError{&FailedScanningResource{resourceType, err}, filePath, 0, -1, false, false}
 }
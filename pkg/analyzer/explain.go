@@ -0,0 +1,325 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// WorkloadExplanation explains the connectivity of a single workload: which Services select its pods, which Services are referred to
+// by each network address in its manifest (or why not), and the rules of the NetworkPolicy generated for the workload
+type WorkloadExplanation struct {
+	Workload     *Resource                `json:"workload"`
+	Services     []string                 `json:"services,omitempty"` // the Services which select the workload's pods
+	Addresses    []AddressExplanation     `json:"addresses,omitempty"`
+	IngressRules []IngressRuleExplanation `json:"ingress_rules,omitempty"`
+	EgressRules  []EgressRuleExplanation  `json:"egress_rules,omitempty"`
+}
+
+// AddressExplanation tells which of the Services, named like the host of a network address, the address refers to
+type AddressExplanation struct {
+	AddressOrigin
+	Rejected bool           `json:"rejected,omitempty"` // the address is ignored, as its host is not a valid DNS-1123 subdomain
+	Services []ServiceMatch `json:"services,omitempty"`
+}
+
+// ServiceMatch tells whether an address refers to a given Service, and if not - why
+type ServiceMatch struct {
+	Service string `json:"service"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// IngressRuleExplanation is an ingress rule of the NetworkPolicy generated for a workload, along with the reasons it is required
+type IngressRuleExplanation struct {
+	Rule    network.NetworkPolicyIngressRule `json:"rule"`
+	Reasons []string                         `json:"reasons"`
+}
+
+// EgressRuleExplanation is an egress rule of the NetworkPolicy generated for a workload, along with the reasons it is required
+type EgressRuleExplanation struct {
+	Rule    network.NetworkPolicyEgressRule `json:"rule"`
+	Reasons []string                        `json:"reasons"`
+}
+
+// ExplainWorkloadFromInfos explains the connectivity of the workload with the given namespace, kind and name,
+// as discovered while processing K8s resources in the given slice of Info objects.
+func (ps *PoliciesSynthesizer) ExplainWorkloadFromInfos(infos []*resource.Info, namespace, kind, name string) (
+	*WorkloadExplanation, error) {
+	resAcc, errs := ps.accumulateResourcesFromInfos(infos)
+	return ps.explainWorkload(resAcc, errs, namespace, kind, name)
+}
+
+// ExplainWorkloadFromFolderPath explains the connectivity of the workload with the given namespace, kind and name, as discovered
+// while processing K8s resources under the provided directory or one of its subdirectories (recursively).
+func (ps *PoliciesSynthesizer) ExplainWorkloadFromFolderPath(dirPath, namespace, kind, name string) (*WorkloadExplanation, error) {
+	return ps.ExplainWorkloadFromFolderPaths([]string{dirPath}, namespace, kind, name)
+}
+
+// ExplainWorkloadFromFolderPaths explains the connectivity of the workload with the given namespace, kind and name, as discovered
+// while processing K8s resources under the provided directories or one of their subdirectories (recursively).
+func (ps *PoliciesSynthesizer) ExplainWorkloadFromFolderPaths(dirPaths []string, namespace, kind, name string) (
+	*WorkloadExplanation, error) {
+	resAcc, errs := ps.accumulateResourcesFromFolderPaths(dirPaths)
+	return ps.explainWorkload(resAcc, errs, namespace, kind, name)
+}
+
+func (ps *PoliciesSynthesizer) explainWorkload(resAcc *resourceAccumulator, errs []FileProcessingError, namespace, kind, name string) (
+	*WorkloadExplanation, error) {
+	var explanation *WorkloadExplanation
+	if !stopProcessing(ps.stopOnError, errs) {
+		var connErrs []FileProcessingError
+		explanation, connErrs = ps.explainWorkloadConnectivity(resAcc, namespace, kind, name)
+		errs = append(errs, connErrs...)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return explanation, nil
+}
+
+func (ps *PoliciesSynthesizer) explainWorkloadConnectivity(resAcc *resourceAccumulator, namespace, kind, name string) (
+	*WorkloadExplanation, []FileProcessingError) {
+	resources, connections, errs := ps.extractConnections(resAcc)
+	if stopProcessing(ps.stopOnError, errs) {
+		return nil, errs
+	}
+	idx := slices.IndexFunc(resources, func(res *Resource) bool {
+		return namespacedName(res.Resource.Namespace, res.Resource.Name) == namespacedName(namespace, name) &&
+			strings.EqualFold(res.Resource.Kind, kind)
+	})
+	if idx < 0 {
+		return nil, appendAndLogNewError(errs, workloadNotFound(namespace, kind, name), ps.logger)
+	}
+
+	workload := resources[idx]
+	explanation := WorkloadExplanation{Workload: workload}
+	for _, svc := range findServices(workload, resAcc.services) {
+		explanation.Services = append(explanation.Services, serviceDescription(svc))
+	}
+	for _, origin := range workload.Resource.AddressOrigins {
+		matches := explainAddress(origin.Address, workload, resAcc.services, connections)
+		explanation.Addresses = append(explanation.Addresses, AddressExplanation{AddressOrigin: origin, Services: matches})
+	}
+	for _, origin := range workload.Resource.RejectedAddrs {
+		if matches := explainRejectedAddress(origin.Address, resAcc.services); len(matches) > 0 {
+			explanation.Addresses = append(explanation.Addresses, AddressExplanation{AddressOrigin: origin, Rejected: true, Services: matches})
+		}
+	}
+
+	deployConnectivity := determineConnectivityPerDeployment(resources, connections, ps.probeSourcePeers(), ps.podSelectors(resources))
+	for _, deployConn := range deployConnectivity {
+		if !deployConn.equals(workload) {
+			continue
+		}
+		ps.addExternalAndDNSEgressRules(deployConn)
+		for i := range deployConn.ingressConns {
+			rule := IngressRuleExplanation{Rule: deployConn.ingressConns[i], Reasons: deployConn.ingressReasons[i]}
+			explanation.IngressRules = append(explanation.IngressRules, rule)
+		}
+		for i := range deployConn.egressConns {
+			rule := EgressRuleExplanation{Rule: deployConn.egressConns[i], Reasons: deployConn.egressReasons[i]}
+			explanation.EgressRules = append(explanation.EgressRules, rule)
+		}
+	}
+	return &explanation, errs
+}
+
+// explainAddress tells, for each Service which is named like the host of the given address (or has the host as an alias),
+// whether the address refers to the Service, and if not - why
+func explainAddress(addr string, workload *Resource, services []*Service, connections []*Connections) []ServiceMatch {
+	host, port, _ := strings.Cut(addr, ":")
+	svcName, _, _ := strings.Cut(host, ".")
+	matches := []ServiceMatch{}
+	for _, svc := range services {
+		if svc.Resource.Name != svcName && !slices.Contains(svc.hostAliases, host) {
+			continue
+		}
+		match := ServiceMatch{Service: serviceDescription(svc)}
+		svcAddresses := getPossibleServiceAddresses(svc, workload)
+		match.Matched, _ = envValueMatchesService(addr, svc, svcAddresses)
+		connected := slices.ContainsFunc(connections, func(conn *Connections) bool {
+			return conn.Link == svc && conn.Source != nil && conn.Source.equals(workload)
+		})
+		switch {
+		case match.Matched && !connected:
+			match.Reason = "the Service does not select the pods of any other scanned workload, so no connection is required"
+		case match.Matched:
+			// the address refers to the Service, and a connection to the Service's pods is required
+		case slices.Contains(svcAddresses, host):
+			match.Reason = fmt.Sprintf("port mismatch: %s is not one of the Service's port numbers (%s)", port, servicePortNumbers(svc))
+		default:
+			match.Reason = addressNamespaceMismatch(host, workload, svc, svcAddresses)
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// addressNamespaceMismatch explains why an address host, whose first label is the name of the given Service,
+// does not refer to the Service
+func addressNamespaceMismatch(host string, workload *Resource, svc *Service, svcAddresses []string) string {
+	svcNamespace := namespaceOrDefault(svc.Resource.Namespace)
+	hostLabels := strings.Split(host, ".")
+	switch {
+	case len(hostLabels) == 1:
+		return fmt.Sprintf("namespace mismatch: the address has no namespace, so it refers to a Service in the workload's namespace %s, "+
+			"but the Service is in namespace %s", namespaceOrDefault(workload.Resource.Namespace), svcNamespace)
+	case hostLabels[1] != svcNamespace:
+		return fmt.Sprintf("namespace mismatch: the address refers to namespace %s, but the Service is in namespace %s",
+			hostLabels[1], svcNamespace)
+	}
+	return fmt.Sprintf("%s is not one of the Service's addresses (%s)", host, strings.Join(svcAddresses, ", "))
+}
+
+// explainRejectedAddress returns the Services, which the given host (not being a valid DNS-1123 subdomain) would refer to,
+// had its letters been lowercase and its underscores been dashes
+func explainRejectedAddress(host string, services []*Service) []ServiceMatch {
+	hostNoPort, _, _ := strings.Cut(host, ":")
+	svcName, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(hostNoPort, "_", "-")), ".")
+	matches := []ServiceMatch{}
+	for _, svc := range services {
+		if svc.Resource.Name == svcName {
+			reason := fmt.Sprintf("not DNS-1123: %s is not a valid DNS-1123 subdomain, so the value is ignored", hostNoPort)
+			matches = append(matches, ServiceMatch{Service: serviceDescription(svc), Reason: reason})
+		}
+	}
+	return matches
+}
+
+func servicePortNumbers(svc *Service) string {
+	ports := make([]string, 0, len(svc.Resource.Network))
+	for _, port := range svc.Resource.Network {
+		ports = append(ports, strconv.Itoa(port.Port))
+	}
+	return strings.Join(ports, ", ")
+}
+
+// String returns a human-readable form of the explanation
+func (explanation *WorkloadExplanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Workload: %s\n", workloadDescription(explanation.Workload))
+	sb.WriteString("\nSelected by:\n")
+	for _, svc := range explanation.Services {
+		fmt.Fprintf(&sb, "  %s\n", svc)
+	}
+	if len(explanation.Services) == 0 {
+		sb.WriteString("  no Service\n")
+	}
+
+	sb.WriteString("\nNetwork addresses:\n")
+	for i := range explanation.Addresses {
+		explanation.Addresses[i].writeTo(&sb)
+	}
+	if len(explanation.Addresses) == 0 {
+		sb.WriteString("  none found\n")
+	}
+
+	sb.WriteString("\nIngress rules:\n")
+	for i := range explanation.IngressRules {
+		rule := &explanation.IngressRules[i].Rule
+		writeRule(&sb, i, "from "+peersString(rule.From)+", "+portsString(rule.Ports), explanation.IngressRules[i].Reasons)
+	}
+	if len(explanation.IngressRules) == 0 {
+		sb.WriteString("  none - all ingress traffic is denied\n")
+	}
+
+	sb.WriteString("\nEgress rules:\n")
+	for i := range explanation.EgressRules {
+		rule := &explanation.EgressRules[i].Rule
+		writeRule(&sb, i, "to "+peersString(rule.To)+", "+portsString(rule.Ports), explanation.EgressRules[i].Reasons)
+	}
+	if len(explanation.EgressRules) == 0 {
+		sb.WriteString("  none - all egress traffic is denied\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (addrExplanation *AddressExplanation) writeTo(sb *strings.Builder) {
+	fmt.Fprintf(sb, "  %s\n", addrExplanation.AddressOrigin.String())
+	for _, match := range addrExplanation.Services {
+		switch {
+		case match.Matched && match.Reason == "":
+			fmt.Fprintf(sb, "    matches %s\n", match.Service)
+		case match.Matched:
+			fmt.Fprintf(sb, "    matches %s, but %s\n", match.Service, match.Reason)
+		default:
+			fmt.Fprintf(sb, "    does not match %s - %s\n", match.Service, match.Reason)
+		}
+	}
+	if len(addrExplanation.Services) == 0 {
+		sb.WriteString("    matches no Service\n")
+	}
+}
+
+func writeRule(sb *strings.Builder, idx int, rule string, reasons []string) {
+	fmt.Fprintf(sb, "  [%d] %s\n", idx, rule)
+	for _, reason := range reasons {
+		fmt.Fprintf(sb, "      because %s\n", reason)
+	}
+}
+
+func peersString(peers []network.NetworkPolicyPeer) string {
+	if len(peers) == 0 {
+		return "anywhere"
+	}
+	peerStrings := make([]string, 0, len(peers))
+	for i := range peers {
+		peerStrings = append(peerStrings, peerString(&peers[i]))
+	}
+	return strings.Join(peerStrings, " or ")
+}
+
+func peerString(peer *network.NetworkPolicyPeer) string {
+	if peer.IPBlock != nil {
+		return peer.IPBlock.CIDR
+	}
+	pods := "all pods"
+	if peer.PodSelector != nil && !isEmptySelector(peer.PodSelector) {
+		pods = "pods " + metaV1.FormatLabelSelector(peer.PodSelector)
+	}
+	switch {
+	case peer.NamespaceSelector == nil:
+		return pods + " in the same namespace"
+	case isEmptySelector(peer.NamespaceSelector):
+		return pods + " in all namespaces"
+	}
+	return pods + " in namespaces " + metaV1.FormatLabelSelector(peer.NamespaceSelector)
+}
+
+func isEmptySelector(selector *metaV1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+func portsString(ports []network.NetworkPolicyPort) string {
+	if len(ports) == 0 {
+		return "all ports"
+	}
+	portStrings := make([]string, 0, len(ports))
+	for i := range ports {
+		protocol := "TCP"
+		if ports[i].Protocol != nil {
+			protocol = string(*ports[i].Protocol)
+		}
+		if ports[i].Port == nil {
+			portStrings = append(portStrings, protocol)
+		} else {
+			portStrings = append(portStrings, protocol+"/"+ports[i].Port.String())
+		}
+	}
+	return "ports " + strings.Join(portStrings, ", ")
+}
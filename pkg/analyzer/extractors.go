@@ -0,0 +1,272 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+
+	ocappsv1 "github.com/openshift/api/apps/v1"
+	ocroutev1 "github.com/openshift/api/route/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// WorkloadExtractor is a function which extracts the pod template from a workload resource of a specific kind.
+// The pod template is then analyzed in the same way as the pod templates of the built-in workload kinds.
+type WorkloadExtractor func(obj *unstructured.Unstructured) (*corev1.PodTemplateSpec, error)
+
+// ExposedServicePort identifies a Service port to which a resource (e.g., an Ingress) routes traffic from outside the cluster
+type ExposedServicePort struct {
+	Namespace   string
+	ServiceName string
+	Port        intstr.IntOrString // if zero, all the Service's ports are exposed
+	Protocol    corev1.Protocol    // if empty, the Service port is exposed regardless of its protocol
+	// HTTPPaths optionally holds regular expressions of the HTTP request paths routed to the Service port (empty means all paths)
+	HTTPPaths []string
+	// ParentGateways lists the Gateways through which the Service port is exposed (only relevant for Gateway API routes)
+	ParentGateways []types.NamespacedName
+
+	exposedBy string // the resource exposing the Service port, and the file in which it is defined
+}
+
+func (esp *ExposedServicePort) matchesPort(port *SvcNetworkAttr) bool {
+	return esp.Port == intstr.IntOrString{} || port.equals(&esp.Port)
+}
+
+func (esp *ExposedServicePort) matchesProtocol(protocol corev1.Protocol) bool {
+	return esp.Protocol == "" || esp.Protocol == protocolOrDefault(protocol)
+}
+
+// ExposureExtractor is a function which extracts the Service ports exposed by a resource of a specific kind (e.g., a route)
+type ExposureExtractor func(obj *unstructured.Unstructured) ([]ExposedServicePort, error)
+
+// workloadKind holds what is needed to analyze resources of a given workload kind
+type workloadKind struct {
+	extract WorkloadExtractor
+	// impliedService optionally returns a Service which is implicitly created for the workload (e.g., for Knative Services)
+	impliedService func(obj *unstructured.Unstructured) *Service
+	// kind optionally replaces the manifest's kind in the workload's identity, when that kind is ambiguous (e.g., Knative Services)
+	kind string
+}
+
+// extractorRegistry maps each supported GroupVersionKind to the function which extracts the relevant information from its resources
+type extractorRegistry struct {
+	workloads map[schema.GroupVersionKind]*workloadKind
+	exposures map[schema.GroupVersionKind]ExposureExtractor
+	versions  map[string][]string // for each registered kind, the list of supported API versions (group/version)
+	// ignoredGroups lists API groups whose kinds are silently skipped, even if named like a registered kind
+	// (e.g., Calico NetworkPolicies, which are policies like K8s NetworkPolicies, rather than an unsupported version of them)
+	ignoredGroups []string
+}
+
+var (
+	appsV1beta1       = schema.GroupVersion{Group: appsv1.GroupName, Version: "v1beta1"}
+	appsV1beta2       = schema.GroupVersion{Group: appsv1.GroupName, Version: "v1beta2"}
+	extensionsV1beta1 = schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+	networkV1beta1    = schema.GroupVersion{Group: networkv1.GroupName, Version: "v1beta1"}
+	batchV1beta1      = schema.GroupVersion{Group: batchv1.GroupName, Version: "v1beta1"}
+	gatewayV1beta1    = schema.GroupVersion{Group: gatewayv1.GroupName, Version: "v1beta1"}
+	gatewayV1alpha2   = schema.GroupVersion{Group: gatewayv1.GroupName, Version: "v1alpha2"}
+	openshiftLegacyV1 = corev1.SchemeGroupVersion // OpenShift 3 served its kinds also under the legacy "v1" API version
+
+	argoRolloutsGroupVersion   = schema.GroupVersion{Group: "argoproj.io", Version: "v1alpha1"}
+	knativeServingGroupVersion = schema.GroupVersion{Group: knativeServingGroup, Version: "v1"}
+)
+
+// newExtractorRegistry creates a registry with extractors for all the built-in workload kinds and exposing kinds,
+// in all their supported API versions
+func newExtractorRegistry() *extractorRegistry {
+	er := &extractorRegistry{
+		workloads: map[schema.GroupVersionKind]*workloadKind{},
+		exposures: map[schema.GroupVersionKind]ExposureExtractor{},
+		versions:  map[string][]string{},
+	}
+
+	er.registerWorkload(pod, podTemplateExtractor(func(obj *corev1.Pod) *corev1.PodTemplateSpec {
+		return &corev1.PodTemplateSpec{Spec: obj.Spec, ObjectMeta: obj.ObjectMeta}
+	}), corev1.SchemeGroupVersion)
+	er.registerWorkload(replicationController,
+		podTemplateExtractor(func(obj *corev1.ReplicationController) *corev1.PodTemplateSpec { return obj.Spec.Template }),
+		corev1.SchemeGroupVersion)
+	er.registerWorkload(replicaSet,
+		podTemplateExtractor(func(obj *appsv1.ReplicaSet) *corev1.PodTemplateSpec { return &obj.Spec.Template }),
+		appsv1.SchemeGroupVersion, appsV1beta2, extensionsV1beta1)
+	er.registerWorkload(deployment,
+		podTemplateExtractor(func(obj *appsv1.Deployment) *corev1.PodTemplateSpec { return &obj.Spec.Template }),
+		appsv1.SchemeGroupVersion, appsV1beta2, appsV1beta1, extensionsV1beta1)
+	er.registerWorkload(daemonSet,
+		podTemplateExtractor(func(obj *appsv1.DaemonSet) *corev1.PodTemplateSpec { return &obj.Spec.Template }),
+		appsv1.SchemeGroupVersion, appsV1beta2, extensionsV1beta1)
+	er.registerWorkload(statefulSet,
+		podTemplateExtractor(func(obj *appsv1.StatefulSet) *corev1.PodTemplateSpec { return &obj.Spec.Template }),
+		appsv1.SchemeGroupVersion, appsV1beta2, appsV1beta1)
+	er.registerWorkload(job,
+		podTemplateExtractor(func(obj *batchv1.Job) *corev1.PodTemplateSpec { return &obj.Spec.Template }),
+		batchv1.SchemeGroupVersion)
+	er.registerWorkload(cronJob,
+		podTemplateExtractor(func(obj *batchv1.CronJob) *corev1.PodTemplateSpec { return &obj.Spec.JobTemplate.Spec.Template }),
+		batchv1.SchemeGroupVersion, batchV1beta1)
+	er.registerWorkload(deploymentConfig,
+		podTemplateExtractor(func(obj *ocappsv1.DeploymentConfig) *corev1.PodTemplateSpec { return obj.Spec.Template }),
+		ocappsv1.GroupVersion, openshiftLegacyV1)
+	er.registerWorkload(rollout, argoRolloutTemplate, argoRolloutsGroupVersion)
+	er.registerWorkload(service, knativeServiceTemplate, knativeServingGroupVersion)
+	er.workloads[knativeServingGroupVersion.WithKind(service)].impliedService = knativeImpliedService
+	er.workloads[knativeServingGroupVersion.WithKind(service)].kind = knativeService
+
+	er.registerExposure(route, ocRouteExposure, ocroutev1.GroupVersion, openshiftLegacyV1)
+	er.registerExposure(ingress, k8sIngressExposure, networkv1.SchemeGroupVersion)
+	er.registerExposure(ingress, k8sIngressV1beta1Exposure, networkV1beta1, extensionsV1beta1)
+	er.registerExposure(httpRoute, gatewayHTTPRouteExposure, gatewayv1.SchemeGroupVersion, gatewayV1beta1, gatewayV1alpha2)
+	er.registerExposure(grpcRoute, gatewayGRPCRouteExposure, gatewayv1.SchemeGroupVersion, gatewayV1alpha2)
+	er.registerExposure(tcpRoute, gatewayTCPRouteExposure, gatewayV1alpha2)
+	er.registerExposure(tlsRoute, gatewayTLSRouteExposure, gatewayV1alpha2)
+	er.registerExposure(udpRoute, gatewayUDPRouteExposure, gatewayV1alpha2)
+
+	er.registerVersion(corev1.SchemeGroupVersion.WithKind(service)) // Services and ConfigMaps are parsed without extractors
+	er.registerVersion(corev1.SchemeGroupVersion.WithKind(configmap))
+	er.registerVersion(networkv1.SchemeGroupVersion.WithKind(networkPolicy)) // so are Gateways, ReferenceGrants and NetworkPolicies
+	er.registerVersion(gatewayv1.SchemeGroupVersion.WithKind(gateway))
+	er.registerVersion(gatewayV1beta1.WithKind(gateway))
+	er.registerVersion(gatewayV1beta1.WithKind(referenceGrant))
+	er.registerVersion(gatewayV1alpha2.WithKind(referenceGrant))
+	for _, gv := range istioNetworkingVersions { // Istio networking resources are parsed without extractors
+		er.registerVersion(gv.WithKind(virtualService))
+		er.registerVersion(gv.WithKind(destinationRule))
+		er.registerVersion(gv.WithKind(serviceEntry))
+		er.registerVersion(gv.WithKind(gateway))
+	}
+	er.ignoredGroups = []string{calicoGroup, calicoCRDGroup}
+
+	return er
+}
+
+// registerWorkload registers the given extractor for the given workload kind in the given API versions.
+// If a GroupVersionKind is already registered, only its extractor is replaced; its other handling (e.g., an implied Service) is kept.
+func (er *extractorRegistry) registerWorkload(kind string, extractor WorkloadExtractor, groupVersions ...schema.GroupVersion) {
+	for _, gv := range groupVersions {
+		if wk, ok := er.workloads[gv.WithKind(kind)]; ok {
+			wk.extract = extractor
+			continue
+		}
+		er.workloads[gv.WithKind(kind)] = &workloadKind{extract: extractor}
+		er.registerVersion(gv.WithKind(kind))
+	}
+}
+
+func (er *extractorRegistry) registerExposure(kind string, extractor ExposureExtractor, groupVersions ...schema.GroupVersion) {
+	for _, gv := range groupVersions {
+		er.exposures[gv.WithKind(kind)] = extractor
+		er.registerVersion(gv.WithKind(kind))
+	}
+}
+
+func (er *extractorRegistry) registerVersion(gvk schema.GroupVersionKind) {
+	apiVersion := gvk.GroupVersion().String()
+	if !slices.Contains(er.versions[gvk.Kind], apiVersion) {
+		er.versions[gvk.Kind] = append(er.versions[gvk.Kind], apiVersion)
+	}
+}
+
+// workloadKind returns how to analyze workload resources of the given GroupVersionKind (if it is a registered workload kind)
+func (er *extractorRegistry) workloadKind(gvk schema.GroupVersionKind) (*workloadKind, bool) {
+	wk, ok := er.workloads[gvk]
+	return wk, ok
+}
+
+// exposureExtractor returns the extractor of exposed Service ports for the given GroupVersionKind (if it is a registered kind)
+func (er *extractorRegistry) exposureExtractor(gvk schema.GroupVersionKind) (ExposureExtractor, bool) {
+	extractor, ok := er.exposures[gvk]
+	return extractor, ok
+}
+
+// supportedVersions returns the API versions (group/version) in which the given kind is supported,
+// or nil if the kind is not supported in any group
+func (er *extractorRegistry) supportedVersions(kind string) []string {
+	return er.versions[kind]
+}
+
+// isUnsupportedVersion checks whether the given GroupVersionKind is named like a registered kind, but is not registered:
+// a same-named kind from an unknown group, or an unknown version. Kinds of ignored groups are never reported.
+func (er *extractorRegistry) isUnsupportedVersion(gvk schema.GroupVersionKind) bool {
+	return len(er.supportedVersions(gvk.Kind)) > 0 && !slices.Contains(er.supportedVersions(gvk.Kind), gvk.GroupVersion().String()) &&
+		!slices.Contains(er.ignoredGroups, gvk.Group)
+}
+
+// podTemplateExtractor returns a WorkloadExtractor for a workload kind with a Go type,
+// using the given function to get the pod template from an object of this type
+func podTemplateExtractor[T any](template func(*T) *corev1.PodTemplateSpec) WorkloadExtractor {
+	return func(obj *unstructured.Unstructured) (*corev1.PodTemplateSpec, error) {
+		typedObj := parseResourceFromUnstructured[T](obj)
+		if typedObj == nil {
+			return nil, fmt.Errorf("failed to parse %s resource", obj.GetKind())
+		}
+		return template(typedObj), nil
+	}
+}
+
+func argoRolloutTemplate(obj *unstructured.Unstructured) (*corev1.PodTemplateSpec, error) {
+	podSpecV1, err := podTemplateFromUnstructured(obj, "spec", "template")
+	if err != nil {
+		return nil, err
+	}
+	delete(podSpecV1.Labels, "rollouts-pod-template-hash") // auto-generated - better not use it in netpols
+	return podSpecV1, nil
+}
+
+const (
+	knativeServiceLabel    = "serving.knative.dev/service"
+	knativeVisibilityLabel = "networking.knative.dev/visibility"
+	knativeClusterLocal    = "cluster-local"
+	knativeServicePort     = 80
+	knativeQueueProxyPort  = 8012 // requests reach Knative pods through the injected queue-proxy sidecar
+	knativeQueueProxyName  = "queue-port"
+)
+
+// knativeServiceTemplate returns the pod template of a Knative Service, as completed by Knative:
+// pods are labeled with the Knative Service name and a queue-proxy sidecar is injected into them
+func knativeServiceTemplate(obj *unstructured.Unstructured) (*corev1.PodTemplateSpec, error) {
+	podSpecV1, err := podTemplateFromUnstructured(obj, "spec", "template")
+	if err != nil {
+		return nil, err
+	}
+	if podSpecV1.Labels == nil {
+		podSpecV1.Labels = map[string]string{}
+	}
+	podSpecV1.Labels[knativeServiceLabel] = obj.GetName()
+	queueProxy := corev1.Container{
+		Name:  "queue-proxy",
+		Ports: []corev1.ContainerPort{{Name: knativeQueueProxyName, ContainerPort: knativeQueueProxyPort}},
+	}
+	podSpecV1.Spec.Containers = append([]corev1.Container{queueProxy}, podSpecV1.Spec.Containers...)
+	return podSpecV1, nil
+}
+
+// knativeImpliedService returns the K8s Service which Knative creates to route traffic to the Knative Service's pods.
+// Unless labeled as cluster-local, a Knative Service is exposed outside the cluster.
+func knativeImpliedService(obj *unstructured.Unstructured) *Service {
+	clusterLocal := obj.GetLabels()[knativeVisibilityLabel] == knativeClusterLocal
+	var serviceCtx Service
+	serviceCtx.Resource.Name = obj.GetName()
+	serviceCtx.Resource.Namespace = obj.GetNamespace()
+	serviceCtx.Resource.Kind = service
+	serviceCtx.Resource.Type = corev1.ServiceTypeClusterIP
+	serviceCtx.Resource.Selectors = matchLabelSelectorToStrLabels(map[string]string{knativeServiceLabel: obj.GetName()})
+	serviceCtx.Resource.ExposeExternally = !clusterLocal
+	serviceCtx.Resource.Network = []SvcNetworkAttr{
+		{Port: knativeServicePort, TargetPort: intstr.FromInt(knativeQueueProxyPort), exposeToCluster: clusterLocal},
+	}
+	return &serviceCtx
+}
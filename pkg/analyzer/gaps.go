@@ -0,0 +1,381 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	network "k8s.io/api/networking/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// PolicyGapReport compares the connectivity an application needs with the connectivity allowed by the NetworkPolicies
+// already found among its manifests
+type PolicyGapReport struct {
+	Policies           []string            `json:"policies"` // the existing NetworkPolicies which were evaluated
+	BlockedConnections []BlockedConnection `json:"blocked_connections,omitempty"`
+	UnneededFlows      []UnneededFlow      `json:"unneeded_flows,omitempty"`
+}
+
+// BlockedConnection is a discovered connection, which the existing NetworkPolicies deny on some (or all) of its ports
+type BlockedConnection struct {
+	Source  string   `json:"source"` // a workload, "outside the cluster", or the ingress pods from which an exposed Service is reached
+	Target  string   `json:"target"`
+	Service string   `json:"service"`
+	Ports   []string `json:"ports"` // the denied ports of the target workload
+	Reasons []string `json:"reasons"`
+}
+
+// UnneededFlow is traffic between two workloads (or from outside the cluster to a workload), which the existing NetworkPolicies
+// explicitly allow, but which no discovered connection needs. Traffic which is allowed only because no policy selects
+// either of its ends is not reported.
+type UnneededFlow struct {
+	Source    string   `json:"source"`
+	Target    string   `json:"target"`
+	Ports     []string `json:"ports"`      // the allowed ports of the target workload
+	AllowedBy []string `json:"allowed_by"` // the policies allowing the traffic
+}
+
+// IsEmpty checks whether the existing policies allow exactly the needed connectivity (as far as the report can tell)
+func (report *PolicyGapReport) IsEmpty() bool {
+	return len(report.BlockedConnections) == 0 && len(report.UnneededFlows) == 0
+}
+
+// PolicyGapsFromInfos compares the connectivity discovered while processing K8s resources in the given slice of Info objects
+// with the connectivity allowed by the NetworkPolicies among these resources.
+func (ps *PoliciesSynthesizer) PolicyGapsFromInfos(infos []*resource.Info) (*PolicyGapReport, error) {
+	resAcc, errs := ps.accumulateResourcesFromInfos(infos)
+	return ps.policyGaps(resAcc, errs)
+}
+
+// PolicyGapsFromFolderPath compares the connectivity discovered while processing K8s resources under the provided directory
+// or one of its subdirectories (recursively) with the connectivity allowed by the NetworkPolicies among these resources.
+func (ps *PoliciesSynthesizer) PolicyGapsFromFolderPath(dirPath string) (*PolicyGapReport, error) {
+	return ps.PolicyGapsFromFolderPaths([]string{dirPath})
+}
+
+// PolicyGapsFromFolderPaths compares the connectivity discovered while processing K8s resources under the provided directories
+// or one of their subdirectories (recursively) with the connectivity allowed by the NetworkPolicies among these resources.
+func (ps *PoliciesSynthesizer) PolicyGapsFromFolderPaths(dirPaths []string) (*PolicyGapReport, error) {
+	resAcc, errs := ps.accumulateResourcesFromFolderPaths(dirPaths)
+	return ps.policyGaps(resAcc, errs)
+}
+
+func (ps *PoliciesSynthesizer) policyGaps(resAcc *resourceAccumulator, errs []FileProcessingError) (*PolicyGapReport, error) {
+	var report *PolicyGapReport
+	if !stopProcessing(ps.stopOnError, errs) {
+		resources, connections, connErrs := ps.extractConnections(resAcc)
+		errs = append(errs, connErrs...)
+		if !stopProcessing(ps.stopOnError, errs) {
+			report = findPolicyGaps(resources, resAcc.services, connections, resAcc.networkPolicies)
+		}
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func findPolicyGaps(resources []*Resource, services []*Service, connections []*Connections,
+	policies []*network.NetworkPolicy) *PolicyGapReport {
+	report := PolicyGapReport{Policies: []string{}}
+	for _, policy := range policies {
+		report.Policies = append(report.Policies, namespacedName(policy.Namespace, policy.Name))
+	}
+	slices.Sort(report.Policies)
+	report.BlockedConnections = blockedConnections(connections, policies)
+	report.UnneededFlows = unneededFlows(resources, services, connections, policies)
+	return &report
+}
+
+// connectionFlow is the traffic of a discovered connection from a single source
+type connectionFlow struct {
+	sourceName string
+	source     *Resource // nil if the source is not a workload
+	sourcePeer FlowPeer
+	ports      []FlowPort // the destination ports on the target workload
+}
+
+// connectionFlows returns the traffic of the given connection. A source-less connection has a flow from outside the cluster
+// if its Service is exposed externally, and otherwise a flow from each of the ingress sources of its exposed ports.
+func connectionFlows(conn *Connections) []connectionFlow {
+	switch {
+	case conn.Source != nil:
+		return []connectionFlow{{workloadIdentity(conn.Source), conn.Source, workloadPeer(conn.Source),
+			targetFlowPorts(connectionPorts(conn), conn.Target)}}
+	case conn.Link.Resource.ExposeExternally:
+		return []connectionFlow{{exposedOutsideCluster, nil, FlowPeer{External: true}, targetFlowPorts(conn.Link.Resource.Network, conn.Target)}}
+	}
+
+	sources, portsPerSource := exposedPortsBySource(conn.Link.Resource.Network)
+	flows := make([]connectionFlow, 0, len(sources))
+	for i := range sources {
+		flows = append(flows, connectionFlow{ingressSourceName(&sources[i]), nil, ingressSourcePeer(&sources[i]),
+			targetFlowPorts(portsPerSource[i], conn.Target)})
+	}
+	return flows
+}
+
+func ingressSourceName(source *IngressSource) string {
+	name := "all pods"
+	if len(source.PodLabels) > 0 {
+		name = "pods " + k8slabels.Set(source.PodLabels).String()
+	}
+	if source.Namespace == "" {
+		return name + " in the cluster"
+	}
+	return name + " in namespace " + source.Namespace
+}
+
+// blockedConnections returns the discovered connections which the given policies deny on some of their ports
+func blockedConnections(connections []*Connections, policies []*network.NetworkPolicy) []BlockedConnection {
+	blocked := []BlockedConnection{}
+	for _, conn := range connections {
+		for _, flow := range connectionFlows(conn) {
+			blockedConn := BlockedConnection{Source: flow.sourceName, Target: workloadIdentity(conn.Target), Service: serviceIdentity(conn.Link)}
+			for i := range flow.ports {
+				reasons := flowDenialReasons(policies, &flow, conn.Target, &flow.ports[i])
+				if len(reasons) == 0 {
+					continue
+				}
+				blockedConn.Ports = appendUnique(blockedConn.Ports, flow.ports[i].key())
+				for _, reason := range reasons {
+					blockedConn.Reasons = appendUnique(blockedConn.Reasons, reason)
+				}
+			}
+			if len(blockedConn.Ports) > 0 {
+				blocked = append(blocked, blockedConn)
+			}
+		}
+	}
+	slices.SortFunc(blocked, func(b1, b2 BlockedConnection) int {
+		return strings.Compare(b1.Source+" "+b1.Target+" "+b1.Service, b2.Source+" "+b2.Target+" "+b2.Service)
+	})
+	return blocked
+}
+
+// flowDenialReasons tells which policies deny the given flow to the given target port; returns an empty slice if the flow is allowed
+func flowDenialReasons(policies []*network.NetworkPolicy, flow *connectionFlow, target *Resource, port *FlowPort) []string {
+	reasons := []string{}
+	targetPeer := workloadPeer(target)
+	if flow.source != nil {
+		if verdict := evaluatePolicies(policies, &flow.sourcePeer, network.PolicyTypeEgress, &targetPeer, port); !verdict.allowed {
+			reasons = append(reasons, "egress denied by "+strings.Join(verdict.policies, ", "))
+		}
+	}
+	if verdict := evaluatePolicies(policies, &targetPeer, network.PolicyTypeIngress, &flow.sourcePeer, port); !verdict.allowed {
+		reasons = append(reasons, "ingress denied by "+strings.Join(verdict.policies, ", "))
+	}
+	return reasons
+}
+
+// unneededFlows returns the flows to the ports of the given workloads, which the given policies explicitly allow,
+// but which none of the given connections needs. Flows from all other workloads and from outside the cluster are checked.
+func unneededFlows(resources []*Resource, services []*Service, connections []*Connections,
+	policies []*network.NetworkPolicy) []UnneededFlow {
+	needed := neededFlows{flows: map[string]bool{}, ingressPeers: map[string][]FlowPeer{}}
+	for _, conn := range connections {
+		for _, flow := range connectionFlows(conn) {
+			for i := range flow.ports {
+				needed.add(&flow, conn.Target, &flow.ports[i])
+			}
+		}
+	}
+
+	flows := []UnneededFlow{}
+	for _, target := range resources {
+		ports := workloadFlowPorts(target, services)
+		for _, source := range resources {
+			if source != target {
+				flow := connectionFlow{workloadIdentity(source), source, workloadPeer(source), ports}
+				flows = appendUnneededFlow(flows, policies, &needed, &flow, target)
+			}
+		}
+		external := connectionFlow{exposedOutsideCluster, nil, FlowPeer{External: true}, ports}
+		flows = appendUnneededFlow(flows, policies, &needed, &external, target)
+	}
+	slices.SortFunc(flows, func(f1, f2 UnneededFlow) int {
+		return strings.Compare(f1.Source+" "+f1.Target, f2.Source+" "+f2.Target)
+	})
+	return flows
+}
+
+func appendUnneededFlow(flows []UnneededFlow, policies []*network.NetworkPolicy, needed *neededFlows, flow *connectionFlow,
+	target *Resource) []UnneededFlow {
+	unneeded := UnneededFlow{Source: flow.sourceName, Target: workloadIdentity(target)}
+	for i := range flow.ports {
+		port := &flow.ports[i]
+		if needed.contains(flow, target, port) {
+			continue
+		}
+		allowedBy := allowingPolicies(policies, flow, target, port)
+		if len(allowedBy) == 0 {
+			continue
+		}
+		unneeded.Ports = appendUnique(unneeded.Ports, port.key())
+		for _, policy := range allowedBy {
+			unneeded.AllowedBy = appendUnique(unneeded.AllowedBy, policy)
+		}
+	}
+	if len(unneeded.Ports) == 0 {
+		return flows
+	}
+	slices.Sort(unneeded.AllowedBy)
+	return append(flows, unneeded)
+}
+
+// allowingPolicies returns the policies which explicitly allow the given flow to the given target port.
+// Returns an empty slice if the flow is denied, or if it is allowed only because no policy selects either of its ends.
+func allowingPolicies(policies []*network.NetworkPolicy, flow *connectionFlow, target *Resource, port *FlowPort) []string {
+	targetPeer := workloadPeer(target)
+	ingress := evaluatePolicies(policies, &targetPeer, network.PolicyTypeIngress, &flow.sourcePeer, port)
+	if !ingress.allowed {
+		return nil
+	}
+	allowedBy := ingress.policies
+	if flow.source != nil {
+		egress := evaluatePolicies(policies, &flow.sourcePeer, network.PolicyTypeEgress, &targetPeer, port)
+		if !egress.allowed {
+			return nil
+		}
+		allowedBy = append(allowedBy, egress.policies...)
+	}
+	return allowedBy
+}
+
+// workloadFlowPorts returns the ports of the given workload: the ports its containers declare,
+// and the target ports of the Services which select it
+func workloadFlowPorts(res *Resource, services []*Service) []FlowPort {
+	ports := []FlowPort{}
+	keys := []string{}
+	addPort := func(port FlowPort) {
+		if !slices.Contains(keys, port.key()) {
+			keys = append(keys, port.key())
+			ports = append(ports, port)
+		}
+	}
+	for _, containerPort := range res.Resource.ContainerPorts {
+		addPort(workloadFlowPort(res, intstr.FromInt(containerPort.Port), protocolOrDefault(containerPort.Protocol)))
+	}
+	for _, svc := range findServices(res, services) {
+		for _, port := range targetFlowPorts(svc.Resource.Network, res) {
+			addPort(port)
+		}
+	}
+	return ports
+}
+
+// neededFlows records the flows which the discovered connections need
+type neededFlows struct {
+	flows        map[string]bool       // keyed by flowKey()
+	ingressPeers map[string][]FlowPeer // the ingress sources from which each target port is reached, keyed by targetPortKey()
+}
+
+func (nf *neededFlows) add(flow *connectionFlow, target *Resource, port *FlowPort) {
+	nf.flows[flowKey(flow.sourceName, target, port)] = true
+	if flow.source == nil && !flow.sourcePeer.External {
+		key := targetPortKey(target, port)
+		nf.ingressPeers[key] = append(nf.ingressPeers[key], flow.sourcePeer)
+	}
+}
+
+// contains checks whether the given flow is needed, either by a connection from the same source,
+// or by a Service exposure to ingress sources which include the source
+func (nf *neededFlows) contains(flow *connectionFlow, target *Resource, port *FlowPort) bool {
+	if nf.flows[flowKey(flow.sourceName, target, port)] {
+		return true
+	}
+	return flow.source != nil && slices.ContainsFunc(nf.ingressPeers[targetPortKey(target, port)], func(peer FlowPeer) bool {
+		return peer.includes(&flow.sourcePeer)
+	})
+}
+
+func targetPortKey(target *Resource, port *FlowPort) string {
+	return workloadIdentity(target) + " " + port.key()
+}
+
+func flowKey(sourceName string, target *Resource, port *FlowPort) string {
+	return sourceName + " " + targetPortKey(target, port)
+}
+
+// String returns a human-readable form of the report
+func (report *PolicyGapReport) String() string {
+	var sb strings.Builder
+	if len(report.Policies) == 0 {
+		sb.WriteString("No existing NetworkPolicies found\n")
+	} else {
+		fmt.Fprintf(&sb, "Existing NetworkPolicies: %s\n", strings.Join(report.Policies, ", "))
+	}
+	if report.IsEmpty() {
+		sb.WriteString("No gaps between the discovered connectivity and the existing NetworkPolicies")
+		return sb.String()
+	}
+	sb.WriteString("\n")
+	if len(report.BlockedConnections) > 0 {
+		sb.WriteString("Blocked connections:\n")
+		for i := range report.BlockedConnections {
+			blocked := &report.BlockedConnections[i]
+			fmt.Fprintf(&sb, "  ! %s -> %s via %s: %s\n", blocked.Source, blocked.Target, blocked.Service, strings.Join(blocked.Ports, ", "))
+			for _, reason := range blocked.Reasons {
+				fmt.Fprintf(&sb, "      %s\n", reason)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	if len(report.UnneededFlows) > 0 {
+		sb.WriteString("Allowed flows which no discovered connection needs:\n")
+		for i := range report.UnneededFlows {
+			unneeded := &report.UnneededFlows[i]
+			fmt.Fprintf(&sb, "  ~ %s -> %s: %s\n", unneeded.Source, unneeded.Target, strings.Join(unneeded.Ports, ", "))
+			fmt.Fprintf(&sb, "      allowed by %s\n", strings.Join(unneeded.AllowedBy, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n\n")
+}
+
+// Markdown returns the report as Markdown tables, suitable for a pull-request comment
+func (report *PolicyGapReport) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("### NetworkPolicy gaps\n\n")
+	if len(report.Policies) == 0 {
+		sb.WriteString("No existing NetworkPolicies found\n\n")
+	} else {
+		fmt.Fprintf(&sb, "Existing NetworkPolicies: %s\n\n", markdownCell(strings.Join(report.Policies, ", ")))
+	}
+	if report.IsEmpty() {
+		sb.WriteString("No gaps between the discovered connectivity and the existing NetworkPolicies\n")
+		return sb.String()
+	}
+	if len(report.BlockedConnections) > 0 {
+		sb.WriteString("#### Blocked connections\n\n| Source | Target | Service | Ports | Reasons |\n| --- | --- | --- | --- | --- |\n")
+		for i := range report.BlockedConnections {
+			blocked := &report.BlockedConnections[i]
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s |\n", markdownCell(blocked.Source), markdownCell(blocked.Target),
+				markdownCell(blocked.Service), markdownCell(strings.Join(blocked.Ports, ", ")), markdownCell(strings.Join(blocked.Reasons, "\n")))
+		}
+		sb.WriteString("\n")
+	}
+	if len(report.UnneededFlows) > 0 {
+		sb.WriteString("#### Allowed flows which no discovered connection needs\n\n")
+		sb.WriteString("| Source | Target | Ports | Allowed by |\n| --- | --- | --- | --- |\n")
+		for i := range report.UnneededFlows {
+			unneeded := &report.UnneededFlows[i]
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", markdownCell(unneeded.Source), markdownCell(unneeded.Target),
+				markdownCell(strings.Join(unneeded.Ports, ", ")), markdownCell(strings.Join(unneeded.AllowedBy, ", ")))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
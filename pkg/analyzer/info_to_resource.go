@@ -9,74 +9,101 @@ package analyzer
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
 
 	ocroutev1 "github.com/openshift/api/route/v1"
-	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
+	networkv1beta1 "k8s.io/api/networking/v1beta1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/cli-runtime/pkg/resource"
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 )
 
 const RouteBackendServiceKind = "Service"
 
-// k8sWorkloadObjectFromInfo creates a Resource object from an Info object
-func k8sWorkloadObjectFromInfo(info *resource.Info) (*Resource, error) {
-	var podSpecV1 *v1.PodTemplateSpec
+// invalidHostPattern matches strings which look like hosts, but may not be valid DNS-1123 subdomains (e.g., "Redis_Master")
+var invalidHostPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// k8sWorkloadObjectFromInfo creates a Resource object from an Info object, using the given workload extractor
+func k8sWorkloadObjectFromInfo(info *resource.Info, extract WorkloadExtractor) (*Resource, error) {
+	obj, ok := info.Object.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse workload resource")
+	}
+	podSpecV1, err := extract(obj)
+	if err != nil {
+		return nil, err
+	}
+	if podSpecV1 == nil {
+		return nil, fmt.Errorf("%s has no pod template", obj.GetKind())
+	}
+
 	var resourceCtx Resource
-	var metaObj metaV1.Object
 	resourceCtx.Resource.FilePath = info.Source
-	resourceCtx.Resource.Kind = info.Object.GetObjectKind().GroupVersionKind().Kind
-	switch resourceCtx.Resource.Kind {
-	case pod:
-		obj := parseResourceFromInfo[v1.Pod](info)
-		podSpecV1 = &v1.PodTemplateSpec{Spec: obj.Spec, ObjectMeta: obj.ObjectMeta}
-		metaObj = obj
-	case replicaSet:
-		obj := parseResourceFromInfo[appsv1.ReplicaSet](info)
-		podSpecV1 = &obj.Spec.Template
-		metaObj = obj
-	case replicationController:
-		obj := parseResourceFromInfo[v1.ReplicationController](info)
-		podSpecV1 = obj.Spec.Template
-		metaObj = obj
-	case deployment:
-		obj := parseResourceFromInfo[appsv1.Deployment](info)
-		podSpecV1 = &obj.Spec.Template
-		metaObj = obj
-	case daemonSet:
-		obj := parseResourceFromInfo[appsv1.DaemonSet](info)
-		podSpecV1 = &obj.Spec.Template
-		metaObj = obj
-	case statefulSet:
-		obj := parseResourceFromInfo[appsv1.StatefulSet](info)
-		podSpecV1 = &obj.Spec.Template
-		metaObj = obj
-	case cronJob:
-		obj := parseResourceFromInfo[batchv1.CronJob](info)
-		podSpecV1 = &obj.Spec.JobTemplate.Spec.Template
-		metaObj = obj
-	case job:
-		obj := parseResourceFromInfo[batchv1.Job](info)
-		podSpecV1 = &obj.Spec.Template
-		metaObj = obj
-	default:
-		return nil, fmt.Errorf("unsupported object type: `%s`", resourceCtx.Resource.Kind)
-	}
-
-	parseDeployResource(podSpecV1, metaObj, &resourceCtx)
+	resourceCtx.Resource.Kind = obj.GetKind()
+	parseDeployResource(podSpecV1, obj, &resourceCtx)
+	resourceCtx.Resource.Selector = workloadSelector(obj)
 	return &resourceCtx, nil
 }
 
+// workloadSelector returns the selector with which the given workload selects its pods,
+// or nil if the workload has no selector (e.g., a Pod) or its selector cannot be parsed
+func workloadSelector(obj *unstructured.Unstructured) *metaV1.LabelSelector {
+	fields := []string{"spec", "selector"}
+	switch obj.GetKind() {
+	case cronJob:
+		fields = []string{"spec", "jobTemplate", "spec", "selector"}
+	case replicationController, deploymentConfig: // these kinds use a plain label map as their selector
+		matchLabels, found, err := unstructured.NestedStringMap(obj.Object, fields...)
+		if err != nil || !found || len(matchLabels) == 0 {
+			return nil
+		}
+		return &metaV1.LabelSelector{MatchLabels: matchLabels}
+	}
+
+	selectorMap, found, err := unstructured.NestedMap(obj.Object, fields...)
+	if err != nil || !found {
+		return nil
+	}
+	var selector metaV1.LabelSelector
+	if runtime.DefaultUnstructuredConverter.FromUnstructured(selectorMap, &selector) != nil {
+		return nil
+	}
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		return nil
+	}
+	return &selector
+}
+
+// podTemplateFromUnstructured extracts a pod template, nested in the given fields of an unstructured object.
+// This allows supporting workload kinds whose Go types are not available to this package.
+func podTemplateFromUnstructured(obj *unstructured.Unstructured, fields ...string) (*v1.PodTemplateSpec, error) {
+	template, found, err := unstructured.NestedMap(obj.Object, fields...)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no pod template in field %s", obj.GetKind(), strings.Join(fields, "."))
+	}
+
+	var podSpecV1 v1.PodTemplateSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(template, &podSpecV1); err != nil {
+		return nil, err
+	}
+	return &podSpecV1, nil
+}
+
 func matchLabelSelectorToStrLabels(labels map[string]string) []string {
 	res := []string{}
 	for k, v := range labels {
@@ -145,48 +172,141 @@ func (port *SvcNetworkAttr) equals(intStrPort *intstr.IntOrString) bool {
 		(port.Port > 0 && port.Port == int(intStrPort.IntVal))
 }
 
-// ocRouteFromInfo updates servicesToExpose based on an OpenShift Route object
-func ocRouteFromInfo(info *resource.Info, toExpose servicesToExpose) error {
-	routeObj := parseResourceFromInfo[ocroutev1.Route](info)
+// ocRouteExposure returns the Service ports exposed by an OpenShift Route object
+func ocRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[ocroutev1.Route](obj)
 	if routeObj == nil {
-		return fmt.Errorf("failed to parse Route resource")
+		return nil, fmt.Errorf("failed to parse Route resource")
 	}
-
-	toExpose.appendPort(routeObj.Namespace, routeObj.Spec.To.Name, &routeObj.Spec.Port.TargetPort)
+	targetPort := intstr.IntOrString{} // a Route with no target port exposes all the Service's ports
+	if routeObj.Spec.Port != nil {
+		targetPort = routeObj.Spec.Port.TargetPort
+	}
+	exposed := []ExposedServicePort{{Namespace: routeObj.Namespace, ServiceName: routeObj.Spec.To.Name, Port: targetPort}}
 	for _, backend := range routeObj.Spec.AlternateBackends {
-		toExpose.appendPort(routeObj.Namespace, backend.Name, &routeObj.Spec.Port.TargetPort)
+		exposed = append(exposed, ExposedServicePort{Namespace: routeObj.Namespace, ServiceName: backend.Name, Port: targetPort})
 	}
 
-	return nil
+	return exposed, nil
 }
 
-// k8sIngressFromInfo updates servicesToExpose based on an K8s Ingress object
-func k8sIngressFromInfo(info *resource.Info, toExpose servicesToExpose) error {
-	ingressObj := parseResourceFromInfo[networkv1.Ingress](info)
+// k8sIngressExposure returns the Service ports exposed by a K8s Ingress object
+func k8sIngressExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	ingressObj := parseResourceFromUnstructured[networkv1.Ingress](obj)
 	if ingressObj == nil {
-		return fmt.Errorf("failed to parse Ingress resource")
+		return nil, fmt.Errorf("failed to parse Ingress resource")
 	}
+	return ingressExposure(ingressObj), nil
+}
 
+// k8sIngressV1beta1Exposure returns the Service ports exposed by a K8s Ingress object of the deprecated v1beta1 API version
+// (served under both the networking.k8s.io and the extensions groups), by converting it into a v1 Ingress
+func k8sIngressV1beta1Exposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	ingressObj := parseResourceFromUnstructured[networkv1beta1.Ingress](obj)
+	if ingressObj == nil {
+		return nil, fmt.Errorf("failed to parse Ingress resource")
+	}
+
+	ingressV1 := networkv1.Ingress{ObjectMeta: ingressObj.ObjectMeta}
+	if ingressObj.Spec.Backend != nil {
+		defaultBackend := ingressV1beta1Backend(ingressObj.Spec.Backend)
+		ingressV1.Spec.DefaultBackend = &defaultBackend
+	}
+	for ruleIdx := range ingressObj.Spec.Rules {
+		rule := &ingressObj.Spec.Rules[ruleIdx]
+		ruleV1 := networkv1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			ruleV1.HTTP = &networkv1.HTTPIngressRuleValue{}
+			for pathIdx := range rule.HTTP.Paths {
+				httpPath := &rule.HTTP.Paths[pathIdx]
+				ruleV1.HTTP.Paths = append(ruleV1.HTTP.Paths, networkv1.HTTPIngressPath{
+					Path: httpPath.Path, PathType: (*networkv1.PathType)(httpPath.PathType), Backend: ingressV1beta1Backend(&httpPath.Backend),
+				})
+			}
+		}
+		ingressV1.Spec.Rules = append(ingressV1.Spec.Rules, ruleV1)
+	}
+	return ingressExposure(&ingressV1), nil
+}
+
+// ingressV1beta1Backend converts a v1beta1 Ingress backend into a v1 Ingress backend
+func ingressV1beta1Backend(backend *networkv1beta1.IngressBackend) networkv1.IngressBackend {
+	if backend.ServiceName == "" {
+		return networkv1.IngressBackend{} // a resource backend
+	}
+	port := networkv1.ServiceBackendPort{Number: backend.ServicePort.IntVal}
+	if backend.ServicePort.Type == intstr.String {
+		port = networkv1.ServiceBackendPort{Name: backend.ServicePort.StrVal}
+	}
+	return networkv1.IngressBackend{Service: &networkv1.IngressServiceBackend{Name: backend.ServiceName, Port: port}}
+}
+
+// ingressExposure returns the Service ports exposed by the given v1 Ingress
+func ingressExposure(ingressObj *networkv1.Ingress) []ExposedServicePort {
+	exposed := []ExposedServicePort{}
 	defaultBackend := ingressObj.Spec.DefaultBackend
 	if defaultBackend != nil && defaultBackend.Service != nil {
-		portToAppend := portFromServiceBackendPort(&defaultBackend.Service.Port)
-		toExpose.appendPort(ingressObj.Namespace, defaultBackend.Service.Name, portToAppend)
+		port := portFromServiceBackendPort(&defaultBackend.Service.Port)
+		exposed = append(exposed, ExposedServicePort{Namespace: ingressObj.Namespace, ServiceName: defaultBackend.Service.Name, Port: *port})
 	}
 
 	for ruleIdx := range ingressObj.Spec.Rules {
 		rule := &ingressObj.Spec.Rules[ruleIdx]
 		if rule.HTTP != nil {
 			for pathIdx := range rule.HTTP.Paths {
-				svc := rule.HTTP.Paths[pathIdx].Backend.Service
+				httpPath := &rule.HTTP.Paths[pathIdx]
+				svc := httpPath.Backend.Service
 				if svc != nil {
-					portToAppend := portFromServiceBackendPort(&svc.Port)
-					toExpose.appendPort(ingressObj.Namespace, svc.Name, portToAppend)
+					port := portFromServiceBackendPort(&svc.Port)
+					exposedPort := ExposedServicePort{Namespace: ingressObj.Namespace, ServiceName: svc.Name, Port: *port}
+					exact := httpPath.PathType != nil && *httpPath.PathType == networkv1.PathTypeExact
+					if pathRegex, restricted := httpPathRegex(httpPath.Path, exact); restricted {
+						exposedPort.HTTPPaths = []string{pathRegex}
+					}
+					exposed = append(exposed, exposedPort)
 				}
 			}
 		}
 	}
 
-	return nil
+	return exposed
+}
+
+// httpPathRegex converts an exact or a prefix HTTP path match into a regular expression, matching the same request paths.
+// The returned bool is false if all paths are matched.
+func httpPathRegex(path string, exact bool) (string, bool) {
+	if exact {
+		return regexp.QuoteMeta(path), true
+	}
+	prefix := strings.TrimSuffix(path, "/")
+	if prefix == "" {
+		return "", false // matching all paths
+	}
+	return regexp.QuoteMeta(prefix) + "(/.*)?", true
+}
+
+// httpRouteRulePaths returns regular expressions matching the request paths which are matched by an HTTPRoute rule.
+// Returns nil if the rule matches all paths.
+func httpRouteRulePaths(rule *gatewayv1.HTTPRouteRule) []string {
+	paths := []string{}
+	for i := range rule.Matches {
+		pathMatch := rule.Matches[i].Path
+		if pathMatch == nil || pathMatch.Value == nil {
+			return nil // matching all paths
+		}
+		pathRegex, restricted := *pathMatch.Value, true
+		if pathMatch.Type == nil || *pathMatch.Type != gatewayv1.PathMatchRegularExpression {
+			pathRegex, restricted = httpPathRegex(*pathMatch.Value, pathMatch.Type != nil && *pathMatch.Type == gatewayv1.PathMatchExact)
+		}
+		if !restricted {
+			return nil
+		}
+		paths = append(paths, pathRegex)
+	}
+	if len(paths) == 0 {
+		return nil // a rule with no matches matches all paths
+	}
+	return paths
 }
 
 func portFromServiceBackendPort(sbp *networkv1.ServiceBackendPort) *intstr.IntOrString {
@@ -197,54 +317,147 @@ func portFromServiceBackendPort(sbp *networkv1.ServiceBackendPort) *intstr.IntOr
 	return &res
 }
 
-func gatewayHTTPRouteFromInfo(info *resource.Info, toExpose servicesToExpose) error {
-	routeObj := parseResourceFromInfo[gatewayv1.HTTPRoute](info)
+// gatewayHTTPRouteExposure returns the Service ports exposed by a Gateway API HTTPRoute object
+func gatewayHTTPRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[gatewayv1.HTTPRoute](obj)
 	if routeObj == nil {
-		return fmt.Errorf("failed to parse HTTPRoute resource")
+		return nil, fmt.Errorf("failed to parse HTTPRoute resource")
 	}
 
+	route := newGatewayRoute(routeObj, &routeObj.Spec.CommonRouteSpec, "")
+	exposed := []ExposedServicePort{}
 	for i := range routeObj.Spec.Rules {
 		rule := &routeObj.Spec.Rules[i]
+		paths := httpRouteRulePaths(rule)
 		for j := range rule.BackendRefs {
-			backend := &rule.BackendRefs[j]
-			if (backend.Kind != nil && string(*backend.Kind) != RouteBackendServiceKind) || backend.Port == nil {
-				continue // ignore backends which are not services and which do not specify a port
-			}
-			namespace := routeObj.Namespace
-			if backend.Namespace != nil {
-				namespace = string(*backend.Namespace)
+			if port, ok := route.exposedBackendPort(&rule.BackendRefs[j].BackendObjectReference); ok {
+				port.HTTPPaths = paths
+				exposed = append(exposed, port)
 			}
-			port := intstr.FromInt32(int32(*backend.Port))
-			toExpose.appendPort(namespace, string(backend.Name), &port)
 		}
 	}
 
-	return nil
+	return exposed, nil
 }
 
-func gatewayGRPCRouteFromInfo(info *resource.Info, toExpose servicesToExpose) error {
-	routeObj := parseResourceFromInfo[gatewayv1.GRPCRoute](info)
+// gatewayGRPCRouteExposure returns the Service ports exposed by a Gateway API GRPCRoute object
+func gatewayGRPCRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[gatewayv1.GRPCRoute](obj)
 	if routeObj == nil {
-		return fmt.Errorf("failed to parse GRPCRoute resource")
+		return nil, fmt.Errorf("failed to parse GRPCRoute resource")
 	}
 
+	route := newGatewayRoute(routeObj, &routeObj.Spec.CommonRouteSpec, "")
+	exposed := []ExposedServicePort{}
 	for i := range routeObj.Spec.Rules {
 		rule := &routeObj.Spec.Rules[i]
 		for j := range rule.BackendRefs {
-			backend := &rule.BackendRefs[j]
-			if (backend.Kind != nil && string(*backend.Kind) != RouteBackendServiceKind) || backend.Port == nil {
-				continue // ignore backends which are not services and which do not specify a port
-			}
-			port := intstr.FromInt32(int32(*backend.Port))
-			namespace := routeObj.Namespace
-			if backend.Namespace != nil {
-				namespace = string(*backend.Namespace)
+			if port, ok := route.exposedBackendPort(&rule.BackendRefs[j].BackendObjectReference); ok {
+				exposed = append(exposed, port)
 			}
-			toExpose.appendPort(namespace, string(backend.Name), &port)
 		}
 	}
 
-	return nil
+	return exposed, nil
+}
+
+// gatewayTCPRouteExposure returns the Service ports exposed by a Gateway API TCPRoute object (experimental channel)
+func gatewayTCPRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[gatewayv1alpha2.TCPRoute](obj)
+	if routeObj == nil {
+		return nil, fmt.Errorf("failed to parse TCPRoute resource")
+	}
+
+	route := newGatewayRoute(routeObj, &routeObj.Spec.CommonRouteSpec, v1.ProtocolTCP)
+	exposed := []ExposedServicePort{}
+	for i := range routeObj.Spec.Rules {
+		exposed = route.appendExposedBackendPorts(exposed, routeObj.Spec.Rules[i].BackendRefs)
+	}
+	return exposed, nil
+}
+
+// gatewayTLSRouteExposure returns the Service ports exposed by a Gateway API TLSRoute object (experimental channel)
+func gatewayTLSRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[gatewayv1alpha2.TLSRoute](obj)
+	if routeObj == nil {
+		return nil, fmt.Errorf("failed to parse TLSRoute resource")
+	}
+
+	route := newGatewayRoute(routeObj, &routeObj.Spec.CommonRouteSpec, v1.ProtocolTCP)
+	exposed := []ExposedServicePort{}
+	for i := range routeObj.Spec.Rules {
+		exposed = route.appendExposedBackendPorts(exposed, routeObj.Spec.Rules[i].BackendRefs)
+	}
+	return exposed, nil
+}
+
+// gatewayUDPRouteExposure returns the Service ports exposed by a Gateway API UDPRoute object (experimental channel)
+func gatewayUDPRouteExposure(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+	routeObj := parseResourceFromUnstructured[gatewayv1alpha2.UDPRoute](obj)
+	if routeObj == nil {
+		return nil, fmt.Errorf("failed to parse UDPRoute resource")
+	}
+
+	route := newGatewayRoute(routeObj, &routeObj.Spec.CommonRouteSpec, v1.ProtocolUDP)
+	exposed := []ExposedServicePort{}
+	for i := range routeObj.Spec.Rules {
+		exposed = route.appendExposedBackendPorts(exposed, routeObj.Spec.Rules[i].BackendRefs)
+	}
+	return exposed, nil
+}
+
+// gatewayRoute holds the information which is common to all the backends of a Gateway API route
+type gatewayRoute struct {
+	namespace      string
+	parentGateways []types.NamespacedName
+	protocol       v1.Protocol // the protocol of the exposed Service ports; if empty, ports of all protocols are exposed
+}
+
+func newGatewayRoute(routeObj metaV1.Object, spec *gatewayv1.CommonRouteSpec, protocol v1.Protocol) *gatewayRoute {
+	route := gatewayRoute{namespace: routeObj.GetNamespace(), protocol: protocol}
+	for i := range spec.ParentRefs {
+		parentRef := &spec.ParentRefs[i]
+		if (parentRef.Group != nil && string(*parentRef.Group) != gatewayv1.GroupName) ||
+			(parentRef.Kind != nil && string(*parentRef.Kind) != gateway) {
+			continue // only Gateway parents are relevant for determining where the route's traffic comes from
+		}
+		gwNamespace := route.namespace
+		if parentRef.Namespace != nil {
+			gwNamespace = string(*parentRef.Namespace)
+		}
+		route.parentGateways = append(route.parentGateways, types.NamespacedName{Namespace: gwNamespace, Name: string(parentRef.Name)})
+	}
+	return &route
+}
+
+func (gr *gatewayRoute) appendExposedBackendPorts(exposed []ExposedServicePort, backendRefs []gatewayv1.BackendRef) []ExposedServicePort {
+	for i := range backendRefs {
+		if port, ok := gr.exposedBackendPort(&backendRefs[i].BackendObjectReference); ok {
+			exposed = append(exposed, port)
+		}
+	}
+	return exposed
+}
+
+// exposedBackendPort returns the Service port exposed by a backend of the route.
+// The backend namespace defaults to the route's namespace.
+// Returns false if the backend is not a Service or does not specify a port.
+func (gr *gatewayRoute) exposedBackendPort(backend *gatewayv1.BackendObjectReference) (ExposedServicePort, bool) {
+	if (backend.Group != nil && *backend.Group != "") || (backend.Kind != nil && string(*backend.Kind) != RouteBackendServiceKind) ||
+		backend.Port == nil {
+		return ExposedServicePort{}, false // ignore backends which are not services and which do not specify a port
+	}
+	namespace := gr.namespace
+	if backend.Namespace != nil {
+		namespace = string(*backend.Namespace)
+	}
+	return ExposedServicePort{
+		Namespace:      namespace,
+		ServiceName:    string(backend.Name),
+		Port:           intstr.FromInt32(int32(*backend.Port)),
+		Protocol:       gr.protocol,
+		ParentGateways: gr.parentGateways,
+	}, true
 }
 
 func parseDeployResource(podSpec *v1.PodTemplateSpec, obj metaV1.Object, resourceCtx *Resource) {
@@ -253,45 +466,84 @@ func parseDeployResource(podSpec *v1.PodTemplateSpec, obj metaV1.Object, resourc
 	resourceCtx.Resource.Labels = podSpec.Labels
 	delete(resourceCtx.Resource.Labels, "pod-template-hash") // auto-generated - better not use it in netpols
 	resourceCtx.Resource.ServiceAccountName = podSpec.Spec.ServiceAccountName
+	resourceCtx.Resource.HostNetwork = podSpec.Spec.HostNetwork
 	for containerIdx := range podSpec.Spec.Containers {
 		container := &podSpec.Spec.Containers[containerIdx]
 		resourceCtx.Resource.Image.ID = container.Image
+		for _, p := range container.Ports {
+			containerPort := ContainerPortAttr{Name: p.Name, Port: int(p.ContainerPort), Protocol: p.Protocol, HostPort: int(p.HostPort)}
+			resourceCtx.Resource.ContainerPorts = append(resourceCtx.Resource.ContainerPorts, containerPort)
+		}
+		resourceCtx.Resource.ProbePorts = appendProbePorts(resourceCtx.Resource.ProbePorts, container)
 		for _, e := range container.Env {
 			if e.Value != "" {
-				if netAddr, ok := networkAddressFromStr(e.Value); ok {
-					resourceCtx.Resource.NetworkAddrs = append(resourceCtx.Resource.NetworkAddrs, netAddr)
-				}
+				resourceCtx.addManifestValue(e.Value, container.Name, "env "+e.Name)
 			} else if e.ValueFrom != nil && e.ValueFrom.ConfigMapKeyRef != nil {
 				keyRef := e.ValueFrom.ConfigMapKeyRef
 				if keyRef.Name != "" && keyRef.Key != "" { // just store ref for now - check later if it's a network address
-					cfgMapKeyRef := cfgMapKeyRef{Name: keyRef.Name, Key: keyRef.Key}
+					cfgMapKeyRef := cfgMapKeyRef{Name: keyRef.Name, Key: keyRef.Key, Container: container.Name, EnvName: e.Name}
 					resourceCtx.Resource.ConfigMapKeyRefs = append(resourceCtx.Resource.ConfigMapKeyRefs, cfgMapKeyRef)
 				}
 			}
 		}
 		for _, envFrom := range container.EnvFrom {
 			if envFrom.ConfigMapRef != nil { // just store ref for now - check later if the config map values contain a network address
-				resourceCtx.Resource.ConfigMapRefs = append(resourceCtx.Resource.ConfigMapRefs, envFrom.ConfigMapRef.Name)
+				cfgMapRef := cfgMapRef{Name: envFrom.ConfigMapRef.Name, Container: container.Name}
+				resourceCtx.Resource.ConfigMapRefs = append(resourceCtx.Resource.ConfigMapRefs, cfgMapRef)
 			}
 		}
-		resourceCtx.Resource.NetworkAddrs = appendNetworkAddresses(resourceCtx.Resource.NetworkAddrs, container.Args)
-		resourceCtx.Resource.NetworkAddrs = appendNetworkAddresses(resourceCtx.Resource.NetworkAddrs, container.Command)
+		addNetworkAddresses(resourceCtx, container.Name, "args", container.Args)
+		addNetworkAddresses(resourceCtx, container.Name, "command", container.Command)
 	}
 	for volIdx := range podSpec.Spec.Volumes {
 		volume := &podSpec.Spec.Volumes[volIdx]
 		if volume.ConfigMap != nil {
-			resourceCtx.Resource.ConfigMapRefs = append(resourceCtx.Resource.ConfigMapRefs, volume.ConfigMap.Name)
+			resourceCtx.Resource.ConfigMapRefs = append(resourceCtx.Resource.ConfigMapRefs, cfgMapRef{Name: volume.ConfigMap.Name})
 		}
 	}
 }
 
-func appendNetworkAddresses(networkAddresses, values []string) []string {
-	for _, val := range values {
-		if netAddr, ok := networkAddressFromStr(val); ok {
-			networkAddresses = append(networkAddresses, netAddr)
+// appendProbePorts appends the ports used by the container's liveness, readiness and startup probes.
+// Named probe ports are resolved using the ports declared by the container.
+func appendProbePorts(probePorts []intstr.IntOrString, container *v1.Container) []intstr.IntOrString {
+	for _, probe := range []*v1.Probe{container.LivenessProbe, container.ReadinessProbe, container.StartupProbe} {
+		port, ok := probePort(probe)
+		if !ok {
+			continue
 		}
+		if port.Type == intstr.String {
+			for _, containerPort := range container.Ports {
+				if containerPort.Name == port.StrVal {
+					port = intstr.FromInt32(containerPort.ContainerPort)
+				}
+			}
+		}
+		if !slices.Contains(probePorts, port) {
+			probePorts = append(probePorts, port)
+		}
+	}
+	return probePorts
+}
+
+func probePort(probe *v1.Probe) (intstr.IntOrString, bool) {
+	switch {
+	case probe == nil:
+		return intstr.IntOrString{}, false
+	case probe.HTTPGet != nil:
+		return probe.HTTPGet.Port, true
+	case probe.TCPSocket != nil:
+		return probe.TCPSocket.Port, true
+	case probe.GRPC != nil:
+		return intstr.FromInt32(probe.GRPC.Port), true
+	}
+	return intstr.IntOrString{}, false // exec probes do not use the network
+}
+
+// addNetworkAddresses adds to the workload the network addresses in the given values of a container field (e.g., its args)
+func addNetworkAddresses(resource *Resource, container, field string, values []string) {
+	for i, val := range values {
+		resource.addManifestValue(val, container, fmt.Sprintf("%s[%d]", field, i))
 	}
-	return networkAddresses
 }
 
 // networkAddressFromStr tries to extract a network address from the given string.
@@ -311,6 +563,19 @@ func networkAddressFromStr(value string) (string, bool) {
 	return "", false
 }
 
+// urlSchemeOf returns the (lower-case) scheme of the given network address, if the given value holds the address as a URL
+// (e.g., "https" for "api.example.com" in "https://api.example.com/v1", or "postgresql" in "jdbc:postgresql://db:5432/app")
+func urlSchemeOf(value, netAddr string) (string, bool) {
+	prefix, _, found := strings.Cut(value, "://"+netAddr)
+	if !found {
+		return "", false
+	}
+	start := strings.LastIndexFunc(prefix, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+-.", r)
+	})
+	return strings.ToLower(prefix[start+1:]), true
+}
+
 func networkAddressFromSuffix(value string) (string, bool) {
 	host, err := getHostFromURL(value)
 	if err != nil {
@@ -342,6 +607,27 @@ func networkAddressFromSuffix(value string) (string, bool) {
 	return host, true
 }
 
+// invalidHostFromStr looks in the given string (which is not a network address) for a host, which would have made it a network address,
+// had it been a valid DNS-1123 subdomain, e.g., "Redis_Master:6379" or "http://DB/". Such a host is returned together with the value true.
+func invalidHostFromStr(value string) (string, bool) {
+	for _, suffix := range possibleSuffixes(value) {
+		if _, afterScheme, found := strings.Cut(suffix, "://"); found {
+			suffix = afterScheme
+		}
+		host, _, _ := strings.Cut(suffix, "/")
+		hostNoPort, port, _ := strings.Cut(host, ":")
+		if port != "" && len(validation.IsValidPortName(port)) > 0 {
+			if portInt, err := strconv.Atoi(port); err != nil || len(validation.IsValidPortNum(portInt)) > 0 {
+				continue
+			}
+		}
+		if invalidHostPattern.MatchString(hostNoPort) && len(validation.IsDNS1123Subdomain(hostNoPort)) > 0 {
+			return host, true
+		}
+	}
+	return "", false
+}
+
 // Sometimes the given value includes the network address as its suffix.
 // For example, a command-line arg may look like "server-addr=my_server:5000"
 // If we are unable to convert "value" to a network address, we may also want to check its suffixes.
@@ -384,6 +670,10 @@ func parseResourceFromInfo[T interface{}](info *resource.Info) *T {
 		return nil
 	}
 
+	return parseResourceFromUnstructured[T](obj)
+}
+
+func parseResourceFromUnstructured[T interface{}](obj *unstructured.Unstructured) *T {
 	var rc T
 	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &rc)
 	if err != nil {
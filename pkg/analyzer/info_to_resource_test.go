@@ -8,6 +8,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/cli-runtime/pkg/resource"
 
 	"github.com/np-guard/netpol-analyzer/pkg/manifests/fsscanner"
@@ -39,6 +42,23 @@ func TestNetworkAddressValue(t *testing.T) {
 	}
 }
 
+func TestInvalidHostValue(t *testing.T) {
+	valuesToCheck := map[string]string{
+		"Redis_Master:6379":      "Redis_Master:6379",
+		"http://DB/path":         "DB",
+		"-server=My_Server:5024": "My_Server:5024",
+		"not%a*url":              "",
+		"My_Server:502%4":        "", // port number is invalid
+		"123":                    "",
+	}
+
+	for val, expectedHost := range valuesToCheck {
+		host, ok := invalidHostFromStr(val)
+		require.Equal(t, expectedHost != "", ok)
+		require.Equal(t, expectedHost, host)
+	}
+}
+
 func TestScanningSvc(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"k8s_guestbook", "frontend-service.yaml"}, 0)
 	require.Nil(t, err)
@@ -53,12 +73,13 @@ func TestScanningSvc(t *testing.T) {
 func TestScanningDeploymentWithArgs(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"sockshop", "manifests", "01-carts-dep.yaml"}, 0)
 	require.Nil(t, err)
-	res, err := k8sWorkloadObjectFromInfo(resourceInfo)
+	res, err := workloadFromInfo(resourceInfo)
 	require.Nil(t, err)
 	require.Equal(t, "carts", res.Resource.Name)
 	require.Len(t, res.Resource.NetworkAddrs, 2)
 	require.Equal(t, "false", res.Resource.NetworkAddrs[0])
 	require.Equal(t, "carts-db:27017", res.Resource.NetworkAddrs[1])
+	require.Equal(t, AddressOrigin{Address: "carts-db:27017", Container: "carts", Field: "args[1]"}, res.Resource.AddressOrigins[1])
 	require.Len(t, res.Resource.Labels, 1)
 	require.Equal(t, "carts", res.Resource.Labels["name"])
 }
@@ -66,7 +87,7 @@ func TestScanningDeploymentWithArgs(t *testing.T) {
 func TestScanningDeploymentWithEnvs(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"k8s_guestbook", "frontend-deployment.yaml"}, 0)
 	require.Nil(t, err)
-	res, err := k8sWorkloadObjectFromInfo(resourceInfo)
+	res, err := workloadFromInfo(resourceInfo)
 	require.Nil(t, err)
 	require.Equal(t, "frontend", res.Resource.Name)
 	require.Len(t, res.Resource.NetworkAddrs, 4)
@@ -76,7 +97,7 @@ func TestScanningDeploymentWithEnvs(t *testing.T) {
 func TestScanningDeploymentWithConfigMapRef(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"acs-security-demos", "frontend", "webapp", "deployment.yaml"}, 0)
 	require.Nil(t, err)
-	res, err := k8sWorkloadObjectFromInfo(resourceInfo)
+	res, err := workloadFromInfo(resourceInfo)
 	require.Nil(t, err)
 	require.Equal(t, "webapp", res.Resource.Name)
 	require.Len(t, res.Resource.ConfigMapRefs, 1)
@@ -84,16 +105,119 @@ func TestScanningDeploymentWithConfigMapRef(t *testing.T) {
 	require.Len(t, res.Resource.Labels, 1)
 }
 
+func TestResolvingNamedTargetPort(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"sockshop", "manifests", "19-rabbitmq-dep.yaml"}, 0)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Len(t, res.Resource.ContainerPorts, 3)
+
+	resourceInfo, err = loadResourceAsInfo([]string{"sockshop", "manifests", "20-rabbitmq-svc.yaml"}, 0)
+	require.Nil(t, err)
+	svc, err := k8sServiceFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Len(t, svc.Resource.Network, 2)
+	for i := range svc.Resource.Network {
+		port := &svc.Resource.Network[i]
+		resolvedPort, ok := res.resolveTargetPort(port)
+		require.True(t, ok)
+		require.Equal(t, int32(port.Port), resolvedPort.IntVal) // exporter is resolved to container port 9090
+	}
+
+	missingPort := SvcNetworkAttr{Port: 8080, TargetPort: intstr.FromString("no-such-port")}
+	resolvedPort, ok := res.resolveTargetPort(&missingPort)
+	require.False(t, ok)
+	require.Equal(t, "no-such-port", resolvedPort.StrVal)
+
+	// a workload which declares no container ports cannot resolve a named target port, but a numbered one is assumed to be open
+	res.Resource.ContainerPorts = nil
+	_, ok = res.resolveTargetPort(&missingPort)
+	require.False(t, ok)
+	numberedPort := SvcNetworkAttr{Port: 8080, TargetPort: intstr.FromInt(9090)}
+	resolvedPort, ok = res.resolveTargetPort(&numberedPort)
+	require.True(t, ok)
+	require.Equal(t, int32(9090), resolvedPort.IntVal)
+	svc.Resource.Network = []SvcNetworkAttr{missingPort, numberedPort}
+	warnings := checkTargetPorts(res, svc, NewDefaultLogger())
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0].Error().Error(), "no-such-port")
+
+	// no traffic is allowed to (or expected on) the unresolved port
+	netpolPorts := toNetpolPorts(svc.Resource.Network, res, false)
+	require.Len(t, netpolPorts, 1)
+	require.Equal(t, int32(9090), netpolPorts[0].Port.IntVal)
+	require.Equal(t, []FlowPort{{Number: 9090, Protocol: core.ProtocolTCP}}, targetFlowPorts(svc.Resource.Network, res))
+}
+
+func TestScanningProbesAndHostNetwork(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"probes_and_host_network", "manifests.yaml"}, 0)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, "backend", res.Resource.Name)
+	require.Len(t, res.Resource.ContainerPorts, 3)
+	require.Equal(t, []intstr.IntOrString{intstr.FromInt(8081), intstr.FromInt(8080), intstr.FromInt(9000)}, res.Resource.ProbePorts)
+	require.False(t, res.Resource.HostNetwork)
+
+	resourceInfo, err = loadResourceAsInfo([]string{"probes_and_host_network", "manifests.yaml"}, 2)
+	require.Nil(t, err)
+	res, err = workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, "node-agent", res.Resource.Name)
+	require.Empty(t, res.Resource.ProbePorts) // exec probes do not use the network
+	require.True(t, res.Resource.HostNetwork)
+	require.Len(t, res.Resource.ContainerPorts, 1)
+	require.Equal(t, 9100, res.Resource.ContainerPorts[0].HostPort)
+}
+
 func TestScanningReplicaSet(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"k8s_guestbook", "redis-leader-deployment.yaml"}, 0)
 	require.Nil(t, err)
-	res, err := k8sWorkloadObjectFromInfo(resourceInfo)
+	res, err := workloadFromInfo(resourceInfo)
 	require.Nil(t, err)
 	require.Equal(t, "redis-leader", res.Resource.Name)
 	require.Len(t, res.Resource.NetworkAddrs, 0)
 	require.Len(t, res.Resource.Labels, 3)
 }
 
+func TestScanningRollout(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"rollouts_knative_dc", "manifests.yaml"}, 0)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, "storefront", res.Resource.Name)
+	require.Equal(t, rollout, res.Resource.Kind)
+	require.Equal(t, []string{"pricing.shop.svc.cluster.local"}, res.Resource.NetworkAddrs)
+	require.Equal(t, map[string]string{"app": "storefront"}, res.Resource.Labels)
+}
+
+func TestScanningKnativeService(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"rollouts_knative_dc", "manifests.yaml"}, 2)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	svc := knativeImpliedService(resourceInfo.Object.(*unstructured.Unstructured))
+	require.Equal(t, "pricing", res.Resource.Name)
+	require.Equal(t, []string{"inventory:5432"}, res.Resource.NetworkAddrs)
+	require.Equal(t, "pricing", res.Resource.Labels[knativeServiceLabel])
+	require.Equal(t, "pricing", svc.Resource.Name)
+	require.True(t, areSelectorsContained(res.Resource.Labels, svc.Resource.Selectors))
+	require.False(t, svc.Resource.ExposeExternally) // labeled as cluster-local
+	require.Len(t, svc.Resource.Network, 1)
+	_, ok := res.resolveTargetPort(&svc.Resource.Network[0])
+	require.True(t, ok)
+}
+
+func TestScanningDeploymentConfig(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"rollouts_knative_dc", "manifests.yaml"}, 3)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, "inventory", res.Resource.Name)
+	require.Equal(t, deploymentConfig, res.Resource.Kind)
+	require.Len(t, res.Resource.ContainerPorts, 1)
+}
+
 func TestScanningConfigMap(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"qotd", "qotd_usecase.yaml"}, 0)
 	require.Nil(t, err)
@@ -106,25 +230,82 @@ func TestScanningConfigMap(t *testing.T) {
 func TestScanningIngress(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"bookinfo", "bookinfo-ingress.yaml"}, 0)
 	require.Nil(t, err)
-	toExpose := servicesToExpose{}
-	err = k8sIngressFromInfo(resourceInfo, toExpose)
+	exposed, err := k8sIngressExposure(resourceInfo.Object.(*unstructured.Unstructured))
 	require.Nil(t, err)
-	require.Len(t, toExpose, 1)
+	require.Len(t, exposed, 5)
+	for _, exposedPort := range exposed {
+		require.Equal(t, "productpage", exposedPort.ServiceName)
+	}
+}
+
+func TestScanningIngressV1beta1(t *testing.T) {
+	backend := func(name string, port any) map[string]any {
+		return map[string]any{"serviceName": name, "servicePort": port}
+	}
+	ingress := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "extensions/v1beta1",
+		"kind":       "Ingress",
+		"metadata":   map[string]any{"name": "shop", "namespace": "web"},
+		"spec": map[string]any{
+			"backend": backend("frontend", int64(80)),
+			"rules": []any{map[string]any{"http": map[string]any{"paths": []any{
+				map[string]any{"path": "/api", "pathType": "Prefix", "backend": backend("api", "http")},
+			}}}},
+		},
+	}}
+	_, ok := newExtractorRegistry().exposureExtractor(ingress.GroupVersionKind())
+	require.True(t, ok)
+	exposed, err := k8sIngressV1beta1Exposure(ingress)
+	require.Nil(t, err)
+	require.Equal(t, []ExposedServicePort{
+		{Namespace: "web", ServiceName: "frontend", Port: intstr.FromInt(80)},
+		{Namespace: "web", ServiceName: "api", Port: intstr.FromString("http"), HTTPPaths: []string{`/api(/.*)?`}},
+	}, exposed)
+}
+
+func TestScanningHTTPRouteV1alpha2(t *testing.T) {
+	route := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "gateway.networking.k8s.io/v1alpha2",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]any{"name": "shop", "namespace": "web"},
+		"spec": map[string]any{"rules": []any{map[string]any{
+			"backendRefs": []any{map[string]any{"name": "frontend", "port": int64(80)}},
+		}}},
+	}}
+	extractor, ok := newExtractorRegistry().exposureExtractor(route.GroupVersionKind()) // same schema as v1
+	require.True(t, ok)
+	exposed, err := extractor(route)
+	require.Nil(t, err)
+	require.Len(t, exposed, 1)
+	require.Equal(t, "frontend", exposed[0].ServiceName)
+	require.Equal(t, intstr.FromInt(80), exposed[0].Port)
 }
 
 func TestScanningRoute(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"acs-security-demos", "frontend", "webapp", "route.yaml"}, 0)
 	require.Nil(t, err)
-	toExpose := servicesToExpose{}
-	err = ocRouteFromInfo(resourceInfo, toExpose)
+	exposed, err := ocRouteExposure(resourceInfo.Object.(*unstructured.Unstructured))
 	require.Nil(t, err)
-	require.Len(t, toExpose, 1)
+	require.Len(t, exposed, 1)
+	require.Equal(t, "webapp-service", exposed[0].ServiceName)
+}
+
+func TestScanningRouteWithoutPort(t *testing.T) {
+	route := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "route.openshift.io/v1",
+		"kind":       "Route",
+		"metadata":   map[string]any{"name": "webapp", "namespace": "frontend"},
+		"spec":       map[string]any{"to": map[string]any{"kind": "Service", "name": "webapp-service"}},
+	}}
+	exposed, err := ocRouteExposure(route)
+	require.Nil(t, err)
+	require.Equal(t, []ExposedServicePort{{Namespace: "frontend", ServiceName: "webapp-service"}}, exposed) // all ports
 }
 
 func TestScanningCronJob(t *testing.T) {
 	resourceInfo, err := loadResourceAsInfo([]string{"openshift", "openshift-operator-lifecycle-manager-resources.yaml"}, 7)
 	require.Nil(t, err)
-	res, err := k8sWorkloadObjectFromInfo(resourceInfo)
+	res, err := workloadFromInfo(resourceInfo)
 	require.Nil(t, err)
 	require.Equal(t, "collect-profiles", res.Resource.Name)
 	require.Equal(t, cronJob, res.Resource.Kind)
@@ -132,6 +313,39 @@ func TestScanningCronJob(t *testing.T) {
 	require.Len(t, res.Resource.Labels, 0)
 }
 
+// workloadFromInfo parses a workload resource using the built-in extractors
+func TestScanningWorkloadSelectors(t *testing.T) {
+	resourceInfo, err := loadResourceAsInfo([]string{"workload_selectors", "manifests.yaml"}, 0)
+	require.Nil(t, err)
+	res, err := workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, "web", res.Resource.Name)
+	require.Equal(t, map[string]string{"app": "web"}, res.Resource.Selector.MatchLabels)
+	require.Len(t, res.Resource.Selector.MatchExpressions, 1)
+	require.Equal(t, []string{"frontend", "edge"}, res.Resource.Selector.MatchExpressions[0].Values)
+
+	resourceInfo, err = loadResourceAsInfo([]string{"workload_selectors", "manifests.yaml"}, 5)
+	require.Nil(t, err)
+	res, err = workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Equal(t, replicationController, res.Resource.Kind)
+	require.Equal(t, map[string]string{"app": "reports"}, res.Resource.Selector.MatchLabels)
+
+	resourceInfo, err = loadResourceAsInfo([]string{"rollouts_knative_dc", "manifests.yaml"}, 2)
+	require.Nil(t, err)
+	res, err = workloadFromInfo(resourceInfo)
+	require.Nil(t, err)
+	require.Nil(t, res.Resource.Selector) // Knative Services have no selector
+}
+
+func workloadFromInfo(info *resource.Info) (*Resource, error) {
+	wk, ok := newExtractorRegistry().workloadKind(info.Object.GetObjectKind().GroupVersionKind())
+	if !ok {
+		return nil, fmt.Errorf("unsupported object type: `%s`", info.Object.GetObjectKind().GroupVersionKind().Kind)
+	}
+	return k8sWorkloadObjectFromInfo(info, wk.extract)
+}
+
 func loadResourceAsInfo(resourceDirs []string, infoIndex int) (*resource.Info, error) {
 	currentDir, _ := os.Getwd()
 	resourceRelPath := filepath.Join(resourceDirs...)
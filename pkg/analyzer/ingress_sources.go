@@ -0,0 +1,126 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"maps"
+
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// gatewayNameLabel is the label which Gateway implementations put on the pods they deploy for each Gateway (see GEP-1762)
+const gatewayNameLabel = "gateway.networking.k8s.io/gateway-name"
+
+// IngressSource identifies the pods through which traffic from outside the cluster enters it,
+// e.g., the pods of an ingress controller or of a Gateway API implementation.
+type IngressSource struct {
+	Namespace string            // the namespace of the pods; if empty, pods in all namespaces are selected
+	PodLabels map[string]string // the labels of the pods; if empty, all pods in the namespace(s) are selected
+}
+
+func (is *IngressSource) equals(other *IngressSource) bool {
+	return is.Namespace == other.Namespace && maps.Equal(is.PodLabels, other.PodLabels)
+}
+
+// netpolPeer returns a NetworkPolicy peer, selecting the pods of this ingress source
+func (is *IngressSource) netpolPeer() network.NetworkPolicyPeer {
+	peer := network.NetworkPolicyPeer{NamespaceSelector: &metaV1.LabelSelector{}}
+	if is.Namespace != "" {
+		peer.NamespaceSelector.MatchLabels = map[string]string{namespaceNameLabel: is.Namespace}
+	}
+	if len(is.PodLabels) > 0 {
+		peer.PodSelector = &metaV1.LabelSelector{MatchLabels: is.PodLabels}
+	}
+	return peer
+}
+
+// forGateway returns the ingress source of the given Gateway, assuming this is the ingress source of the Gateway's class.
+// Missing fields are inferred from the Gateway itself.
+func (is *IngressSource) forGateway(gw types.NamespacedName) IngressSource {
+	source := IngressSource{Namespace: is.Namespace, PodLabels: is.PodLabels}
+	if source.Namespace == "" {
+		source.Namespace = gw.Namespace
+	}
+	if len(source.PodLabels) == 0 {
+		source.PodLabels = map[string]string{gatewayNameLabel: gw.Name}
+	}
+	return source
+}
+
+// ingressSources determines the pods from which traffic to exposed Service ports is expected
+type ingressSources struct {
+	defaultSource  IngressSource            // the source of traffic to ports exposed by Ingress/Route resources; empty means all cluster pods
+	gatewayClasses map[string]IngressSource // the source of traffic to ports exposed via Gateways of each GatewayClass
+}
+
+// defaultGatewayClassSources returns the ingress sources of GatewayClasses whose Gateway pods can be inferred
+func defaultGatewayClassSources() map[string]IngressSource {
+	return map[string]IngressSource{
+		"istio": {}, // Istio deploys a proxy for each Gateway in the Gateway's namespace, labeled with the Gateway's name
+	}
+}
+
+// sourcesOf returns the ingress sources of the given exposed Service port.
+// The given map holds the GatewayClass name of each known Gateway.
+func (is *ingressSources) sourcesOf(port *ExposedServicePort, gateways map[types.NamespacedName]string) []IngressSource {
+	if len(port.ParentGateways) == 0 {
+		return []IngressSource{is.defaultSource}
+	}
+
+	sources := []IngressSource{}
+	for _, gw := range port.ParentGateways {
+		className, ok := gateways[gw]
+		if !ok {
+			sources = append(sources, is.defaultSource)
+			continue
+		}
+		classSource, ok := is.gatewayClasses[className]
+		if !ok {
+			sources = append(sources, is.defaultSource)
+			continue
+		}
+		sources = append(sources, classSource.forGateway(gw))
+	}
+	return sources
+}
+
+// exposedPortsBySource groups the exposed ports among the given Service ports by their ingress sources
+func exposedPortsBySource(ports []SvcNetworkAttr) ([]IngressSource, [][]SvcNetworkAttr) {
+	sources := []IngressSource{}
+	portsPerSource := [][]SvcNetworkAttr{}
+	for i := range ports {
+		port := &ports[i]
+		if !port.exposeToCluster {
+			continue
+		}
+		portSources := port.ingressSources
+		if len(portSources) == 0 {
+			portSources = []IngressSource{{}} // exposed to all cluster pods
+		}
+		for j := range portSources {
+			idx := indexOfSource(sources, &portSources[j])
+			if idx < 0 {
+				sources = append(sources, portSources[j])
+				portsPerSource = append(portsPerSource, nil)
+				idx = len(sources) - 1
+			}
+			portsPerSource[idx] = append(portsPerSource[idx], *port)
+		}
+	}
+	return sources, portsPerSource
+}
+
+func indexOfSource(sources []IngressSource, source *IngressSource) int {
+	for i := range sources {
+		if sources[i].equals(source) {
+			return i
+		}
+	}
+	return -1
+}
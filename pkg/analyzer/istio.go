@@ -0,0 +1,340 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// Istio resources that are relevant for connectivity analysis
+const (
+	virtualService  string = "VirtualService"
+	destinationRule string = "DestinationRule"
+	serviceEntry    string = "ServiceEntry"
+
+	istioNetworkingGroup = "networking.istio.io"
+	istioMeshGateway     = "mesh" // the reserved gateway name, standing for all the sidecars in the mesh
+	clusterDomainSuffix  = ".svc.cluster.local"
+	istioMeshInternal    = "MESH_INTERNAL" // the location of ServiceEntries for services inside the mesh
+)
+
+var istioNetworkingVersions = []schema.GroupVersion{
+	{Group: istioNetworkingGroup, Version: "v1"},
+	{Group: istioNetworkingGroup, Version: "v1beta1"},
+	{Group: istioNetworkingGroup, Version: "v1alpha3"},
+}
+
+// istioVirtualService holds the fields of an Istio VirtualService, which are relevant for connectivity analysis
+type istioVirtualService struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Hosts    []string      `json:"hosts,omitempty"`
+		Gateways []string      `json:"gateways,omitempty"`
+		HTTP     []istioRoutes `json:"http,omitempty"`
+		TLS      []istioRoutes `json:"tls,omitempty"`
+		TCP      []istioRoutes `json:"tcp,omitempty"`
+	} `json:"spec"`
+
+	FilePath string `json:"-"`
+}
+
+type istioRoutes struct {
+	Route []struct {
+		Destination istioDestination `json:"destination"`
+	} `json:"route,omitempty"`
+}
+
+type istioDestination struct {
+	Host   string `json:"host"`
+	Subset string `json:"subset,omitempty"`
+	Port   *struct {
+		Number int `json:"number,omitempty"`
+	} `json:"port,omitempty"`
+}
+
+// istioDestinationRule holds the fields of an Istio DestinationRule, which are relevant for connectivity analysis
+type istioDestinationRule struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Host    string `json:"host"`
+		Subsets []struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels,omitempty"`
+		} `json:"subsets,omitempty"`
+	} `json:"spec"`
+}
+
+// istioServiceEntry holds the fields of an Istio ServiceEntry, which are relevant for connectivity analysis
+type istioServiceEntry struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Hosts     []string `json:"hosts,omitempty"`
+		Addresses []string `json:"addresses,omitempty"`
+		Location  string   `json:"location,omitempty"`
+		Ports     []struct {
+			Number   int    `json:"number"`
+			Protocol string `json:"protocol,omitempty"`
+		} `json:"ports,omitempty"`
+		Endpoints []struct {
+			Address string `json:"address,omitempty"`
+		} `json:"endpoints,omitempty"`
+	} `json:"spec"`
+}
+
+// parseIstioResource adds the Istio networking resource in the given Info object to the relevant slice
+func (ra *resourceAccumulator) parseIstioResource(info *resource.Info, kind string) error {
+	switch kind {
+	case virtualService:
+		vs := parseResourceFromInfo[istioVirtualService](info)
+		if vs == nil {
+			return fmt.Errorf("failed to parse VirtualService resource")
+		}
+		vs.FilePath = info.Source
+		ra.virtualServices = append(ra.virtualServices, vs)
+	case destinationRule:
+		dr := parseResourceFromInfo[istioDestinationRule](info)
+		if dr == nil {
+			return fmt.Errorf("failed to parse DestinationRule resource")
+		}
+		ra.destinationRules = append(ra.destinationRules, dr)
+	case serviceEntry:
+		se := parseResourceFromInfo[istioServiceEntry](info)
+		if se == nil {
+			return fmt.Errorf("failed to parse ServiceEntry resource")
+		}
+		ra.serviceEntries = append(ra.serviceEntries, se)
+	case gateway: // Istio Gateways need no parsing - VirtualServices refer to them by name
+	}
+	return nil
+}
+
+// isIstioNetworkingKind checks whether the given GroupVersionKind is one of the supported Istio networking kinds
+func isIstioNetworkingKind(gvk schema.GroupVersionKind) bool {
+	return slices.Contains(istioNetworkingVersions, gvk.GroupVersion()) &&
+		(gvk.Kind == virtualService || gvk.Kind == destinationRule || gvk.Kind == serviceEntry || gvk.Kind == gateway)
+}
+
+// applyIstioRouting applies the Istio networking resources to the workloads and services found:
+//   - VirtualService hosts become additional addresses of their destination Services (mesh-bound VirtualServices)
+//   - destinations of VirtualServices which are bound to a Gateway become exposed
+//   - destinations routed to DestinationRule subsets only reach the workloads of these subsets
+//   - workloads referring to ServiceEntry hosts get external destinations
+//
+// It should only be called after ALL calls to getRelevantK8sResources successfully returned
+func (ra *resourceAccumulator) applyIstioRouting() {
+	ra.applyVirtualServices()
+	for _, res := range ra.workloads {
+		for _, addr := range res.Resource.NetworkAddrs {
+			for _, se := range ra.serviceEntries {
+				for _, dest := range se.destinationsOf(addr) {
+					if !slices.ContainsFunc(res.Resource.ExternalDestinations,
+						func(known ExternalDestination) bool { return reflect.DeepEqual(known, dest) }) {
+						res.Resource.ExternalDestinations = append(res.Resource.ExternalDestinations, dest)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (ra *resourceAccumulator) applyVirtualServices() {
+	servicesByName := map[types.NamespacedName]*Service{}
+	for _, svc := range ra.services {
+		servicesByName[types.NamespacedName{Namespace: svc.Resource.Namespace, Name: svc.Resource.Name}] = svc
+	}
+
+	namespaces := ra.scannedNamespaces()
+	unrestricted := map[*Service]bool{} // services which mesh traffic may reach without going through a subset
+	for _, vs := range ra.virtualServices {
+		meshBound := len(vs.Spec.Gateways) == 0 || slices.Contains(vs.Spec.Gateways, istioMeshGateway)
+		gatewayBound := slices.ContainsFunc(vs.Spec.Gateways, func(gw string) bool { return gw != istioMeshGateway })
+		for _, dest := range vs.destinations() {
+			svcName, _ := meshHostToService(dest.Host, vs.Namespace, namespaces)
+			svc, ok := servicesByName[svcName]
+			if !ok {
+				ra.logger.Debugf("destination host %s of VirtualService %s does not match any Service", dest.Host, vs.Name)
+				continue
+			}
+			if gatewayBound {
+				exposedPort := dest.exposedPort(svc)
+				exposedPort.exposedBy = resourceDescription(virtualService, vs.Namespace, vs.Name, vs.FilePath)
+				ra.servicesToExpose.appendPort(exposedPort)
+			}
+			if !meshBound {
+				continue
+			}
+			for _, host := range vs.aliasHosts(namespaces) {
+				svc.addHostAlias(host)
+			}
+			if subsetLabels, ok := ra.subsetLabels(dest, vs.Namespace, namespaces); ok {
+				svc.meshSubsets = append(svc.meshSubsets, subsetLabels)
+			} else {
+				unrestricted[svc] = true
+			}
+		}
+	}
+	for svc := range unrestricted {
+		svc.meshSubsets = nil
+	}
+}
+
+// aliasHosts returns the hosts of the VirtualService which do not directly refer to a K8s Service (e.g., custom domains),
+// given the namespaces of the scanned resources
+func (vs *istioVirtualService) aliasHosts(namespaces map[string]bool) []string {
+	hosts := []string{}
+	for _, host := range vs.Spec.Hosts {
+		if _, isSvcHost := meshHostToService(host, vs.Namespace, namespaces); !isSvcHost && !strings.HasPrefix(host, "*") {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// destinations returns all the route destinations of the VirtualService
+func (vs *istioVirtualService) destinations() []istioDestination {
+	dests := []istioDestination{}
+	for _, routes := range slices.Concat(vs.Spec.HTTP, vs.Spec.TLS, vs.Spec.TCP) {
+		for i := range routes.Route {
+			dests = append(dests, routes.Route[i].Destination)
+		}
+	}
+	return dests
+}
+
+// exposedPort returns the port of the given Service, to which the destination routes traffic.
+// If the destination does not specify a port, all the Service's ports are returned.
+func (dest *istioDestination) exposedPort(svc *Service) *ExposedServicePort {
+	exposedPort := ExposedServicePort{Namespace: svc.Resource.Namespace, ServiceName: svc.Resource.Name}
+	if dest.Port != nil {
+		exposedPort.Port = intstr.FromInt(dest.Port.Number)
+	}
+	return &exposedPort
+}
+
+// subsetLabels returns the labels of the DestinationRule subset to which the given destination is routed (if any)
+func (ra *resourceAccumulator) subsetLabels(dest istioDestination, namespace string,
+	namespaces map[string]bool) (map[string]string, bool) {
+	if dest.Subset == "" {
+		return nil, false
+	}
+	destSvc, _ := meshHostToService(dest.Host, namespace, namespaces)
+	for _, dr := range ra.destinationRules {
+		if drSvc, ok := meshHostToService(dr.Spec.Host, dr.Namespace, namespaces); !ok || drSvc != destSvc {
+			continue
+		}
+		for i := range dr.Spec.Subsets {
+			if dr.Spec.Subsets[i].Name == dest.Subset {
+				return dr.Spec.Subsets[i].Labels, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// meshHostToService converts an Istio host, which refers to a K8s Service, into the Service's namespace and name.
+// Short host names are interpreted relative to the given namespace (the namespace of the referring resource).
+// A "name.namespace" host only refers to a Service if its namespace is one of the given (scanned) namespaces,
+// or if it ends with ".svc" or ".svc.cluster.local" - otherwise it may be a custom domain (e.g., "bookinfo.com").
+func meshHostToService(host, namespace string, namespaces map[string]bool) (types.NamespacedName, bool) {
+	svcHost := strings.TrimSuffix(strings.TrimSuffix(host, clusterDomainSuffix), ".svc")
+	name, hostNamespace, hasNamespace := strings.Cut(svcHost, ".")
+	switch {
+	case !hasNamespace:
+		return types.NamespacedName{Namespace: namespace, Name: name}, true
+	case !strings.Contains(hostNamespace, ".") && (svcHost != host || namespaces[hostNamespace]):
+		return types.NamespacedName{Namespace: hostNamespace, Name: name}, true
+	}
+	return types.NamespacedName{}, false // not a cluster-local host (e.g., an external host or a custom domain)
+}
+
+// destinationsOf returns the external destinations of a workload, which refers to the given network address,
+// if the address matches one of the ServiceEntry hosts.
+// ServiceEntries of services inside the mesh (MESH_INTERNAL) have no external destinations.
+func (se *istioServiceEntry) destinationsOf(addr string) []ExternalDestination {
+	host, portStr, hasPort := strings.Cut(addr, ":")
+	if se.Spec.Location == istioMeshInternal || !se.matchesHost(host) {
+		return nil
+	}
+
+	addresses := []string{}
+	for _, address := range se.Spec.Addresses {
+		addresses = appendCIDR(addresses, address)
+	}
+	for i := range se.Spec.Endpoints {
+		addresses = appendCIDR(addresses, se.Spec.Endpoints[i].Address)
+	}
+
+	dests := []ExternalDestination{}
+	for i := range se.Spec.Ports {
+		port := &se.Spec.Ports[i]
+		if hasPort && portStr != strconv.Itoa(port.Number) {
+			continue
+		}
+		protocol := istioToK8sProtocol(port.Protocol)
+		dests = append(dests, ExternalDestination{Host: host, Addresses: addresses, Port: port.Number, Protocol: protocol})
+	}
+	if len(se.Spec.Ports) == 0 {
+		dests = append(dests, ExternalDestination{Host: host, Addresses: addresses})
+	}
+	return dests
+}
+
+// matchesHost checks whether the given host matches one of the ServiceEntry hosts
+func (se *istioServiceEntry) matchesHost(host string) bool {
+	return slices.ContainsFunc(se.Spec.Hosts, func(seHost string) bool { return hostMatches(seHost, host) })
+}
+
+// hostMatches checks whether the given host matches a (possibly wildcard) ServiceEntry host
+func hostMatches(seHost, host string) bool {
+	if suffix, isWildcard := strings.CutPrefix(seHost, "*"); isWildcard {
+		return strings.HasSuffix(host, suffix)
+	}
+	return seHost == host
+}
+
+// appendCIDR appends the given address to the given slice of CIDRs, if it is an IP address or a CIDR
+func appendCIDR(cidrs []string, address string) []string {
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return append(cidrs, address)
+	}
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return cidrs // e.g., a DNS name
+	case ip.To4() != nil:
+		return append(cidrs, address+"/32")
+	default:
+		return append(cidrs, address+"/128")
+	}
+}
+
+// istioToK8sProtocol converts an Istio port protocol into the transport protocol used by K8s
+func istioToK8sProtocol(protocol string) corev1.Protocol {
+	switch strings.ToUpper(protocol) {
+	case "UDP":
+		return corev1.ProtocolUDP
+	case "SCTP":
+		return corev1.ProtocolSCTP
+	default: // HTTP, HTTPS, GRPC, HTTP2, MONGO, TLS and TCP are all carried over TCP
+		return corev1.ProtocolTCP
+	}
+}
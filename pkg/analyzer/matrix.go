@@ -0,0 +1,252 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+const (
+	matrixIngressEndpoint   = "external/ingress" // traffic from outside the cluster, either directly or through the ingress sources
+	matrixInClusterEndpoint = "any in-cluster"   // traffic from any pod in the cluster
+	matrixAllPorts          = "all"
+	matrixNoPorts           = "-"
+	matrixColumnPadding     = 2
+)
+
+// ConnectivityMatrix holds the ports on which each source may connect to each workload under the synthesized NetworkPolicies.
+// The sources are all the workloads, followed by two pseudo-endpoints: "external/ingress" (traffic entering the cluster,
+// either directly or through the pods of the ingress sources) and "any in-cluster" (traffic from any pod in the cluster).
+// A port is given as protocol/number (e.g., TCP/8080), "TCP/*" stands for all the ports of a protocol, and "all" for all ports.
+type ConnectivityMatrix struct {
+	Sources      []string     `json:"sources"`
+	Destinations []string     `json:"destinations"`
+	Ports        [][][]string `json:"ports"` // Ports[i][j] are the ports on which Sources[i] may connect to Destinations[j]
+}
+
+// ConnectivityMatrixFromInfos returns the connectivity matrix under the NetworkPolicies synthesized for the connections
+// discovered while processing K8s resources in the given slice of Info objects.
+func (ps *PoliciesSynthesizer) ConnectivityMatrixFromInfos(infos []*resource.Info) (*ConnectivityMatrix, error) {
+	resAcc, errs := ps.accumulateResourcesFromInfos(infos)
+	return ps.connectivityMatrix(resAcc, errs)
+}
+
+// ConnectivityMatrixFromFolderPath returns the connectivity matrix under the NetworkPolicies synthesized for the connections
+// discovered while processing K8s resources under the provided directory or one of its subdirectories (recursively).
+func (ps *PoliciesSynthesizer) ConnectivityMatrixFromFolderPath(dirPath string) (*ConnectivityMatrix, error) {
+	return ps.ConnectivityMatrixFromFolderPaths([]string{dirPath})
+}
+
+// ConnectivityMatrixFromFolderPaths returns the connectivity matrix under the NetworkPolicies synthesized for the connections
+// discovered while processing K8s resources under the provided directories or one of their subdirectories (recursively).
+func (ps *PoliciesSynthesizer) ConnectivityMatrixFromFolderPaths(dirPaths []string) (*ConnectivityMatrix, error) {
+	resAcc, errs := ps.accumulateResourcesFromFolderPaths(dirPaths)
+	return ps.connectivityMatrix(resAcc, errs)
+}
+
+func (ps *PoliciesSynthesizer) connectivityMatrix(resAcc *resourceAccumulator, errs []FileProcessingError) (*ConnectivityMatrix, error) {
+	var matrix *ConnectivityMatrix
+	if !stopProcessing(ps.stopOnError, errs) {
+		resources, connections, connErrs := ps.extractConnections(resAcc)
+		errs = append(errs, connErrs...)
+		if !stopProcessing(ps.stopOnError, errs) {
+			matrix = buildConnectivityMatrix(resources, resAcc.services, connections, ps.synthNetpols(resources, connections))
+		}
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return matrix, nil
+}
+
+// buildConnectivityMatrix evaluates the given policies for traffic from each workload and pseudo-endpoint to each workload.
+// The ports checked for each workload are its own ports, the target ports of the Services selecting it,
+// and the ports mentioned in the policy rules.
+func buildConnectivityMatrix(resources []*Resource, services []*Service, connections []*Connections,
+	policies []*network.NetworkPolicy) *ConnectivityMatrix {
+	workloads := slices.Clone(resources)
+	slices.SortFunc(workloads, func(res1, res2 *Resource) int {
+		return strings.Compare(workloadIdentity(res1), workloadIdentity(res2))
+	})
+	workloads = slices.CompactFunc(workloads, func(res1, res2 *Resource) bool { // a workload may be defined in several files
+		return workloadIdentity(res1) == workloadIdentity(res2)
+	})
+
+	matrix := ConnectivityMatrix{Destinations: []string{}, Ports: [][][]string{}}
+	sourcePeers := [][]FlowPeer{}
+	for _, res := range workloads {
+		matrix.Destinations = append(matrix.Destinations, workloadIdentity(res))
+		sourcePeers = append(sourcePeers, []FlowPeer{workloadPeer(res)})
+	}
+	matrix.Sources = append(slices.Clone(matrix.Destinations), matrixIngressEndpoint, matrixInClusterEndpoint)
+	sourcePeers = append(sourcePeers, ingressPeers(connections), []FlowPeer{{}})
+
+	rulePorts := policyRulePorts(policies)
+	candidates := make([][]FlowPort, 0, len(workloads))
+	for _, res := range workloads {
+		candidates = append(candidates, candidatePorts(res, services, rulePorts))
+	}
+	for _, peers := range sourcePeers {
+		row := make([][]string, 0, len(workloads))
+		for j, res := range workloads {
+			row = append(row, allowedPorts(policies, peers, res, candidates[j]))
+		}
+		matrix.Ports = append(matrix.Ports, row)
+	}
+	return &matrix
+}
+
+// ingressPeers returns the peers from which traffic enters the cluster: addresses outside the cluster,
+// and the ingress sources of the Services exposed by the given connections
+func ingressPeers(connections []*Connections) []FlowPeer {
+	peers := []FlowPeer{{External: true}}
+	for _, conn := range connections {
+		if conn.Source != nil {
+			continue
+		}
+		for _, flow := range connectionFlows(conn) {
+			if !slices.ContainsFunc(peers, func(peer FlowPeer) bool { return peer.includes(&flow.sourcePeer) && flow.sourcePeer.includes(&peer) }) {
+				peers = append(peers, flow.sourcePeer)
+			}
+		}
+	}
+	return peers
+}
+
+// policyRulePorts returns the numbered ports mentioned in the rules of the given policies
+func policyRulePorts(policies []*network.NetworkPolicy) []FlowPort {
+	ports := []FlowPort{}
+	addPorts := func(rulePorts []network.NetworkPolicyPort) {
+		for i := range rulePorts {
+			if rulePorts[i].Port == nil || rulePorts[i].Port.Type == intstr.String {
+				continue
+			}
+			protocol := corev1.ProtocolTCP
+			if rulePorts[i].Protocol != nil {
+				protocol = *rulePorts[i].Protocol
+			}
+			ports = append(ports, FlowPort{Number: int(rulePorts[i].Port.IntVal), Protocol: protocol})
+		}
+	}
+	for _, policy := range policies {
+		for i := range policy.Spec.Ingress {
+			addPorts(policy.Spec.Ingress[i].Ports)
+		}
+		for i := range policy.Spec.Egress {
+			addPorts(policy.Spec.Egress[i].Ports)
+		}
+	}
+	return ports
+}
+
+// candidatePorts returns the ports of the given workload, followed by the given rule ports, sorted by protocol and number
+func candidatePorts(res *Resource, services []*Service, rulePorts []FlowPort) []FlowPort {
+	ports := workloadFlowPorts(res, services)
+	for i := range rulePorts {
+		if !slices.ContainsFunc(ports, func(port FlowPort) bool { return port.key() == rulePorts[i].key() }) {
+			ports = append(ports, rulePorts[i])
+		}
+	}
+	slices.SortStableFunc(ports, func(port1, port2 FlowPort) int {
+		if port1.Protocol != port2.Protocol {
+			return strings.Compare(string(port1.Protocol), string(port2.Protocol))
+		}
+		return port1.Number - port2.Number
+	})
+	return ports
+}
+
+// allowedPorts returns the ports of the given target, on which the given policies allow traffic from (one of) the given peers
+func allowedPorts(policies []*network.NetworkPolicy, peers []FlowPeer, target *Resource, candidates []FlowPort) []string {
+	targetPeer := workloadPeer(target)
+	allowed := func(port *FlowPort) bool {
+		return slices.ContainsFunc(peers, func(peer FlowPeer) bool { return EvaluateFlow(policies, &peer, &targetPeer, port).Allowed })
+	}
+
+	ports := []string{}
+	allProtocols := []corev1.Protocol{corev1.ProtocolSCTP, corev1.ProtocolTCP, corev1.ProtocolUDP}
+	openProtocols := []corev1.Protocol{}
+	for _, protocol := range allProtocols {
+		if allowed(&FlowPort{Protocol: protocol}) { // an unnumbered port is only allowed if all the ports of its protocol are
+			openProtocols = append(openProtocols, protocol)
+			ports = append(ports, string(protocol)+"/*")
+		}
+	}
+	if len(openProtocols) == len(allProtocols) {
+		return []string{matrixAllPorts}
+	}
+	for i := range candidates {
+		if !slices.Contains(openProtocols, candidates[i].Protocol) && allowed(&candidates[i]) {
+			ports = appendUnique(ports, candidates[i].key())
+		}
+	}
+	return ports
+}
+
+// String returns the matrix as a text table, with a row for each source and a column for each destination
+func (matrix *ConnectivityMatrix) String() string {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 0, matrixColumnPadding, ' ', 0)
+	for _, row := range matrix.table(",") {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// CSV returns the matrix in CSV format, with a row for each source and a column for each destination
+func (matrix *ConnectivityMatrix) CSV() string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.WriteAll(matrix.table(" ")) // writing to a buffer does not fail
+	return buf.String()
+}
+
+// Markdown returns the matrix as a Markdown table, with a row for each source and a column for each destination
+func (matrix *ConnectivityMatrix) Markdown() string {
+	var sb strings.Builder
+	for i, row := range matrix.table(", ") {
+		for j := range row {
+			row[j] = markdownCell(row[j])
+		}
+		fmt.Fprintf(&sb, "| %s |\n", strings.Join(row, " | "))
+		if i == 0 {
+			fmt.Fprintf(&sb, "|%s\n", strings.Repeat(" --- |", len(row)))
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// table returns the header row and a row for each source, joining the ports in each cell with the given separator
+func (matrix *ConnectivityMatrix) table(portSeparator string) [][]string {
+	rows := [][]string{append([]string{"source \\ destination"}, matrix.Destinations...)}
+	for i, source := range matrix.Sources {
+		row := []string{source}
+		for _, ports := range matrix.Ports[i] {
+			if len(ports) == 0 {
+				row = append(row, matrixNoPorts)
+			} else {
+				row = append(row, strings.Join(ports, portSeparator))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
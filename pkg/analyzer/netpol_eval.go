@@ -0,0 +1,305 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"net"
+	"slices"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// FlowPeer is one end of a network flow, as seen by NetworkPolicies: either a set of pods or the addresses outside the cluster.
+// Namespaces are assumed to carry only the label K8s puts on each namespace, holding its name.
+type FlowPeer struct {
+	Namespace string            `json:"namespace,omitempty"` // the namespace of the pods; empty means pods in any namespace
+	Labels    map[string]string `json:"labels,omitempty"`    // the labels of the pods; nil means any pod in the namespace(s)
+	External  bool              `json:"external,omitempty"`  // the peer is outside the cluster
+}
+
+// workloadPeer returns the peer standing for the pods of the given workload
+func workloadPeer(res *Resource) FlowPeer {
+	labels := res.Resource.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return FlowPeer{Namespace: namespaceOrDefault(res.Resource.Namespace), Labels: labels}
+}
+
+// ingressSourcePeer returns the peer standing for the pods of the given ingress source
+func ingressSourcePeer(source *IngressSource) FlowPeer {
+	peer := FlowPeer{Namespace: source.Namespace}
+	if len(source.PodLabels) > 0 {
+		peer.Labels = source.PodLabels
+	}
+	return peer
+}
+
+// includes checks whether all the endpoints of the other peer are also endpoints of this peer
+func (fp *FlowPeer) includes(other *FlowPeer) bool {
+	if fp.External || other.External {
+		return fp.External == other.External
+	}
+	if fp.Namespace != "" && fp.Namespace != other.Namespace {
+		return false
+	}
+	if fp.Labels == nil {
+		return true
+	}
+	if other.Labels == nil {
+		return false
+	}
+	for key, value := range fp.Labels {
+		if otherValue, ok := other.Labels[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// FlowPort is the destination port of a network flow. A port may be known only by its name, if it could not be resolved.
+type FlowPort struct {
+	Number   int             `json:"number,omitempty"`
+	Name     string          `json:"name,omitempty"` // the name of the port in the destination pod's container, if it has one
+	Protocol corev1.Protocol `json:"protocol"`
+}
+
+func (fp *FlowPort) key() string {
+	if fp.Number == 0 {
+		return string(fp.Protocol) + "/" + fp.Name
+	}
+	return string(fp.Protocol) + "/" + strconv.Itoa(fp.Number)
+}
+
+// targetFlowPorts returns the destination ports of traffic, sent to the given Service ports and forwarded to the given target workload.
+// Ports whose named target ports cannot be resolved are skipped, as no traffic is forwarded to them.
+func targetFlowPorts(ports []SvcNetworkAttr, target *Resource) []FlowPort {
+	flowPorts := make([]FlowPort, 0, len(ports))
+	for i := range ports {
+		targetPort, ok := target.resolveTargetPort(&ports[i])
+		if !ok && targetPort.Type == intstr.String {
+			continue
+		}
+		flowPorts = append(flowPorts, workloadFlowPort(target, targetPort, protocolOrDefault(ports[i].Protocol)))
+	}
+	return flowPorts
+}
+
+// workloadFlowPort returns the given port of the given workload, along with its name (if the workload declares it)
+func workloadFlowPort(res *Resource, port intstr.IntOrString, protocol corev1.Protocol) FlowPort {
+	fp := FlowPort{Protocol: protocol}
+	if port.Type == intstr.String {
+		fp.Name = port.StrVal
+	} else {
+		fp.Number = int(port.IntVal)
+	}
+	for _, containerPort := range res.Resource.ContainerPorts {
+		if containerPort.matches(&port, protocol) {
+			fp.Number, fp.Name = containerPort.Port, containerPort.Name
+			break
+		}
+	}
+	return fp
+}
+
+// FlowVerdict tells whether NetworkPolicies allow a flow, and which policies decided so
+type FlowVerdict struct {
+	Allowed bool `json:"allowed"`
+	// the policies allowing the flow out of the source pods, or if they deny it - the policies isolating the source pods
+	EgressPolicies []string `json:"egress_policies,omitempty"`
+	// the policies allowing the flow into the destination pods, or if they deny it - the policies isolating the destination pods
+	IngressPolicies []string `json:"ingress_policies,omitempty"`
+}
+
+// EvaluateFlow decides whether the given NetworkPolicies allow traffic from the source to the destination port of the destination.
+// Egress (ingress) policies are only evaluated if the source (destination) stands for specific pods: pods in a given namespace
+// with given labels. Pods are never matched by ipBlock peers, and addresses outside the cluster are only matched by ipBlock peers
+// covering all addresses (e.g., 0.0.0.0/0).
+func EvaluateFlow(policies []*network.NetworkPolicy, source, destination *FlowPeer, port *FlowPort) FlowVerdict {
+	verdict := FlowVerdict{Allowed: true}
+	if source.isSpecificPods() {
+		egress := evaluatePolicies(policies, source, network.PolicyTypeEgress, destination, port)
+		verdict.Allowed = egress.allowed
+		verdict.EgressPolicies = egress.policies
+	}
+	if destination.isSpecificPods() {
+		ingress := evaluatePolicies(policies, destination, network.PolicyTypeIngress, source, port)
+		verdict.Allowed = verdict.Allowed && ingress.allowed
+		verdict.IngressPolicies = ingress.policies
+	}
+	return verdict
+}
+
+// VerifyPolicies checks that the given NetworkPolicies allow all the given connections.
+// It returns the connections which the policies deny on some of their ports (an empty slice if none).
+func VerifyPolicies(connections []*Connections, policies []*network.NetworkPolicy) []BlockedConnection {
+	return blockedConnections(connections, policies)
+}
+
+func (fp *FlowPeer) isSpecificPods() bool {
+	return !fp.External && fp.Namespace != "" && fp.Labels != nil
+}
+
+// policyVerdict tells whether the policies of some pods allow a flow in one direction (ingress or egress)
+type policyVerdict struct {
+	isolated bool     // some policy selects the pods for this direction
+	allowed  bool     // the flow is allowed
+	policies []string // the policies allowing the flow, or if it is denied - the policies isolating the pods
+}
+
+// evaluatePolicies decides whether the given policies allow a flow in the given direction between the given pods
+// and the given peer (the source of ingress flows, or the destination of egress flows), with the given destination port
+func evaluatePolicies(policies []*network.NetworkPolicy, pods *FlowPeer, direction network.PolicyType, peer *FlowPeer,
+	port *FlowPort) policyVerdict {
+	verdict := policyVerdict{}
+	isolating := []string{}
+	for _, policy := range policies {
+		if !policySelects(policy, pods) || !policyAppliesTo(policy, direction) {
+			continue
+		}
+		verdict.isolated = true
+		policyName := namespacedName(policy.Namespace, policy.Name)
+		isolating = append(isolating, policyName)
+		if policyAllows(policy, direction, peer, port) {
+			verdict.policies = append(verdict.policies, policyName)
+		}
+	}
+	verdict.allowed = !verdict.isolated || len(verdict.policies) > 0
+	if !verdict.allowed {
+		verdict.policies = isolating
+	}
+	return verdict
+}
+
+// policySelects checks whether the given policy applies to the given pods
+func policySelects(policy *network.NetworkPolicy, pods *FlowPeer) bool {
+	return !pods.External && namespaceOrDefault(policy.Namespace) == pods.Namespace && labelsMatch(&policy.Spec.PodSelector, pods.Labels)
+}
+
+// policyAppliesTo checks whether the given policy isolates the pods it selects in the given direction.
+// A policy without policy types always isolates ingress, and isolates egress only if it has egress rules.
+func policyAppliesTo(policy *network.NetworkPolicy, direction network.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return direction == network.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	return slices.Contains(policy.Spec.PolicyTypes, direction)
+}
+
+// policyAllows checks whether one of the rules of the given policy allows the flow with the given peer and destination port
+func policyAllows(policy *network.NetworkPolicy, direction network.PolicyType, peer *FlowPeer, port *FlowPort) bool {
+	namespace := namespaceOrDefault(policy.Namespace)
+	if direction == network.PolicyTypeIngress {
+		return slices.ContainsFunc(policy.Spec.Ingress, func(rule network.NetworkPolicyIngressRule) bool {
+			return peer.matchedBy(rule.From, namespace) && port.matchedBy(rule.Ports)
+		})
+	}
+	return slices.ContainsFunc(policy.Spec.Egress, func(rule network.NetworkPolicyEgressRule) bool {
+		return peer.matchedBy(rule.To, namespace) && port.matchedBy(rule.Ports)
+	})
+}
+
+// matchedBy checks whether the given rule peers (of a policy in the given namespace) match all the endpoints of this peer.
+// An empty peer list matches all endpoints.
+func (fp *FlowPeer) matchedBy(peers []network.NetworkPolicyPeer, policyNamespace string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for i := range peers {
+		if fp.matchedByPeer(&peers[i], policyNamespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchedByPeer checks whether the given rule peer matches all the endpoints of this peer.
+// Pods are never matched by IP blocks, as their addresses are unknown, and addresses outside the cluster are only matched
+// by IP blocks covering all addresses (e.g., 0.0.0.0/0), regardless of their exceptions.
+func (fp *FlowPeer) matchedByPeer(peer *network.NetworkPolicyPeer, policyNamespace string) bool {
+	if peer.IPBlock != nil {
+		return fp.External && coversAllAddresses(peer.IPBlock.CIDR)
+	}
+	if fp.External {
+		return false
+	}
+	if peer.NamespaceSelector == nil {
+		if fp.Namespace != policyNamespace {
+			return false
+		}
+	} else if !labelsMatch(peer.NamespaceSelector, namespaceLabels(fp.Namespace)) {
+		return false
+	}
+	return peer.PodSelector == nil || labelsMatch(peer.PodSelector, fp.Labels)
+}
+
+// matchedBy checks whether one of the given rule ports matches this port. An empty port list matches all ports.
+func (fp *FlowPort) matchedBy(ports []network.NetworkPolicyPort) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for i := range ports {
+		port := &ports[i]
+		protocol := corev1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+		if protocol != fp.Protocol {
+			continue
+		}
+		switch {
+		case port.Port == nil:
+			return true
+		case port.Port.Type == intstr.String:
+			if fp.Name != "" && port.Port.StrVal == fp.Name {
+				return true
+			}
+		default:
+			endPort := port.Port.IntVal
+			if port.EndPort != nil {
+				endPort = *port.EndPort
+			}
+			if fp.Number != 0 && fp.Number >= int(port.Port.IntVal) && fp.Number <= int(endPort) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labelsMatch checks whether the given selector selects all the objects with the given labels (nil labels stand for any labels)
+func labelsMatch(selector *metaV1.LabelSelector, labels map[string]string) bool {
+	if labels == nil {
+		return isEmptySelector(selector)
+	}
+	labelSelector, err := metaV1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return labelSelector.Matches(k8slabels.Set(labels))
+}
+
+// namespaceLabels returns the labels of the given namespace (nil for any namespace)
+func namespaceLabels(namespace string) map[string]string {
+	if namespace == "" {
+		return nil
+	}
+	return map[string]string{namespaceNameLabel: namespace}
+}
+
+func coversAllAddresses(cidr string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones == 0
+}
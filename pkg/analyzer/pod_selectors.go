@@ -0,0 +1,185 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"slices"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// preferredSelectorLabel is preferred over other labels when choosing among equally small pod selectors
+	preferredSelectorLabel = "app.kubernetes.io/name"
+	// maxExhaustiveSelectorSize is the largest pod selector searched for among all label subsets of its size;
+	// larger selectors are chosen greedily, as the number of label subsets grows exponentially
+	maxExhaustiveSelectorSize = 3
+)
+
+// podSelectors returns the selector which generated policies should use for selecting the pods of each given workload
+// (keyed by workloadIdentity())
+func (ps *PoliciesSynthesizer) podSelectors(resources []*Resource) map[string]*metaV1.LabelSelector {
+	workloadsPerNamespace := map[string][]*Resource{} // a workload with no namespace is in the default namespace
+	for _, res := range resources {
+		namespace := namespaceOrDefault(res.Resource.Namespace)
+		workloadsPerNamespace[namespace] = append(workloadsPerNamespace[namespace], res)
+	}
+	selectors := map[string]*metaV1.LabelSelector{}
+	for _, res := range resources {
+		namespaceWorkloads := workloadsPerNamespace[namespaceOrDefault(res.Resource.Namespace)]
+		if !ps.minimalSelectors && len(ps.ignoredPodLabels) == 0 && ownSelectorSelectsOnly(res, namespaceWorkloads) {
+			selectors[workloadIdentity(res)] = res.Resource.Selector
+			continue
+		}
+		selectors[workloadIdentity(res)] = &metaV1.LabelSelector{MatchLabels: ps.podSelectorLabels(res, namespaceWorkloads)}
+	}
+	return selectors
+}
+
+// ownSelectorSelectsOnly checks whether the workload's own selector (spec.selector) selects the pods of the workload,
+// but none of the pods of the other given workloads
+func ownSelectorSelectsOnly(res *Resource, namespaceWorkloads []*Resource) bool {
+	if res.Resource.Selector == nil {
+		return false
+	}
+	selector, err := metaV1.LabelSelectorAsSelector(res.Resource.Selector)
+	if err != nil || selector.Empty() || !selector.Matches(k8slabels.Set(res.Resource.Labels)) {
+		return false
+	}
+	for _, other := range namespaceWorkloads {
+		if other != res && selector.Matches(k8slabels.Set(other.Resource.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+// podSelectorLabels returns the labels for selecting the pods of the given workload, but not the pods of the other given workloads.
+// Ignored labels are left out, and if minimal selectors are requested, the smallest such label subset is returned
+// (if it has more than maxExhaustiveSelectorSize labels, a small subset is chosen greedily instead).
+// If no such subset exists, all the labels of the workload are returned.
+func (ps *PoliciesSynthesizer) podSelectorLabels(res *Resource, namespaceWorkloads []*Resource) map[string]string {
+	labels := res.Resource.Labels
+	candidates := []string{}
+	for key := range labels {
+		if !slices.Contains(ps.ignoredPodLabels, key) {
+			candidates = append(candidates, key)
+		}
+	}
+	slices.Sort(candidates)
+	if len(candidates) == 0 || !selectsOnly(labels, candidates, res, namespaceWorkloads) {
+		return labels // a subset of the candidates cannot select fewer workloads than all the candidates
+	}
+	if !ps.minimalSelectors {
+		return labelSubset(labels, candidates)
+	}
+
+	for size := 1; size < len(candidates) && size <= maxExhaustiveSelectorSize; size++ {
+		var selected []string
+		for _, keys := range combinations(candidates, size) {
+			if !selectsOnly(labels, keys, res, namespaceWorkloads) {
+				continue
+			}
+			if selected == nil || slices.Contains(keys, preferredSelectorLabel) {
+				selected = keys
+			}
+			if slices.Contains(selected, preferredSelectorLabel) {
+				break
+			}
+		}
+		if selected != nil {
+			return labelSubset(labels, selected)
+		}
+	}
+	return labelSubset(labels, greedySelectorKeys(labels, candidates, res, namespaceWorkloads))
+}
+
+// greedySelectorKeys returns a subset of the given candidate label keys (which select the pods of the given workload
+// and none of the pods of the other given workloads), which also does so. Starting with the preferred label (if a candidate),
+// the label which rules out most of the remaining other workloads is repeatedly added, until none of them is selected.
+func greedySelectorKeys(labels map[string]string, candidates []string, res *Resource, workloads []*Resource) []string {
+	remaining := []*Resource{}
+	for _, other := range workloads {
+		if other != res {
+			remaining = append(remaining, other)
+		}
+	}
+	keys := []string{}
+	addKey := func(key string) {
+		keys = append(keys, key)
+		remaining = slices.DeleteFunc(remaining, func(other *Resource) bool {
+			return selectsOnly(labels, []string{key}, res, []*Resource{other}) // the key rules the other workload out
+		})
+	}
+	if slices.Contains(candidates, preferredSelectorLabel) {
+		addKey(preferredSelectorLabel)
+	}
+	for len(remaining) > 0 {
+		bestKey, bestCount := "", 0
+		for _, key := range candidates {
+			if slices.Contains(keys, key) {
+				continue
+			}
+			count := 0
+			for _, other := range remaining {
+				if selectsOnly(labels, []string{key}, res, []*Resource{other}) {
+					count++
+				}
+			}
+			if count > bestCount {
+				bestKey, bestCount = key, count
+			}
+		}
+		addKey(bestKey)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// selectsOnly checks whether a selector with the given label keys (with values taken from the given labels)
+// selects the pods of the given workload and none of the pods of the other given workloads
+func selectsOnly(labels map[string]string, keys []string, res *Resource, workloads []*Resource) bool {
+	for _, other := range workloads {
+		if other == res {
+			continue
+		}
+		selected := true
+		for _, key := range keys {
+			if value, ok := other.Resource.Labels[key]; !ok || value != labels[key] {
+				selected = false
+				break
+			}
+		}
+		if selected {
+			return false
+		}
+	}
+	return true
+}
+
+// combinations returns all the subsets of the given size of the given (sorted) keys, in lexicographic order
+func combinations(keys []string, size int) [][]string {
+	if size == 0 {
+		return [][]string{{}}
+	}
+	result := [][]string{}
+	for i := 0; i <= len(keys)-size; i++ {
+		for _, rest := range combinations(keys[i+1:], size-1) {
+			result = append(result, append([]string{keys[i]}, rest...))
+		}
+	}
+	return result
+}
+
+func labelSubset(labels map[string]string, keys []string) map[string]string {
+	subset := make(map[string]string, len(keys))
+	for _, key := range keys {
+		subset[key] = labels[key]
+	}
+	return subset
+}
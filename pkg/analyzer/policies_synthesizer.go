@@ -11,10 +11,14 @@ SPDX-License-Identifier: Apache-2.0
 package analyzer
 
 import (
+	"fmt"
 	"io/fs"
+	"net"
 	"path/filepath"
+	"slices"
 
 	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/cli-runtime/pkg/resource"
 )
@@ -36,8 +40,25 @@ type PoliciesSynthesizer struct {
 	stopOnError bool
 	walkFn      WalkFunction
 	dnsPort     intstr.IntOrString
-
-	errors []FileProcessingError
+	probeCIDRs  []string
+	extractors  *extractorRegistry
+
+	ingressSources        ingressSources
+	istioTrustDomain      string
+	ciliumDNSVisibility   bool
+	calicoWorkloadOrder   float64
+	calicoBaselineOrder   float64
+	baselineAdminPolicy   bool
+	adminAllowDNS         bool
+	monitoringNamespace   string
+	minimalSelectors      bool
+	ignoredPodLabels      []string
+	provenanceAnnotations bool
+
+	adminPolicies []*AdminPolicy
+
+	optionErrors []FileProcessingError // errors in the given options, whose invalid values are ignored
+	errors       []FileProcessingError
 }
 
 // PoliciesSynthesizerOption is the type for specifying options for PoliciesSynthesizer,
@@ -82,6 +103,141 @@ func WithDNSNamedPort(dnsPort string) PoliciesSynthesizerOption {
 	}
 }
 
+// WithProbeSourceCIDRs is a functional option to allow ingress traffic from the given CIDRs (typically, the cluster's node CIDRs)
+// to the ports used by the workloads' liveness, readiness and startup probes. This is only required when the cluster's network
+// plugin enforces NetworkPolicies on traffic from the kubelet. Invalid CIDRs are ignored, and reported as errors.
+func WithProbeSourceCIDRs(cidrs []string) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.probeCIDRs = cidrs
+	}
+}
+
+// WithIngressSource is a functional option to declare where the cluster's ingress controller (or Gateway implementation) runs.
+// Services exposed by Ingress, Route or Gateway API route resources will then only accept traffic from the pods of the given source,
+// rather than from all pods in the cluster.
+func WithIngressSource(source IngressSource) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.ingressSources.defaultSource = source
+	}
+}
+
+// WithGatewayClassIngressSource is a functional option to declare where the pods of Gateways with the given GatewayClass name run.
+// Services exposed by Gateway API routes, which are attached to such Gateways, will then only accept traffic from these pods.
+// If source.Namespace is empty, the pods are assumed to run in the Gateway's namespace. If source.PodLabels is empty,
+// the pods are assumed to be labeled with "gateway.networking.k8s.io/gateway-name: <Gateway name>",
+// as is the case for implementations which deploy a dedicated proxy for each Gateway (e.g., Istio).
+func WithGatewayClassIngressSource(gatewayClassName string, source IngressSource) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.ingressSources.gatewayClasses[gatewayClassName] = source
+	}
+}
+
+// WithIstioTrustDomain is a functional option to set the trust domain of the workload identities (principals)
+// in the generated Istio AuthorizationPolicies to a non-default value
+func WithIstioTrustDomain(trustDomain string) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.istioTrustDomain = trustDomain
+	}
+}
+
+// WithCiliumDNSVisibility is a functional option which directs the generated CiliumNetworkPolicies to allow DNS traffic
+// only to kube-dns, and to pass it through Cilium's DNS proxy (making DNS queries visible, e.g., in Hubble).
+// This is always the case for workloads which connect to external destinations by their DNS names.
+func WithCiliumDNSVisibility() PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.ciliumDNSVisibility = true
+	}
+}
+
+// WithCalicoPolicyOrders is a functional option to set the orders of the generated Calico policies to non-default values:
+// the order of the NetworkPolicy generated for each workload, and the order of the GlobalNetworkPolicy denying all other traffic.
+// By default, these are 100 and 10000. Calico evaluates policies with a lower order first, so the workload order must be
+// lower than the baseline order (otherwise, an error is reported and the default orders are used), and both should fit
+// among the orders of the cluster's existing Calico policies.
+func WithCalicoPolicyOrders(workloadOrder, baselineOrder float64) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.calicoWorkloadOrder = workloadOrder
+		p.calicoBaselineOrder = baselineOrder
+	}
+}
+
+// WithBaselineAdminNetworkPolicy is a functional option which replaces the default-deny NetworkPolicy, generated for each
+// workload namespace, with a single BaselineAdminNetworkPolicy, denying all traffic of the workload namespaces
+// which is not allowed by the generated NetworkPolicies. Get the generated admin policies by calling AdminPolicies().
+// As BaselineAdminNetworkPolicies cannot select ingress sources outside the cluster, ingress traffic from outside
+// the cluster is only denied to pods which are selected by some NetworkPolicy.
+func WithBaselineAdminNetworkPolicy() PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.baselineAdminPolicy = true
+	}
+}
+
+// WithAdminNetworkPolicyAllowingDNS is a functional option to generate an AdminNetworkPolicy, which always allows
+// egress DNS traffic from the workload namespaces to kube-dns, regardless of any NetworkPolicy.
+// Get the generated admin policies by calling AdminPolicies().
+func WithAdminNetworkPolicyAllowingDNS() PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.adminAllowDNS = true
+	}
+}
+
+// WithAdminNetworkPolicyAllowingMonitoring is a functional option to generate an AdminNetworkPolicy, which always allows
+// ingress traffic from the given monitoring namespace (e.g., where Prometheus runs) to the workload namespaces,
+// regardless of any NetworkPolicy. Get the generated admin policies by calling AdminPolicies().
+func WithAdminNetworkPolicyAllowingMonitoring(monitoringNamespace string) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.monitoringNamespace = monitoringNamespace
+	}
+}
+
+// WithMinimalPodSelectors is a functional option which directs the generated policies to select the pods of each workload
+// using the smallest subset of its pod labels, which does not select the pods of any other scanned workload in its namespace
+// (preferring the "app.kubernetes.io/name" label), rather than using all the labels of its pod template.
+// If more than 3 labels are needed, a small subset is chosen greedily instead of searching all subsets.
+// Note that pods of workloads which are not scanned may also be selected.
+func WithMinimalPodSelectors() PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.minimalSelectors = true
+	}
+}
+
+// WithIgnoredPodLabels is a functional option to leave the given (volatile) labels, e.g., "version" or "helm.sh/chart",
+// out of the pod selectors in the generated policies, as long as the pods of each workload can be told apart without them.
+func WithIgnoredPodLabels(labels []string) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.ignoredPodLabels = labels
+	}
+}
+
+// WithProvenanceAnnotations is a functional option which directs the generated NetworkPolicies to carry annotations,
+// naming the workload each policy is generated for, and explaining why each rule is required: which address in which
+// container field (or ConfigMap key) of which source workload refers to which Service, which Service is exposed, etc.
+func WithProvenanceAnnotations() PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.provenanceAnnotations = true
+	}
+}
+
+// WithWorkloadExtractor is a functional option for analyzing resources of a custom kind (e.g., a CRD) as workloads.
+// The given extractor is used to extract the pod template of each resource with the given GroupVersionKind.
+// It replaces the built-in extractor if the GroupVersionKind is already supported, while keeping the rest of the
+// built-in handling of the kind (e.g., the Service which Knative implicitly creates for each Knative Service).
+func WithWorkloadExtractor(gvk schema.GroupVersionKind, extractor WorkloadExtractor) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.extractors.registerWorkload(gvk.Kind, extractor, gvk.GroupVersion())
+	}
+}
+
+// WithExposureExtractor is a functional option for analyzing resources of a custom kind as exposing Services
+// (as is done for Ingress and Route resources). The given extractor is used to extract the exposed Service ports
+// from each resource with the given GroupVersionKind.
+// It replaces the built-in extractor if the GroupVersionKind is already supported.
+func WithExposureExtractor(gvk schema.GroupVersionKind, extractor ExposureExtractor) PoliciesSynthesizerOption {
+	return func(p *PoliciesSynthesizer) {
+		p.extractors.registerExposure(gvk.Kind, extractor, gvk.GroupVersion())
+	}
+}
+
 // NewPoliciesSynthesizer creates a new instance of PoliciesSynthesizer, and applies the provided functional options.
 func NewPoliciesSynthesizer(options ...PoliciesSynthesizerOption) *PoliciesSynthesizer {
 	// object with default behavior options
@@ -90,36 +246,77 @@ func NewPoliciesSynthesizer(options ...PoliciesSynthesizerOption) *PoliciesSynth
 		stopOnError: false,
 		walkFn:      filepath.WalkDir,
 		dnsPort:     intstr.FromInt(DefaultDNSPort),
-		errors:      []FileProcessingError{},
+		extractors:  newExtractorRegistry(),
+		ingressSources: ingressSources{
+			gatewayClasses: defaultGatewayClassSources(),
+		},
+		istioTrustDomain:    DefaultIstioTrustDomain,
+		calicoWorkloadOrder: calicoWorkloadPolicyOrder,
+		calicoBaselineOrder: calicoBaselinePolicyOrder,
+		errors:              []FileProcessingError{},
 	}
 	for _, o := range options {
 		o(ps)
 	}
+	ps.optionErrors = ps.validateOptions()
 	return ps
 }
 
-// Errors returns a slice of FileProcessingError with all warnings and errors encountered during processing.
+// validateOptions reports the invalid values given in the options, and replaces them with valid ones
+func (ps *PoliciesSynthesizer) validateOptions() []FileProcessingError {
+	errs := []FileProcessingError{}
+	validCIDRs := make([]string, 0, len(ps.probeCIDRs))
+	for _, cidr := range ps.probeCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = appendAndLogNewError(errs, invalidOption("WithProbeSourceCIDRs", err.Error()+"; the CIDR is ignored"), ps.logger)
+			continue
+		}
+		validCIDRs = append(validCIDRs, cidr)
+	}
+	ps.probeCIDRs = validCIDRs
+	if ps.calicoWorkloadOrder >= ps.calicoBaselineOrder {
+		reason := fmt.Sprintf("the workload order (%v) must be lower than the baseline order (%v); using the default orders",
+			ps.calicoWorkloadOrder, ps.calicoBaselineOrder)
+		errs = appendAndLogNewError(errs, invalidOption("WithCalicoPolicyOrders", reason), ps.logger)
+		ps.calicoWorkloadOrder, ps.calicoBaselineOrder = calicoWorkloadPolicyOrder, calicoBaselinePolicyOrder
+	}
+	return errs
+}
+
+// Errors returns a slice of FileProcessingError with all warnings and errors encountered during processing,
+// preceded by the errors in the synthesizer's options.
 func (ps *PoliciesSynthesizer) Errors() []FileProcessingError {
-	return ps.errors
+	return append(slices.Clone(ps.optionErrors), ps.errors...)
 }
 
-// ErrorPtrs returns a slice of pointers to FileProcessingError with all warnings and errors encountered during processing.
+// ErrorPtrs returns a slice of pointers to FileProcessingError with all warnings and errors encountered during processing,
+// preceded by the errors in the synthesizer's options.
 // Might be easier to use than Errors() if the returned slice is to be used as a slice of interfaces.
 func (ps *PoliciesSynthesizer) ErrorPtrs() []*FileProcessingError {
-	ret := make([]*FileProcessingError, len(ps.errors))
-	for idx := range ps.errors {
-		ret[idx] = &ps.errors[idx]
+	errs := ps.Errors()
+	ret := make([]*FileProcessingError, len(errs))
+	for idx := range errs {
+		ret[idx] = &errs[idx]
 	}
 	return ret
 }
 
+// AdminPolicies returns the cluster-scoped admin policies (AdminNetworkPolicies and BaselineAdminNetworkPolicy), which
+// were generated along with the NetworkPolicies by the last call to one of the PoliciesFrom*() methods.
+// Admin policies are only generated if requested by the synthesizer's options.
+func (ps *PoliciesSynthesizer) AdminPolicies() []*AdminPolicy {
+	return ps.adminPolicies
+}
+
 // PoliciesFromInfos returns a slice of Kubernetes NetworkPolicies that allow only the connections discovered
 // while processing K8s resources in the given slice of Info objects.
 func (ps *PoliciesSynthesizer) PoliciesFromInfos(infos []*resource.Info) ([]*networking.NetworkPolicy, error) {
 	resources, connections, errs := ps.extractConnectionsFromInfos(infos)
 	policies := []*networking.NetworkPolicy{}
+	ps.adminPolicies = nil
 	if !stopProcessing(ps.stopOnError, errs) {
 		policies = ps.synthNetpols(resources, connections)
+		ps.adminPolicies = ps.synthAdminPolicies(resources)
 	}
 
 	ps.errors = errs
@@ -141,8 +338,50 @@ func (ps *PoliciesSynthesizer) PoliciesFromFolderPath(dirPath string) ([]*networ
 func (ps *PoliciesSynthesizer) PoliciesFromFolderPaths(dirPaths []string) ([]*networking.NetworkPolicy, error) {
 	resources, connections, errs := ps.extractConnectionsFromFolderPaths(dirPaths)
 	policies := []*networking.NetworkPolicy{}
+	ps.adminPolicies = nil
 	if !stopProcessing(ps.stopOnError, errs) {
 		policies = ps.synthNetpols(resources, connections)
+		ps.adminPolicies = ps.synthAdminPolicies(resources)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// AuthorizationPoliciesFromInfos returns a slice of Istio AuthorizationPolicies that allow only the connections discovered
+// while processing K8s resources in the given slice of Info objects.
+func (ps *PoliciesSynthesizer) AuthorizationPoliciesFromInfos(infos []*resource.Info) ([]*AuthorizationPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromInfos(infos)
+	policies := []*AuthorizationPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthAuthzPolicies(resources, connections)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// AuthorizationPoliciesFromFolderPath returns a slice of Istio AuthorizationPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directory or one of its subdirectories (recursively).
+func (ps *PoliciesSynthesizer) AuthorizationPoliciesFromFolderPath(dirPath string) ([]*AuthorizationPolicy, error) {
+	return ps.AuthorizationPoliciesFromFolderPaths([]string{dirPath})
+}
+
+// AuthorizationPoliciesFromFolderPaths returns a slice of Istio AuthorizationPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directories or one of their subdirectories (recursively).
+func (ps *PoliciesSynthesizer) AuthorizationPoliciesFromFolderPaths(dirPaths []string) ([]*AuthorizationPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromFolderPaths(dirPaths)
+	policies := []*AuthorizationPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthAuthzPolicies(resources, connections)
 	}
 
 	ps.errors = errs
@@ -153,6 +392,135 @@ func (ps *PoliciesSynthesizer) PoliciesFromFolderPaths(dirPaths []string) ([]*ne
 	return policies, nil
 }
 
+// CiliumPoliciesFromInfos returns a slice of CiliumNetworkPolicies that allow only the connections discovered
+// while processing K8s resources provided as a slice of Info objects.
+func (ps *PoliciesSynthesizer) CiliumPoliciesFromInfos(infos []*resource.Info) ([]*CiliumNetworkPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromInfos(infos)
+	policies := []*CiliumNetworkPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthCiliumPolicies(resources, connections)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// CiliumPoliciesFromFolderPath returns a slice of CiliumNetworkPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directory or one of its subdirectories (recursively).
+func (ps *PoliciesSynthesizer) CiliumPoliciesFromFolderPath(dirPath string) ([]*CiliumNetworkPolicy, error) {
+	return ps.CiliumPoliciesFromFolderPaths([]string{dirPath})
+}
+
+// CiliumPoliciesFromFolderPaths returns a slice of CiliumNetworkPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directories or one of their subdirectories (recursively).
+func (ps *PoliciesSynthesizer) CiliumPoliciesFromFolderPaths(dirPaths []string) ([]*CiliumNetworkPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromFolderPaths(dirPaths)
+	policies := []*CiliumNetworkPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthCiliumPolicies(resources, connections)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// CalicoPoliciesFromInfos returns a slice of Calico NetworkPolicies that allow only the connections discovered
+// while processing K8s resources provided as a slice of Info objects, followed by a Calico GlobalNetworkPolicy
+// which denies all other traffic in the workload namespaces.
+func (ps *PoliciesSynthesizer) CalicoPoliciesFromInfos(infos []*resource.Info) ([]*CalicoPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromInfos(infos)
+	policies := []*CalicoPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthCalicoPolicies(resources, connections)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// CalicoPoliciesFromFolderPath returns a slice of Calico NetworkPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directory or one of its subdirectories (recursively),
+// followed by a Calico GlobalNetworkPolicy which denies all other traffic in the workload namespaces.
+func (ps *PoliciesSynthesizer) CalicoPoliciesFromFolderPath(dirPath string) ([]*CalicoPolicy, error) {
+	return ps.CalicoPoliciesFromFolderPaths([]string{dirPath})
+}
+
+// CalicoPoliciesFromFolderPaths returns a slice of Calico NetworkPolicies that allow only the connections discovered
+// while processing K8s resources under the provided directories or one of their subdirectories (recursively),
+// followed by a Calico GlobalNetworkPolicy which denies all other traffic in the workload namespaces.
+func (ps *PoliciesSynthesizer) CalicoPoliciesFromFolderPaths(dirPaths []string) ([]*CalicoPolicy, error) {
+	resources, connections, errs := ps.extractConnectionsFromFolderPaths(dirPaths)
+	policies := []*CalicoPolicy{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		policies = ps.synthCalicoPolicies(resources, connections)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// EgressFirewallsFromInfos returns a slice of EgressFirewalls - one for each workload namespace - that allow egress only
+// to the external destinations discovered while processing K8s resources provided as a slice of Info objects.
+func (ps *PoliciesSynthesizer) EgressFirewallsFromInfos(infos []*resource.Info) ([]*EgressFirewall, error) {
+	resources, _, errs := ps.extractConnectionsFromInfos(infos)
+	firewalls := []*EgressFirewall{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		var warnings []FileProcessingError
+		firewalls, warnings = synthEgressFirewalls(resources, ps.logger)
+		errs = append(errs, warnings...)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return firewalls, nil
+}
+
+// EgressFirewallsFromFolderPath returns a slice of EgressFirewalls - one for each workload namespace - that allow egress
+// only to the external destinations discovered while processing K8s resources under the provided directory
+// or one of its subdirectories (recursively).
+func (ps *PoliciesSynthesizer) EgressFirewallsFromFolderPath(dirPath string) ([]*EgressFirewall, error) {
+	return ps.EgressFirewallsFromFolderPaths([]string{dirPath})
+}
+
+// EgressFirewallsFromFolderPaths returns a slice of EgressFirewalls - one for each workload namespace - that allow egress
+// only to the external destinations discovered while processing K8s resources under the provided directories
+// or one of their subdirectories (recursively).
+func (ps *PoliciesSynthesizer) EgressFirewallsFromFolderPaths(dirPaths []string) ([]*EgressFirewall, error) {
+	resources, _, errs := ps.extractConnectionsFromFolderPaths(dirPaths)
+	firewalls := []*EgressFirewall{}
+	if !stopProcessing(ps.stopOnError, errs) {
+		var warnings []FileProcessingError
+		firewalls, warnings = synthEgressFirewalls(resources, ps.logger)
+		errs = append(errs, warnings...)
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return firewalls, nil
+}
+
 // ConnectionsFromInfos returns a slice of Connections, listing the connections discovered
 // while processing the K8s resources provided as a slice of Info objects.
 func (ps *PoliciesSynthesizer) ConnectionsFromInfos(infos []*resource.Info) ([]*Connections, error) {
@@ -185,8 +553,7 @@ func (ps *PoliciesSynthesizer) ConnectionsFromFolderPaths(dirPaths []string) ([]
 
 func (ps *PoliciesSynthesizer) extractConnectionsFromInfos(infos []*resource.Info) (
 	[]*Resource, []*Connections, []FileProcessingError) {
-	resAcc := newResourceAccumulator(ps.logger, ps.stopOnError)
-	parseErrors := resAcc.parseInfos(infos)
+	resAcc, parseErrors := ps.accumulateResourcesFromInfos(infos)
 	if stopProcessing(ps.stopOnError, parseErrors) {
 		return nil, nil, parseErrors
 	}
@@ -196,20 +563,16 @@ func (ps *PoliciesSynthesizer) extractConnectionsFromInfos(infos []*resource.Inf
 	return wls, conns, errs
 }
 
+func (ps *PoliciesSynthesizer) accumulateResourcesFromInfos(infos []*resource.Info) (*resourceAccumulator, []FileProcessingError) {
+	resAcc := newResourceAccumulator(ps.logger, ps.stopOnError, ps.extractors)
+	parseErrors := resAcc.parseInfos(infos)
+	return resAcc, parseErrors
+}
+
 // Scans the given directories for YAMLs with k8s resources and extracts required connections between workloads
 func (ps *PoliciesSynthesizer) extractConnectionsFromFolderPaths(dirPaths []string) (
 	[]*Resource, []*Connections, []FileProcessingError) {
-	// Find all manifest YAML files
-	mf := manifestFinder{ps.logger, ps.stopOnError, ps.walkFn}
-	manifestFiles, fileErrors := mf.searchForManifestsInDirs(dirPaths)
-	if stopProcessing(ps.stopOnError, fileErrors) {
-		return nil, nil, fileErrors
-	}
-
-	// Parse YAMLs and extract relevant resources
-	resAcc := newResourceAccumulator(ps.logger, ps.stopOnError)
-	parseErrors := resAcc.parseK8sYamls(manifestFiles)
-	fileErrors = append(fileErrors, parseErrors...)
+	resAcc, fileErrors := ps.accumulateResourcesFromFolderPaths(dirPaths)
 	if stopProcessing(ps.stopOnError, fileErrors) {
 		return nil, nil, fileErrors
 	}
@@ -220,6 +583,21 @@ func (ps *PoliciesSynthesizer) extractConnectionsFromFolderPaths(dirPaths []stri
 	return wls, conns, fileErrors
 }
 
+// Scans the given directories for YAMLs with k8s resources and accumulates the relevant resources
+func (ps *PoliciesSynthesizer) accumulateResourcesFromFolderPaths(dirPaths []string) (*resourceAccumulator, []FileProcessingError) {
+	// Find all manifest YAML files
+	mf := manifestFinder{ps.logger, ps.stopOnError, ps.walkFn}
+	manifestFiles, fileErrors := mf.searchForManifestsInDirs(dirPaths)
+	if stopProcessing(ps.stopOnError, fileErrors) {
+		return nil, fileErrors
+	}
+
+	// Parse YAMLs and extract relevant resources
+	resAcc := newResourceAccumulator(ps.logger, ps.stopOnError, ps.extractors)
+	parseErrors := resAcc.parseK8sYamls(manifestFiles)
+	return resAcc, append(fileErrors, parseErrors...)
+}
+
 func (ps *PoliciesSynthesizer) extractConnections(resAcc *resourceAccumulator) (
 	[]*Resource, []*Connections, []FileProcessingError) {
 	if len(resAcc.workloads) == 0 {
@@ -232,10 +610,15 @@ func (ps *PoliciesSynthesizer) extractConnections(resAcc *resourceAccumulator) (
 		return nil, nil, fileErrors
 	}
 
-	resAcc.exposeServices()
+	fileErrors = append(fileErrors, resAcc.checkHostNetworkWorkloads()...)
+	fileErrors = append(fileErrors, resAcc.exposeGrantedBackends()...)
+	resAcc.applyIstioRouting()
+	resAcc.inferExternalDestinations()
+	resAcc.exposeServices(&ps.ingressSources)
 
 	// Discover all connections between resources
-	connections := discoverConnections(resAcc.workloads, resAcc.services, ps.logger)
+	connections, warnings := discoverConnections(resAcc.workloads, resAcc.services, ps.logger)
+	fileErrors = append(fileErrors, warnings...)
 	return resAcc.workloads, connections, fileErrors
 }
 
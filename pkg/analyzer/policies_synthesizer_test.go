@@ -13,12 +13,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/cli-runtime/pkg/resource"
 
 	"github.com/np-guard/netpol-analyzer/pkg/manifests/fsscanner"
@@ -151,6 +156,1050 @@ func TestPoliciesSynthesizerAPIDnsNamedPort(t *testing.T) {
 	}
 }
 
+func TestPoliciesSynthesizerAPIProbeSourceCIDRs(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "probes_and_host_network")
+	synthesizer := NewPoliciesSynthesizer(WithProbeSourceCIDRs([]string{"10.0.0.0/16"}))
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, synthesizer.Errors(), 1)
+	hostNetwork := &HostNetworkWorkloadError{}
+	require.True(t, errors.As(synthesizer.Errors()[0].Error(), &hostNetwork))
+
+	require.Len(t, netpols, 3) // backend, node-agent and namespace default deny
+	backendNetpol := netpols[0]
+	require.Equal(t, "backend-netpol", backendNetpol.Name)
+	require.Len(t, backendNetpol.Spec.Ingress, 2)
+	probeRule := backendNetpol.Spec.Ingress[1]
+	require.Len(t, probeRule.From, 1)
+	require.Equal(t, "10.0.0.0/16", probeRule.From[0].IPBlock.CIDR)
+	require.Len(t, probeRule.Ports, 3)
+
+	// invalid CIDRs are reported and left out of the policies
+	synthesizer = NewPoliciesSynthesizer(WithProbeSourceCIDRs([]string{"10.0.0.0/33", "10.0.0.0/16", "node-net"}))
+	netpols, err = synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, synthesizer.Errors(), 3) // two invalid CIDRs, followed by the host-network warning
+	invalidOption := &InvalidOptionError{}
+	require.True(t, errors.As(synthesizer.Errors()[0].Error(), &invalidOption))
+	require.Contains(t, invalidOption.Error(), "10.0.0.0/33")
+	require.Equal(t, []network.NetworkPolicyPeer{{IPBlock: &network.IPBlock{CIDR: "10.0.0.0/16"}}}, netpols[0].Spec.Ingress[1].From)
+}
+
+func TestPoliciesSynthesizerAPICustomExtractors(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "custom_crds")
+	webAppGVK := schema.GroupVersionKind{Group: "platform.example.com", Version: "v1", Kind: "WebApp"}
+	webAppExtractor := func(obj *unstructured.Unstructured) (*core.PodTemplateSpec, error) {
+		return podTemplateFromUnstructured(obj, "spec", "runtime", "podTemplate")
+	}
+	endpointGVK := schema.GroupVersionKind{Group: "platform.example.com", Version: "v1", Kind: "PublicEndpoint"}
+	endpointExtractor := func(obj *unstructured.Unstructured) ([]ExposedServicePort, error) {
+		svcName, _, _ := unstructured.NestedString(obj.Object, "spec", "service")
+		port, _, _ := unstructured.NestedInt64(obj.Object, "spec", "port")
+		return []ExposedServicePort{{Namespace: obj.GetNamespace(), ServiceName: svcName, Port: intstr.FromInt(int(port))}}, nil
+	}
+
+	synthesizer := NewPoliciesSynthesizer()
+	_, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.NotNil(t, err) // without custom extractors, no workloads are found
+
+	synthesizer = NewPoliciesSynthesizer(WithWorkloadExtractor(webAppGVK, webAppExtractor),
+		WithExposureExtractor(endpointGVK, endpointExtractor))
+	conns, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.Nil(t, err)
+	require.Empty(t, synthesizer.Errors())
+	require.Len(t, conns, 2) // catalog->catalog-db and exposed catalog
+	for _, conn := range conns {
+		require.Equal(t, "WebApp", conn.Target.Resource.Kind)
+		if conn.Source == nil {
+			require.Equal(t, "catalog", conn.Target.Resource.Name)
+		}
+	}
+}
+
+func TestPoliciesSynthesizerAPIKnativeServices(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "rollouts_knative_dc")
+	synthesizer := NewPoliciesSynthesizer()
+	conns, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	idx := slices.IndexFunc(conns, func(conn *Connections) bool { return conn.Target.Resource.Name == "pricing" })
+	require.GreaterOrEqual(t, idx, 0)
+	require.Equal(t, knativeService, conns[idx].Target.Resource.Kind) // not to be confused with the K8s Service of the same name
+	require.Equal(t, "KnativeService shop/pricing", workloadIdentity(conns[idx].Target))
+
+	explanation, err := synthesizer.ExplainWorkloadFromFolderPath(dirPath, "shop", "KnativeService", "pricing")
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, explanation.Services, 1)
+	_, err = synthesizer.ExplainWorkloadFromFolderPath(dirPath, "shop", "Service", "pricing")
+	notFound := &WorkloadNotFoundError{}
+	require.True(t, errors.As(err, &notFound))
+
+	// replacing the extractor of Knative Services keeps their kind and their implied Service
+	knativeGVK := knativeServingGroupVersion.WithKind(service)
+	extracted := false
+	extractor := func(obj *unstructured.Unstructured) (*core.PodTemplateSpec, error) {
+		extracted = true
+		return knativeServiceTemplate(obj)
+	}
+	synthesizer = NewPoliciesSynthesizer(WithWorkloadExtractor(knativeGVK, extractor))
+	conns, err = synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.True(t, extracted)
+	idx = slices.IndexFunc(conns, func(conn *Connections) bool { return conn.Target.Resource.Name == "pricing" })
+	require.GreaterOrEqual(t, idx, 0)
+	require.Equal(t, knativeService, conns[idx].Target.Resource.Kind)
+	require.Equal(t, "pricing", conns[idx].Link.Resource.Name) // the implied Service
+}
+
+func TestPoliciesSynthesizerAPIGatewayL4Routes(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "gateway_l4_routes")
+	synthesizer := NewPoliciesSynthesizer()
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Empty(t, synthesizer.Errors())
+
+	expectedIngress := map[string]core.Protocol{"dns-netpol": core.ProtocolUDP, "postgres-netpol": core.ProtocolTCP,
+		"web-netpol": core.ProtocolTCP}
+	for _, netpol := range netpols {
+		protocol, ok := expectedIngress[netpol.Name]
+		if !ok {
+			continue
+		}
+		delete(expectedIngress, netpol.Name)
+		require.Len(t, netpol.Spec.Ingress, 1)
+		rule := netpol.Spec.Ingress[0]
+		require.Len(t, rule.From, 1)
+		require.NotNil(t, rule.From[0].NamespaceSelector) // exposed to the whole cluster
+		require.Len(t, rule.Ports, 1)                     // UDPRoute only exposes the UDP port of the dns service
+		require.Equal(t, protocol, *rule.Ports[0].Protocol)
+	}
+	require.Empty(t, expectedIngress)
+}
+
+func TestPoliciesSynthesizerAPIIngressSources(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "ingress_sources")
+	nginx := IngressSource{Namespace: "ingress-nginx", PodLabels: map[string]string{"app.kubernetes.io/name": "ingress-nginx"}}
+	envoy := IngressSource{Namespace: "envoy-gateway-system", PodLabels: map[string]string{"app.kubernetes.io/name": "envoy"}}
+	synthesizer := NewPoliciesSynthesizer(WithIngressSource(nginx), WithGatewayClassIngressSource("eg", envoy), WithProvenanceAnnotations())
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Empty(t, synthesizer.Errors())
+
+	expectedSources := map[string]IngressSource{
+		"storefront-netpol": nginx, // exposed by an Ingress
+		"checkout-netpol": { // exposed via an Istio Gateway - pods are inferred from the Gateway
+			Namespace: "shop",
+			PodLabels: map[string]string{gatewayNameLabel: "mesh-gateway"},
+		},
+		"ledger-netpol": envoy, // exposed via a Gateway of an explicitly configured class
+	}
+	for _, netpol := range netpols {
+		source, ok := expectedSources[netpol.Name]
+		if !ok {
+			continue
+		}
+		delete(expectedSources, netpol.Name)
+		require.Len(t, netpol.Spec.Ingress, 1)
+		require.Equal(t, []network.NetworkPolicyPeer{source.netpolPeer()}, netpol.Spec.Ingress[0].From)
+		if netpol.Name == "storefront-netpol" { // the reason names the exposing Ingress and the ingress pods
+			reason := netpol.Annotations["np-guard.io/ingress-rule-0"]
+			require.Contains(t, reason, "is exposed by Ingress shop/storefront (")
+			require.Contains(t, reason, "through the ingress pods labeled app.kubernetes.io/name=ingress-nginx in namespace ingress-nginx")
+		}
+	}
+	require.Empty(t, expectedSources)
+
+	svc := &Service{}
+	svc.Resource.Name = "web"
+	allNamespaces := IngressSource{PodLabels: map[string]string{"app": "router"}}
+	require.Equal(t, "Service default/web () is exposed through the ingress pods labeled app=router in all namespaces",
+		exposureReason(svc, &allNamespaces, nil))
+}
+
+func TestPoliciesSynthesizerAPIReferenceGrants(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "reference_grants")
+	synthesizer := NewPoliciesSynthesizer()
+	conns, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	errs := synthesizer.Errors()
+	require.Len(t, errs, 2) // the admin and the reports backends are not granted
+	for i := range errs {
+		notGranted := &ReferenceNotGrantedError{}
+		require.True(t, errors.As(errs[i].Error(), &notGranted))
+	}
+	require.Contains(t, errs[0].Error().Error(), "api/admin")
+	require.Contains(t, errs[1].Error().Error(), "reports/reports")
+
+	exposed := map[string]bool{}
+	for _, conn := range conns {
+		exposed[conn.Target.Resource.Name] = svcHasExposedPorts(conn.Link)
+	}
+	require.Equal(t, map[string]bool{"api": true, "admin": false, "reports": false}, exposed)
+}
+
+func TestPoliciesSynthesizerAPIIstioMesh(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "istio_mesh")
+	synthesizer := NewPoliciesSynthesizer()
+	conns, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Empty(t, synthesizer.Errors())
+
+	for _, conn := range conns {
+		switch conn.Target.Resource.Name {
+		case "reviews-v1": // not in the subset to which the VirtualService routes
+			require.Nil(t, conn.Source)
+		case "reviews-v2": // reached through the VirtualService's custom host
+			require.NotNil(t, conn.Source)
+			require.Equal(t, "productpage", conn.Source.Resource.Name)
+			// the host of the MESH_INTERNAL ServiceEntry (ratings.bookstore.mesh) is not an external destination
+			require.Equal(t, []ExternalDestination{{"legacy-db.corp.internal", []string{"10.20.0.0/24", "10.20.0.17/32"}, 5432, core.ProtocolTCP}},
+				conn.Target.Resource.ExternalDestinations)
+			require.Empty(t, conn.Target.Resource.InferredExternalDestinations)
+		case "productpage": // exposed through an Istio Gateway
+			require.Nil(t, conn.Source)
+			require.True(t, svcHasExposedPorts(conn.Link))
+			// matched by two ServiceEntries, but recorded once
+			require.Equal(t, []ExternalDestination{{"api.payments.example.com", []string{}, 443, core.ProtocolTCP}},
+				conn.Target.Resource.ExternalDestinations)
+		}
+	}
+}
+
+func TestVirtualServiceAliasHosts(t *testing.T) {
+	vs := &istioVirtualService{}
+	vs.Namespace = "shop"
+	vs.Spec.Hosts = []string{"bookinfo.com", "reviews", "reviews.shop", "ratings.stock.svc", "details.stock.svc.cluster.local",
+		"*.shop.example"}
+	// "bookinfo.com" is not a Service host, as "com" is not a scanned namespace
+	require.Equal(t, []string{"bookinfo.com"}, vs.aliasHosts(map[string]bool{"shop": true}))
+}
+
+func TestPoliciesSynthesizerAPIAuthorizationPolicies(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "bookinfo")
+	synthesizer := NewPoliciesSynthesizer(WithIstioTrustDomain("example.org"))
+	policies, err := synthesizer.AuthorizationPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.NotEmpty(t, policies)
+
+	var mongoPolicy *AuthorizationPolicy
+	for _, policy := range policies {
+		require.Equal(t, "AuthorizationPolicy", policy.Kind)
+		if policy.Name == "mongodb-v1-authz" {
+			mongoPolicy = policy
+		}
+	}
+	require.NotNil(t, mongoPolicy)
+	require.Equal(t, "ALLOW", mongoPolicy.Spec.Action)
+	require.Equal(t, map[string]string{"app": "mongodb", "version": "v1"}, mongoPolicy.Spec.Selector.MatchLabels)
+	require.Len(t, mongoPolicy.Spec.Rules, 1)
+	rule := mongoPolicy.Spec.Rules[0]
+	require.Equal(t, []string{"example.org/ns/default/sa/bookinfo-ratings-v2"}, rule.From[0].Source.Principals)
+	require.Equal(t, []string{"27017"}, rule.To[0].Operation.Ports)
+
+	lastPolicy := policies[len(policies)-1]
+	require.Equal(t, "allow-nothing-in-namespace", lastPolicy.Name)
+	require.Equal(t, AuthorizationPolicySpec{}, lastPolicy.Spec)
+}
+
+func TestPoliciesSynthesizerAPIAuthorizationPoliciesIngressSource(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "ingress_sources")
+	controllerDirPath := filepath.Join(getTestsDir(), "ingress_controller")
+	ingressSource := IngressSource{Namespace: "ingress-nginx", PodLabels: map[string]string{"app.kubernetes.io/name": "ingress-nginx"}}
+	synthesizer := NewPoliciesSynthesizer(WithIngressSource(ingressSource))
+	storefrontRule := func(policies []*AuthorizationPolicy) AuthorizationRule {
+		idx := slices.IndexFunc(policies, func(policy *AuthorizationPolicy) bool { return policy.Name == "storefront-authz" })
+		require.GreaterOrEqual(t, idx, 0)
+		require.Len(t, policies[idx].Spec.Rules, 1)
+		return policies[idx].Spec.Rules[0]
+	}
+
+	// the ingress-controller pods are not scanned, so requests are allowed from their whole namespace
+	policies, err := synthesizer.AuthorizationPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, []AuthorizationRuleFrom{{Source: AuthorizationSource{Namespaces: []string{"ingress-nginx"}}}},
+		storefrontRule(policies).From)
+
+	// the ingress-controller pods are scanned, so requests are only allowed from their ServiceAccount
+	policies, err = synthesizer.AuthorizationPoliciesFromFolderPaths([]string{dirPath, controllerDirPath})
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	controllerPrincipal := "cluster.local/ns/ingress-nginx/sa/ingress-nginx"
+	require.Equal(t, []AuthorizationRuleFrom{{Source: AuthorizationSource{Principals: []string{controllerPrincipal}}}},
+		storefrontRule(policies).From)
+}
+
+func TestAuthzRulePorts(t *testing.T) {
+	target := &Resource{}
+	target.Resource.ContainerPorts = []ContainerPortAttr{{Name: "http", Port: 8080}, {Name: "dns", Port: 5353, Protocol: core.ProtocolUDP}}
+	ports := []SvcNetworkAttr{
+		{Port: 80, TargetPort: intstr.FromString("http")},
+		{Port: 53, TargetPort: intstr.FromString("dns"), Protocol: core.ProtocolUDP}, // Istio does not authorize UDP traffic
+		{Port: 9090, TargetPort: intstr.FromString("metrics")},                       // cannot be resolved to a port number
+	}
+	to, ok := toAuthzRuleTo(toNetpolPorts(ports, target, false))
+	require.True(t, ok)
+	require.Equal(t, []AuthorizationRuleTo{{Operation: AuthorizationOperation{Ports: []string{"8080"}}}}, to)
+
+	_, ok = toAuthzRuleTo(toNetpolPorts(ports[1:], target, false))
+	require.False(t, ok) // no port can be authorized, so no requests should be allowed
+
+	to, ok = toAuthzRuleTo(nil)
+	require.True(t, ok)
+	require.Nil(t, to) // all ports
+}
+
+func TestPoliciesSynthesizerAPICiliumPolicies(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "cilium_l7")
+	synthesizer := NewPoliciesSynthesizer()
+	policies, err := synthesizer.CiliumPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, policies, 3)
+
+	catalogPolicy, webPolicy, denyPolicy := policies[0], policies[1], policies[2]
+	require.Equal(t, "CiliumNetworkPolicy", webPolicy.Kind)
+	require.Equal(t, "web-netpol", webPolicy.Name)
+	require.Len(t, webPolicy.Spec.Ingress, 1)
+	require.Equal(t, []string{"cluster"}, webPolicy.Spec.Ingress[0].FromEntities) // no ingress source is configured
+	require.Equal(t, &CiliumL7Rules{HTTP: []CiliumHTTPRule{{`/api(/.*)?`}, {`/healthz`}}}, webPolicy.Spec.Ingress[0].ToPorts[0].Rules)
+
+	require.Len(t, webPolicy.Spec.Egress, 3)
+	require.Equal(t, map[string]string{"app": "catalog", ciliumNamespaceLabel: "shop"}, webPolicy.Spec.Egress[0].ToEndpoints[0].MatchLabels)
+	require.Equal(t, []CiliumFQDNSelector{{MatchName: "api.payments.example.com"}}, webPolicy.Spec.Egress[1].ToFQDNs)
+	dnsRule := webPolicy.Spec.Egress[2] // FQDN rules require DNS visibility
+	require.Equal(t, []CiliumDNSRule{{"*"}}, dnsRule.ToPorts[0].Rules.DNS)
+
+	require.Len(t, catalogPolicy.Spec.Ingress, 2)
+	require.Equal(t, &CiliumL7Rules{HTTP: []CiliumHTTPRule{{`/catalog(/.*)?`}, {`/items/[0-9]+`}}},
+		catalogPolicy.Spec.Ingress[1].ToPorts[0].Rules)
+	require.Empty(t, catalogPolicy.Spec.Egress)
+
+	require.Equal(t, "default-deny-in-namespace-shop", denyPolicy.Name)
+	require.Equal(t, []CiliumIngressRule{{}}, denyPolicy.Spec.Ingress)
+	require.Equal(t, []CiliumEgressRule{{}}, denyPolicy.Spec.Egress)
+}
+
+func TestCiliumPortRules(t *testing.T) {
+	httpPort, dnsPort, udp := intstr.FromInt(8080), intstr.FromInt(53), core.ProtocolUDP
+	ports := []network.NetworkPolicyPort{{Port: &httpPort}, {Port: &dnsPort, Protocol: &udp}}
+	rules := toCiliumPortRules(ports, []string{"/api"})
+	require.Equal(t, []CiliumPortRule{ // HTTP rules are kept for the TCP port
+		{Ports: []CiliumPortProtocol{{"8080", "TCP"}}, Rules: &CiliumL7Rules{HTTP: []CiliumHTTPRule{{"/api"}}}},
+		{Ports: []CiliumPortProtocol{{"53", "UDP"}}},
+	}, rules)
+
+	rules = toCiliumPortRules([]network.NetworkPolicyPort{{}}, []string{"/api"})
+	require.Equal(t, []CiliumPortRule{{Ports: []CiliumPortProtocol{{"0", "TCP"}}}}, rules) // all ports
+}
+
+func TestPoliciesSynthesizerAPICiliumPoliciesWithoutIstio(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "cilium_l7")
+	infos, errs := fsscanner.GetResourceInfosFromDirPath([]string{dirPath}, true, false)
+	require.Empty(t, errs)
+	infos = slices.DeleteFunc(infos, func(info *resource.Info) bool {
+		return info.Object.GetObjectKind().GroupVersionKind().Kind == "ServiceEntry"
+	})
+
+	synthesizer := NewPoliciesSynthesizer()
+	policies, err := synthesizer.CiliumPoliciesFromInfos(infos)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	webPolicy := policies[1]
+	require.Equal(t, "web-netpol", webPolicy.Name)
+	require.Len(t, webPolicy.Spec.Egress, 3) // the external host is inferred from web's manifest (and allowed on the port of its URL)
+	require.Equal(t, []CiliumFQDNSelector{{MatchName: "api.payments.example.com"}}, webPolicy.Spec.Egress[1].ToFQDNs)
+	require.Equal(t, []CiliumPortRule{{Ports: []CiliumPortProtocol{{"443", "TCP"}}}}, webPolicy.Spec.Egress[1].ToPorts)
+}
+
+func TestPoliciesSynthesizerAPICiliumDNSVisibility(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "k8s_guestbook")
+	synthesizer := NewPoliciesSynthesizer(WithCiliumDNSVisibility(), WithDNSPort(5353))
+	policies, err := synthesizer.CiliumPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	dnsRules := 0
+	for _, policy := range policies {
+		if len(policy.Spec.Egress) == 0 || policy.Spec.Egress[0].ToPorts == nil {
+			continue // no egress rules, or a default-deny policy
+		}
+		dnsRule := policy.Spec.Egress[len(policy.Spec.Egress)-1]
+		require.Equal(t, []CiliumPortProtocol{{"5353", "ANY"}}, dnsRule.ToPorts[0].Ports)
+		require.Equal(t, "kube-dns", dnsRule.ToEndpoints[0].MatchLabels["k8s:k8s-app"])
+		dnsRules++
+	}
+	require.Positive(t, dnsRules)
+}
+
+func TestPoliciesSynthesizerAPICalicoPolicies(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "istio_mesh")
+	synthesizer := NewPoliciesSynthesizer()
+	policies, err := synthesizer.CalicoPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, policies, 4)
+
+	productPolicy := policies[0]
+	require.Equal(t, "NetworkPolicy", productPolicy.Kind)
+	require.Equal(t, "productpage-netpol", productPolicy.Name)
+	require.Equal(t, "app == 'productpage'", productPolicy.Spec.Selector)
+	require.Less(t, productPolicy.Spec.Order, policies[3].Spec.Order)
+	require.Len(t, productPolicy.Spec.Egress, 3)
+	reviewsRule := productPolicy.Spec.Egress[0]
+	require.Equal(t, "TCP", reviewsRule.Protocol)
+	require.Equal(t, "app == 'reviews' && version == 'v2'", reviewsRule.Destination.Selector)
+	require.Equal(t, []intstr.IntOrString{intstr.FromInt(9080)}, reviewsRule.Destination.Ports)
+	dnsRule := productPolicy.Spec.Egress[2]
+	require.Equal(t, CalicoRule{Action: "Allow", Protocol: "UDP",
+		Destination: &CalicoEntityRule{NamespaceSelector: "all()", Ports: []intstr.IntOrString{intstr.FromInt(DefaultDNSPort)}}}, dnsRule)
+
+	baseline := policies[3]
+	require.Equal(t, "GlobalNetworkPolicy", baseline.Kind)
+	require.Empty(t, baseline.Namespace)
+	require.Equal(t, "projectcalico.org/namespace in {'bookstore'}", baseline.Spec.Selector)
+	require.Equal(t, []CalicoRule{{Action: "Deny"}}, baseline.Spec.Ingress)
+	require.Equal(t, []CalicoRule{{Action: "Deny"}}, baseline.Spec.Egress)
+
+	synthesizer = NewPoliciesSynthesizer(WithCalicoPolicyOrders(500, 900))
+	policies, err = synthesizer.CalicoPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, 500.0, policies[0].Spec.Order)
+	require.Equal(t, 900.0, policies[3].Spec.Order)
+
+	// a baseline order which is not higher than the workload order would deny all traffic, so the default orders are used
+	synthesizer = NewPoliciesSynthesizer(WithCalicoPolicyOrders(900, 900))
+	policies, err = synthesizer.CalicoPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, 100.0, policies[0].Spec.Order)
+	require.Equal(t, 10000.0, policies[3].Spec.Order)
+	require.Len(t, synthesizer.Errors(), 1)
+	invalidOption := &InvalidOptionError{}
+	require.True(t, errors.As(synthesizer.Errors()[0].Error(), &invalidOption))
+	require.Contains(t, invalidOption.Error(), "WithCalicoPolicyOrders")
+	require.True(t, synthesizer.Errors()[0].IsSevere())
+}
+
+func TestPoliciesSynthesizerAPIAdminPolicies(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "k8s_guestbook")
+	synthesizer := NewPoliciesSynthesizer()
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Nil(t, synthesizer.AdminPolicies())
+	numNetpols := len(netpols)
+
+	synthesizer = NewPoliciesSynthesizer(WithBaselineAdminNetworkPolicy(), WithAdminNetworkPolicyAllowingDNS(),
+		WithAdminNetworkPolicyAllowingMonitoring("monitoring"), WithDNSNamedPort("dns"))
+	netpols, err = synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, netpols, numNetpols-2) // no default-deny NetworkPolicies for the default and the redis namespaces
+	for _, netpol := range netpols {
+		require.NotContains(t, netpol.Name, "default-deny")
+	}
+
+	adminPolicies := synthesizer.AdminPolicies()
+	require.Len(t, adminPolicies, 3)
+	dnsPolicy, monitoringPolicy, baseline := adminPolicies[0], adminPolicies[1], adminPolicies[2]
+	require.Equal(t, "AdminNetworkPolicy", dnsPolicy.Kind)
+	require.Equal(t, []AdminPolicyPort{{NamedPort: "dns"}}, dnsPolicy.Spec.Egress[0].Ports)
+	require.Equal(t, "monitoring", monitoringPolicy.Spec.Ingress[0].From[0].Namespaces.MatchLabels[namespaceNameLabel])
+	require.Less(t, *dnsPolicy.Spec.Priority, *monitoringPolicy.Spec.Priority)
+
+	require.Equal(t, "BaselineAdminNetworkPolicy", baseline.Kind)
+	require.Equal(t, "default", baseline.Name)
+	require.Nil(t, baseline.Spec.Priority)
+	require.Equal(t, []string{"default", "redis"}, baseline.Spec.Subject.Namespaces.MatchExpressions[0].Values)
+	require.Equal(t, "Deny", baseline.Spec.Ingress[0].Action)
+	require.Equal(t, "Deny", baseline.Spec.Egress[0].Action)
+}
+
+func TestPoliciesSynthesizerAPIEgressFirewalls(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "cilium_l7")
+	synthesizer := NewPoliciesSynthesizer()
+	firewalls, err := synthesizer.EgressFirewallsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, firewalls, 1)
+
+	firewall := firewalls[0]
+	require.Equal(t, "EgressFirewall", firewall.Kind)
+	require.Equal(t, "default", firewall.Name)
+	require.Equal(t, "shop", firewall.Namespace)
+	require.Equal(t, []EgressFirewallRule{
+		{Type: "Allow", To: EgressFirewallDestination{DNSName: "api.payments.example.com"}, Ports: []EgressFirewallPort{{"TCP", 443}}},
+		{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "0.0.0.0/0"}},
+		{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "::/0"}},
+	}, firewall.Spec.Egress)
+
+	firewall.Spec.Egress = append([]EgressFirewallRule{
+		{Type: "Allow", To: EgressFirewallDestination{DNSName: "*.example.com"}},
+		{Type: "Allow", To: EgressFirewallDestination{CIDRSelector: "2001:db8::/64"}},
+	}, firewall.Spec.Egress...)
+	sdnPolicies := EgressNetworkPolicyListFromEgressFirewalls(firewalls)
+	require.Len(t, sdnPolicies.Items, 1)
+	sdnRules := sdnPolicies.Items[0].Spec.Egress
+	require.Len(t, sdnRules, 2) // the wildcard DNS name and the IPv6 CIDRs are not supported
+	require.Equal(t, "api.payments.example.com", sdnRules[0].To.DNSName)
+	require.Equal(t, "0.0.0.0/0", sdnRules[1].To.CIDRSelector)
+}
+
+func TestPoliciesSynthesizerAPIEgressFirewallsWithoutIstio(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "egress_firewalls")
+	synthesizer := NewPoliciesSynthesizer()
+	firewalls, err := synthesizer.EgressFirewallsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, firewalls, 2)
+
+	// addresses of Services (cart, inventory.stock), cluster-local names (audit.shop.svc.cluster.local),
+	// names without a public suffix (legacy.other-ns:8080, 4.13.28) and values which are neither URLs
+	// nor include a port (config.json, com.example.Main) are not external
+	require.Equal(t, "shop", firewalls[0].Namespace)
+	require.Equal(t, []EgressFirewallRule{
+		{Type: "Allow", To: EgressFirewallDestination{DNSName: "api.payments.example.com"}, Ports: []EgressFirewallPort{{"TCP", 443}}},
+		{Type: "Allow", To: EgressFirewallDestination{CIDRSelector: "10.20.0.17/32"}, Ports: []EgressFirewallPort{{"TCP", 1521}}},
+		{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "0.0.0.0/0"}},
+		{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "::/0"}},
+	}, firewalls[0].Spec.Egress)
+
+	require.Equal(t, "stock", firewalls[1].Namespace)
+	require.Len(t, firewalls[1].Spec.Egress, 2) // deny only
+	require.Len(t, synthesizer.Errors(), 1)
+	noExternalDests := &NoExternalDestinationsError{}
+	require.True(t, errors.As(synthesizer.Errors()[0].Error(), &noExternalDests))
+	require.Contains(t, noExternalDests.Error(), "namespace stock")
+}
+
+func TestPoliciesSynthesizerAPIPoliciesWithInferredExternalDestinations(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "egress_firewalls")
+	synthesizer := NewPoliciesSynthesizer()
+	policies, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	idx := slices.IndexFunc(policies, func(policy *network.NetworkPolicy) bool { return policy.Name == "checkout-netpol" })
+	require.GreaterOrEqual(t, idx, 0)
+
+	// the same external destinations as allowed by the shop EgressFirewall, on the ports of their addresses
+	tcp, httpsPort, ledgerPort := core.ProtocolTCP, intstr.FromInt(443), intstr.FromInt(1521)
+	egress := policies[idx].Spec.Egress
+	require.Contains(t, egress, network.NetworkPolicyEgressRule{
+		Ports: []network.NetworkPolicyPort{{Protocol: &tcp, Port: &httpsPort}},
+		To:    []network.NetworkPolicyPeer{{IPBlock: &network.IPBlock{CIDR: "0.0.0.0/0"}}},
+	})
+	require.Contains(t, egress, network.NetworkPolicyEgressRule{
+		Ports: []network.NetworkPolicyPort{{Protocol: &tcp, Port: &ledgerPort}},
+		To:    []network.NetworkPolicyPeer{{IPBlock: &network.IPBlock{CIDR: "10.20.0.17/32"}}},
+	})
+}
+
+func TestExternalDestinationOf(t *testing.T) {
+	namespaces := map[string]bool{"shop": true}
+	for _, tc := range []struct {
+		addr     string
+		scheme   string
+		external bool
+	}{
+		{"api.example.com", "https", true},
+		{"api.example.com:443", "", true},
+		{"api.example.com", "", false},      // neither a URL nor includes a port
+		{"api.example.com", "ftp", false},   // the port is unknown
+		{"api.example.com:http", "", false}, // a named port
+		{"10.0.0.1:5432", "", true},
+		{"10.0.0.1", "", false},
+		{"config.json:80", "", false}, // not a public suffix
+		{"svc.other-ns", "http", false},
+		{"example.com", "https", true},
+		{"com", "https", false}, // a public suffix itself
+		{"cart.shop:80", "", false},
+		{"audit.shop.svc.cluster.local:9000", "", false},
+	} {
+		_, external := externalDestinationOf(tc.addr, tc.scheme, namespaces)
+		require.Equalf(t, tc.external, external, "address %s", tc.addr)
+	}
+	dest, _ := externalDestinationOf("api.example.com", "https", namespaces)
+	require.Equal(t, ExternalDestination{"api.example.com", []string{}, 443, core.ProtocolTCP}, dest)
+}
+
+func TestEgressFirewallsDefaultNamespace(t *testing.T) {
+	noNamespace, defaultNamespace := newWorkload("", "Deployment", "api", nil), newWorkload("default", "Deployment", "db", nil)
+	firewalls, warnings := synthEgressFirewalls([]*Resource{noNamespace, defaultNamespace}, NewDefaultLogger())
+	require.Len(t, firewalls, 1) // a resource with no namespace is in the default namespace
+	require.Equal(t, "default", firewalls[0].Namespace)
+	require.Len(t, warnings, 1) // no external destinations
+}
+
+func TestDenyPoliciesDefaultNamespace(t *testing.T) {
+	noNamespace, defaultNamespace := newWorkload("", "Deployment", "api", nil), newWorkload("default", "Deployment", "db", nil)
+	resources := []*Resource{noNamespace, defaultNamespace}
+	// a resource with no namespace is in the default namespace
+	require.Len(t, getNsDefaultDenyPolicies(resources), 1)
+	require.Len(t, getNsAllowNothingAuthzPolicies(resources), 1)
+	require.Len(t, getNsDefaultDenyCiliumPolicies(resources), 1)
+}
+
+func TestPoliciesSynthesizerAPIMinimalPodSelectors(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "bookinfo")
+	synthesizer := NewPoliciesSynthesizer(WithMinimalPodSelectors())
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	selectors := map[string]map[string]string{}
+	for _, netpol := range netpols {
+		selectors[netpol.Name] = netpol.Spec.PodSelector.MatchLabels
+	}
+	require.Equal(t, map[string]string{"app": "productpage"}, selectors["productpage-v1-netpol"]) // the only productpage workload
+	require.Equal(t, map[string]string{"app": "mongodb"}, selectors["mongodb-v1-netpol"])
+	require.Equal(t, map[string]string{"app": "reviews", "version": "v2"}, selectors["reviews-v2-netpol"]) // app & version both required
+
+	synthesizer = NewPoliciesSynthesizer(WithIgnoredPodLabels([]string{"version"}))
+	netpols, err = synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	for _, netpol := range netpols {
+		selectors[netpol.Name] = netpol.Spec.PodSelector.MatchLabels
+	}
+	require.Equal(t, map[string]string{"app": "productpage"}, selectors["productpage-v1-netpol"])
+	require.Equal(t, map[string]string{"app": "reviews", "version": "v2"}, selectors["reviews-v2-netpol"]) // version is still required
+}
+
+func TestPoliciesSynthesizerAPIMultiTenant(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "multi_tenant")
+	synthesizer := NewPoliciesSynthesizer()
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	netpolsByName := map[string]*network.NetworkPolicy{}
+	for _, netpol := range netpols {
+		name := netpol.Namespace + "/" + netpol.Name
+		require.NotContains(t, netpolsByName, name) // policy names must not collide
+		netpolsByName[name] = netpol
+	}
+	require.Len(t, netpolsByName, 8) // 6 workloads in 2 namespaces + 2 default-deny policies
+	require.Contains(t, netpolsByName, "team-a/frontend-netpol")
+	// the Deployment named frontend-deployment keeps its name, so the frontend Deployment's policy name is further disambiguated
+	require.Equal(t, map[string]string{"app": "frontend-canary"},
+		netpolsByName["team-b/frontend-deployment-netpol"].Spec.PodSelector.MatchLabels)
+	require.Equal(t, map[string]string{"app": "frontend"}, netpolsByName["team-b/frontend-deployment-2-netpol"].Spec.PodSelector.MatchLabels)
+	require.Equal(t, map[string]string{"app": "frontend-cache"},
+		netpolsByName["team-b/frontend-statefulset-netpol"].Spec.PodSelector.MatchLabels)
+
+	// team-b's frontend also connects to the cache, while team-a's frontend does not
+	require.Len(t, netpolsByName["team-a/frontend-netpol"].Spec.Egress, 2)
+	require.Len(t, netpolsByName["team-b/frontend-deployment-2-netpol"].Spec.Egress, 3)
+	// team-a's api accepts connections from team-a's frontend and from team-b's api
+	require.Len(t, netpolsByName["team-a/api-netpol"].Spec.Ingress, 2)
+
+	authzPolicies, err := synthesizer.AuthorizationPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	authzNames := []string{}
+	for _, policy := range authzPolicies {
+		authzNames = append(authzNames, policy.Namespace+"/"+policy.Name)
+	}
+	require.Subset(t, authzNames, []string{"team-a/frontend-authz", "team-b/frontend-deployment-authz",
+		"team-b/frontend-deployment-2-authz", "team-b/frontend-statefulset-authz"})
+
+	calicoPolicies, err := synthesizer.CalicoPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, calicoPolicies, 7) // 6 workloads + the GlobalNetworkPolicy
+}
+
+func TestWorkloadPolicyNames(t *testing.T) {
+	deploy := newWorkload("ns1", "Deployment", "web", nil)
+	statefulSet := newWorkload("ns1", "StatefulSet", "web", nil)
+	otherNsDeploy := newWorkload("ns2", "Deployment", "web", nil)
+	names := workloadPolicyNames([]*Resource{deploy, statefulSet, otherNsDeploy})
+	require.Equal(t, "web-deployment", names[workloadIdentity(deploy)])
+	require.Equal(t, "web-statefulset", names[workloadIdentity(statefulSet)])
+	require.Equal(t, "web", names[workloadIdentity(otherNsDeploy)])
+
+	// a disambiguated name must not collide with the name of another workload
+	namedLikeDeploy := newWorkload("ns1", "Deployment", "web-deployment", nil)
+	names = workloadPolicyNames([]*Resource{deploy, statefulSet, namedLikeDeploy})
+	require.Equal(t, "web-deployment-2", names[workloadIdentity(deploy)])
+	require.Equal(t, "web-statefulset", names[workloadIdentity(statefulSet)])
+	require.Equal(t, "web-deployment", names[workloadIdentity(namedLikeDeploy)])
+
+	// a workload with no namespace is in the default namespace
+	noNsDeploy := newWorkload("", "Deployment", "api", nil)
+	defaultNsDeploy := newWorkload("default", "Deployment", "api", nil)
+	defaultNsJob := newWorkload("default", "Job", "api", nil)
+	require.Equal(t, workloadIdentity(noNsDeploy), workloadIdentity(defaultNsDeploy))
+	require.True(t, noNsDeploy.equals(defaultNsDeploy))
+	require.False(t, defaultNsDeploy.equals(defaultNsJob))
+	names = workloadPolicyNames([]*Resource{noNsDeploy, defaultNsDeploy, defaultNsJob})
+	require.Len(t, names, 2)
+	require.Equal(t, "api-deployment", names[workloadIdentity(noNsDeploy)])
+	require.Equal(t, "api-job", names[workloadIdentity(defaultNsJob)])
+}
+
+func TestPoliciesSynthesizerAPIWorkloadSelectors(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "workload_selectors")
+	synthesizer := NewPoliciesSynthesizer()
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	selectors := map[string]metaV1.LabelSelector{}
+	for _, netpol := range netpols {
+		selectors[netpol.Name] = netpol.Spec.PodSelector
+	}
+	webSelector := selectors["web-netpol"]
+	require.Equal(t, map[string]string{"app": "web"}, webSelector.MatchLabels)
+	require.Len(t, webSelector.MatchExpressions, 1)
+	require.Equal(t, map[string]string{"app": "db"}, selectors["db-netpol"].MatchLabels)
+	require.Equal(t, map[string]string{"app": "reports"}, selectors["reports-netpol"].MatchLabels)
+	// the canary's own selector also selects the pods of web, so its template labels are used
+	require.Equal(t, map[string]string{"app": "web", "track": "canary"}, selectors["web-canary-netpol"].MatchLabels)
+
+	dbIngress := netpols[0].Spec.Ingress
+	require.Equal(t, "db-netpol", netpols[0].Name)
+	require.Len(t, dbIngress, 3)
+	require.Equal(t, &webSelector, dbIngress[0].From[0].PodSelector) // match expressions are also used in rule peers
+
+	authzPolicies, err := synthesizer.AuthorizationPoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	for _, policy := range authzPolicies {
+		if policy.Name == "web-authz" { // Istio's WorkloadSelector does not support match expressions
+			require.Equal(t, map[string]string{"app": "web", "tier": "frontend", "version": "v3"}, policy.Spec.Selector.MatchLabels)
+		}
+	}
+}
+
+func TestPoliciesSynthesizerAPIProvenanceAnnotations(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "acs-security-demos")
+	synthesizer := NewPoliciesSynthesizer()
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	for _, netpol := range netpols {
+		require.Empty(t, netpol.Annotations)
+	}
+
+	synthesizer = NewPoliciesSynthesizer(WithProvenanceAnnotations())
+	netpols, err = synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	foundCheckout := false
+	for _, netpol := range netpols {
+		if netpol.Spec.PodSelector.Size() == 0 { // a default-deny policy
+			require.Contains(t, netpol.Annotations["np-guard.io/explanation"], "namespace "+netpol.Namespace)
+			continue
+		}
+		require.Contains(t, netpol.Annotations["np-guard.io/workload"], "Deployment "+netpol.Namespace+"/")
+		for i := range netpol.Spec.Ingress {
+			require.NotEmpty(t, netpol.Annotations[fmt.Sprintf("np-guard.io/ingress-rule-%d", i)])
+		}
+		for i := range netpol.Spec.Egress {
+			require.NotEmpty(t, netpol.Annotations[fmt.Sprintf("np-guard.io/egress-rule-%d", i)])
+		}
+		if netpol.Name != "checkout-netpol" {
+			continue
+		}
+		foundCheckout = true
+		notificationReason := netpol.Annotations["np-guard.io/egress-rule-0"]
+		require.Contains(t, notificationReason, "Deployment backend/checkout (")
+		require.Contains(t, notificationReason, "refers to Service backend/notification-service (")
+		require.Contains(t, notificationReason,
+			`in container checkout, configmap checkout-endpoint-config key NOTIFICATION_ADDR (envFrom): "notification-service"`)
+		require.Equal(t, "the workload resolves the DNS names of its egress destinations", netpol.Annotations["np-guard.io/egress-rule-3"])
+	}
+	require.True(t, foundCheckout)
+}
+
+func TestPoliciesSynthesizerAPIExplainWorkload(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "explain")
+	synthesizer := NewPoliciesSynthesizer()
+	explanation, err := synthesizer.ExplainWorkloadFromFolderPath(dirPath, "shop", "deployment", "checkout")
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, "checkout", explanation.Workload.Resource.Name)
+	require.Empty(t, explanation.Services)
+	require.Empty(t, explanation.IngressRules)
+	require.Len(t, explanation.EgressRules, 2) // payments and DNS
+
+	reasons := map[string]string{}
+	for _, addr := range explanation.Addresses {
+		require.Len(t, addr.Services, 1)
+		reasons[addr.Field] = addr.Services[0].Reason
+		require.Equal(t, addr.Field == "env PAYMENTS_URL", addr.Services[0].Matched)
+		require.Equal(t, addr.Field == "env CACHE_ADDR", addr.Rejected)
+	}
+	require.Len(t, reasons, 5)
+	require.Empty(t, reasons["env PAYMENTS_URL"])
+	require.Contains(t, reasons["env REDIS_ADDR"], "port mismatch")
+	require.Contains(t, reasons["env INVENTORY_ADDR"], "namespace mismatch")
+	require.Contains(t, reasons["args[0]"], "is not one of the Service's addresses")
+	require.Contains(t, reasons["env CACHE_ADDR"], "not DNS-1123")
+	require.Contains(t, explanation.String(), "does not match Service stock/inventory")
+
+	explanation, err = synthesizer.ExplainWorkloadFromFolderPath(dirPath, "shop", "StatefulSet", "checkout")
+	notFound := &WorkloadNotFoundError{}
+	require.NotNil(t, err)
+	require.True(t, errors.As(err, &notFound))
+	require.Nil(t, explanation)
+}
+
+func TestPoliciesSynthesizerAPIDiff(t *testing.T) {
+	baseDir := filepath.Join(getTestsDir(), "connectivity_diff", "base")
+	headDir := filepath.Join(getTestsDir(), "connectivity_diff", "head")
+	synthesizer := NewPoliciesSynthesizer()
+	diff, err := synthesizer.DiffFromFolderPaths([]string{baseDir}, []string{headDir})
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, []ConnectionChange{{Source: "Deployment store/api", Target: "Deployment store/cache", Service: "Service store/cache",
+		NewPorts: []string{"TCP/6379"}}}, diff.AddedConnections)
+	require.Len(t, diff.RemovedConnections, 1)
+	require.Equal(t, "Deployment store/db", diff.RemovedConnections[0].Target)
+	require.Len(t, diff.ChangedPorts, 1)
+	require.Equal(t, []string{"TCP/8080"}, diff.ChangedPorts[0].OldPorts)
+	require.Equal(t, []string{"TCP/9090"}, diff.ChangedPorts[0].NewPorts)
+	require.Equal(t, []ExposureChange{{Service: "Service store/api", Exposure: "inside the cluster", Ports: []string{"TCP/8080"}}},
+		diff.ExposedServices)
+	require.Empty(t, diff.UnexposedServices)
+	require.Len(t, diff.PolicyChanges, 4)
+
+	// the same connections in reverse order are no change (the unchanged connections above are already defined in other files)
+	conns, err := synthesizer.ConnectionsFromFolderPath(headDir)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	reversed := slices.Clone(conns)
+	slices.Reverse(reversed)
+	diff = DiffConnections(conns, reversed)
+	require.True(t, diff.IsEmpty())
+	require.Equal(t, "No connectivity changes", diff.String())
+}
+
+func TestPoliciesSynthesizerAPIDiffFailFast(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "bad_yamls")
+	synthesizer := NewPoliciesSynthesizer(WithStopOnError())
+	diff, err := synthesizer.DiffFromFolderPaths([]string{dirPath}, []string{dirPath})
+	require.Nil(t, err)
+	require.Len(t, synthesizer.Errors(), 1) // the head revision is not processed after the base revision fails
+	badYaml := &FailedReadingFileError{}
+	require.True(t, errors.As(synthesizer.Errors()[0].Error(), &badYaml))
+	require.Nil(t, diff)
+}
+
+func TestPoliciesSynthesizerAPIPolicyGaps(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "policy_gaps")
+	synthesizer := NewPoliciesSynthesizer()
+	report, err := synthesizer.PolicyGapsFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, report.Policies, 4)
+	require.Equal(t, []BlockedConnection{
+		{Source: "Deployment shop/api", Target: "Deployment shop/db", Service: "Service shop/db", Ports: []string{"TCP/5432"},
+			Reasons: []string{"ingress denied by shop/default-deny"}},
+		{Source: "Deployment shop/frontend", Target: "Deployment shop/api", Service: "Service shop/api", Ports: []string{"TCP/9090"},
+			Reasons: []string{"egress denied by shop/frontend-egress"}},
+	}, report.BlockedConnections)
+	require.Len(t, report.UnneededFlows, 3)
+	require.Equal(t, UnneededFlow{Source: "Deployment shop/db", Target: "Deployment shop/api", Ports: []string{"TCP/9090"},
+		AllowedBy: []string{"shop/api-ingress"}}, report.UnneededFlows[1])
+
+	// the synthesized policies allow exactly the discovered connections
+	resources, conns, errs := synthesizer.extractConnectionsFromFolderPaths([]string{dirPath})
+	require.Nil(t, hasFatalError(errs))
+	netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	report = findPolicyGaps(resources, nil, conns, netpols)
+	require.True(t, report.IsEmpty(), report.String())
+}
+
+func TestPoliciesSynthesizerAPIConnectivityMatrix(t *testing.T) {
+	dirPath := filepath.Join(getTestsDir(), "sockshop")
+	synthesizer := NewPoliciesSynthesizer()
+	matrix, err := synthesizer.ConnectivityMatrixFromFolderPath(dirPath)
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, matrix.Destinations, 14)
+	require.Equal(t, append(slices.Clone(matrix.Destinations), "external/ingress", "any in-cluster"), matrix.Sources)
+	require.Len(t, matrix.Ports, len(matrix.Sources))
+
+	cell := func(source, destination string) []string {
+		return matrix.Ports[slices.Index(matrix.Sources, source)][slices.Index(matrix.Destinations, destination)]
+	}
+	require.Equal(t, []string{"TCP/27017"}, cell("Deployment sock-shop/carts", "Deployment sock-shop/carts-db"))
+	require.Empty(t, cell("Deployment sock-shop/carts-db", "Deployment sock-shop/carts"))
+	require.Equal(t, []string{"TCP/8079"}, cell("external/ingress", "Deployment sock-shop/front-end"))
+	require.Empty(t, cell("any in-cluster", "Deployment sock-shop/user-db"))
+
+	// workloads defined in several files appear once
+	matrix, err = synthesizer.ConnectivityMatrixFromFolderPath(filepath.Join(getTestsDir(), "bookinfo"))
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Len(t, slices.Compact(slices.Clone(matrix.Destinations)), len(matrix.Destinations))
+}
+
+func TestTopologyRenderers(t *testing.T) {
+	synthesizer := NewPoliciesSynthesizer()
+	conns, err := synthesizer.ConnectionsFromFolderPath(filepath.Join(getTestsDir(), "sockshop"))
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	dot := DOTFromConnections(conns)
+	require.True(t, strings.HasPrefix(dot, "digraph topology {"))
+	require.Contains(t, dot, "subgraph \"cluster_sock-shop\" {")
+	require.Contains(t, dot, "\"Deployment sock-shop/orders\" -> \"Deployment sock-shop/carts\" [label=\"carts TCP/80,TCP/9090\"]\n")
+	require.Contains(t, dot, "\"external\" -> \"Deployment sock-shop/front-end\" [label=\"front-end TCP/80\", style=dashed]\n")
+	require.Contains(t, dot, "\"in-cluster\" -> \"Deployment sock-shop/carts\" [label=\"carts TCP/9090\", style=dashed]\n") // Prometheus port
+	require.Equal(t, 1, strings.Count(dot, "\t\"Deployment sock-shop/carts\" [label="), "each workload should have a single node")
+
+	mermaid := MermaidFromConnections(conns)
+	require.True(t, strings.HasPrefix(mermaid, "flowchart LR\n    subgraph ns0[\"sock-shop\"]\n"))
+	require.Contains(t, mermaid, "    external -.->|\"front-end TCP/80\"| ")
+	require.Equal(t, 3, strings.Count(mermaid, " -->|"), "expected an edge per connection between workloads")
+}
+
+func TestTopologyRenderersRouteExposure(t *testing.T) {
+	synthesizer := NewPoliciesSynthesizer()
+	conns, err := synthesizer.ConnectionsFromFolderPath(filepath.Join(getTestsDir(), "acs-security-demos"))
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+
+	// Services exposed by Routes are reached from outside the cluster, through the Routes named in the edge labels
+	dot := DOTFromConnections(conns)
+	require.Contains(t, dot, "\t\"external\" [shape=ellipse, style=dashed]\n")
+	require.Contains(t, dot,
+		"\"external\" -> \"Deployment frontend/webapp\" [label=\"webapp-service TCP/8080 via Route frontend/webapp\", style=dashed]\n")
+	require.NotContains(t, dot, "in-cluster")
+
+	mermaid := MermaidFromConnections(conns)
+	require.Contains(t, mermaid, "    external -.->|\"asset-cache-service TCP/8080 via Route frontend/asset-cache\"| ")
+	require.NotContains(t, mermaid, "in-cluster")
+}
+
+func TestPoliciesSynthesizerAPITopologyReport(t *testing.T) {
+	synthesizer := NewPoliciesSynthesizer()
+	report, err := synthesizer.TopologyReportFromFolderPath(filepath.Join(getTestsDir(), "k8s_guestbook"))
+	require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+	require.Equal(t, []string{"default", "redis"}, report.Namespaces)
+	require.Len(t, report.Workloads, 3)
+	require.Len(t, report.Edges, 4)
+
+	frontend := report.Workloads[0]
+	require.Equal(t, "Deployment default/frontend", frontend.ID)
+	require.Contains(t, frontend.Addresses, "redis-leader.redis:6379")
+	require.Equal(t, []string{"frontend"}, frontend.Services)
+	require.Contains(t, frontend.Policies, "name: frontend-netpol\n")
+	require.Contains(t, frontend.Policies, "---\n", "the default-deny policy should also select the workload")
+
+	html := report.HTML()
+	require.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+	require.Contains(t, html, `"id":"Deployment default/frontend"`)
+	for _, externalRef := range []string{" src=", " href=", "@import", "url(http"} {
+		require.NotContains(t, html, externalRef, "the report should not load any external resource")
+	}
+}
+
+// TestVerifySynthesizedPolicies checks that the NetworkPolicies synthesized for each example allow all its discovered connections,
+// and that the default-deny policies alone block them
+func TestVerifySynthesizedPolicies(t *testing.T) {
+	entries, err := os.ReadDir(getTestsDir())
+	require.Nil(t, err)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t.Run(entry.Name(), func(t *testing.T) {
+			dirPath := filepath.Join(getTestsDir(), entry.Name())
+			synthesizer := NewPoliciesSynthesizer()
+			conns, err := synthesizer.ConnectionsFromFolderPath(dirPath)
+			if err != nil {
+				t.Skipf("no connections to verify: %v", err)
+			}
+			netpols, err := synthesizer.PoliciesFromFolderPath(dirPath)
+			require.Nilf(t, err, "expected no fatal errors, but got %v", err)
+			require.Empty(t, VerifyPolicies(conns, netpols))
+
+			defaultDenyOnly := slices.DeleteFunc(netpols, func(netpol *network.NetworkPolicy) bool {
+				return !strings.HasPrefix(netpol.Name, "default-deny-in-namespace")
+			})
+			hasFlows := slices.ContainsFunc(conns, func(conn *Connections) bool {
+				return slices.ContainsFunc(connectionFlows(conn), func(flow connectionFlow) bool { return len(flow.ports) > 0 })
+			})
+			require.Equal(t, hasFlows, len(VerifyPolicies(conns, defaultDenyOnly)) > 0)
+		})
+	}
+}
+
+func TestEvaluateFlow(t *testing.T) {
+	port := func(p intstr.IntOrString) *network.NetworkPolicyPort { return &network.NetworkPolicyPort{Port: &p} }
+	endPort := int32(8090)
+	apiPolicy := &network.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{Name: "api", Namespace: "shop"},
+		Spec: network.NetworkPolicySpec{
+			PodSelector: metaV1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+			Ingress: []network.NetworkPolicyIngressRule{
+				{From: []network.NetworkPolicyPeer{{PodSelector: &metaV1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}},
+					Ports: []network.NetworkPolicyPort{*port(intstr.FromString("http"))}},
+				{From: []network.NetworkPolicyPeer{{NamespaceSelector: &metaV1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: "monitoring"}}}},
+					Ports: []network.NetworkPolicyPort{{Port: &intstr.IntOrString{IntVal: 8080}, EndPort: &endPort}}},
+				{From: []network.NetworkPolicyPeer{{IPBlock: &network.IPBlock{CIDR: "0.0.0.0/0"}}},
+					Ports: []network.NetworkPolicyPort{*port(intstr.FromInt(443))}},
+			},
+		},
+	}
+	webPolicy := &network.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "shop"},
+		Spec: network.NetworkPolicySpec{
+			Egress:      []network.NetworkPolicyEgressRule{{Ports: []network.NetworkPolicyPort{*port(intstr.FromInt(9090))}}},
+			PolicyTypes: []network.PolicyType{network.PolicyTypeEgress},
+		},
+	}
+	policies := []*network.NetworkPolicy{apiPolicy, webPolicy}
+
+	api := &FlowPeer{Namespace: "shop", Labels: map[string]string{"app": "api"}}
+	web := &FlowPeer{Namespace: "shop", Labels: map[string]string{"app": "web"}}
+	prometheus := &FlowPeer{Namespace: "monitoring", Labels: map[string]string{"app": "prometheus"}}
+	external := &FlowPeer{External: true}
+	anyPod := &FlowPeer{}
+	httpPort := &FlowPort{Number: 9090, Name: "http", Protocol: core.ProtocolTCP}
+
+	require.Equal(t, FlowVerdict{Allowed: true, EgressPolicies: []string{"shop/web"}, IngressPolicies: []string{"shop/api"}},
+		EvaluateFlow(policies, web, api, httpPort))
+	require.False(t, EvaluateFlow(policies, web, api, &FlowPort{Number: 9090, Protocol: core.ProtocolUDP}).Allowed)
+	require.False(t, EvaluateFlow(policies, web, api, &FlowPort{Number: 8085, Protocol: core.ProtocolTCP}).Allowed) // egress denied
+	require.False(t, EvaluateFlow(policies, prometheus, api, httpPort).Allowed)
+	require.True(t, EvaluateFlow(policies, prometheus, api, &FlowPort{Number: 8085, Protocol: core.ProtocolTCP}).Allowed)
+	require.False(t, EvaluateFlow(policies, prometheus, api, &FlowPort{Number: 8091, Protocol: core.ProtocolTCP}).Allowed)
+	require.True(t, EvaluateFlow(policies, external, api, &FlowPort{Number: 443, Protocol: core.ProtocolTCP}).Allowed)
+	require.False(t, EvaluateFlow(policies, anyPod, api, &FlowPort{Number: 443, Protocol: core.ProtocolTCP}).Allowed)
+	require.Equal(t, FlowVerdict{Allowed: false, IngressPolicies: []string{"shop/api"}}, EvaluateFlow(policies, anyPod, api, httpPort))
+	require.True(t, EvaluateFlow(policies, api, prometheus, httpPort).Allowed) // no policy selects either end
+}
+
+func TestMinimalPodSelectorPrefersAppName(t *testing.T) {
+	frontendLabels := map[string]string{"app.kubernetes.io/name": "frontend", "component": "web", "tier": "front"}
+	backendLabels := map[string]string{"app.kubernetes.io/name": "backend", "component": "api", "tier": "back"}
+	frontend := newWorkload("", "Deployment", "frontend", frontendLabels)
+	backend := newWorkload("", "Deployment", "backend", backendLabels)
+	synthesizer := NewPoliciesSynthesizer(WithMinimalPodSelectors())
+	selectors := synthesizer.podSelectors([]*Resource{frontend, backend})
+	require.Equal(t, map[string]string{"app.kubernetes.io/name": "frontend"}, selectors[workloadIdentity(frontend)].MatchLabels)
+	require.Equal(t, map[string]string{"app.kubernetes.io/name": "backend"}, selectors[workloadIdentity(backend)].MatchLabels)
+
+	synthesizer = NewPoliciesSynthesizer(WithMinimalPodSelectors(), WithIgnoredPodLabels([]string{"app.kubernetes.io/name"}))
+	selectors = synthesizer.podSelectors([]*Resource{frontend, backend})
+	require.Equal(t, map[string]string{"component": "web"}, selectors[workloadIdentity(frontend)].MatchLabels)
+}
+
+func TestMinimalPodSelectorDefaultNamespace(t *testing.T) {
+	noNamespace := newWorkload("", "Deployment", "web", map[string]string{"app": "shop", "tier": "web"})
+	defaultNamespace := newWorkload("default", "Deployment", "db", map[string]string{"app": "shop", "tier": "db"})
+	synthesizer := NewPoliciesSynthesizer(WithMinimalPodSelectors())
+	selectors := synthesizer.podSelectors([]*Resource{noNamespace, defaultNamespace})
+	// a workload with no namespace is in the default namespace, so the shared app label does not tell the workloads apart
+	require.Equal(t, map[string]string{"tier": "web"}, selectors[workloadIdentity(noNamespace)].MatchLabels)
+	require.Equal(t, map[string]string{"tier": "db"}, selectors[workloadIdentity(defaultNamespace)].MatchLabels)
+}
+
+func TestMinimalPodSelectorManyLabels(t *testing.T) {
+	// the workloads share many (Helm-like) labels, and each other workload differs from api in a single label,
+	// so api can only be told apart using 4 labels - more than are searched for exhaustively
+	labels := func(differentKey string) map[string]string {
+		podLabels := map[string]string{}
+		for i := 0; i < 30; i++ {
+			podLabels[fmt.Sprintf("shared-%d", i)] = "value"
+		}
+		for _, key := range []string{"a", "b", "c", "d"} {
+			podLabels[key] = "api"
+			if key == differentKey {
+				podLabels[key] = "other"
+			}
+		}
+		return podLabels
+	}
+	resources := []*Resource{newWorkload("shop", "Deployment", "api", labels(""))}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		resources = append(resources, newWorkload("shop", "Deployment", "other-"+key, labels(key)))
+	}
+	synthesizer := NewPoliciesSynthesizer(WithMinimalPodSelectors())
+	selectors := synthesizer.podSelectors(resources)
+	require.Equal(t, map[string]string{"a": "api", "b": "api", "c": "api", "d": "api"}, selectors[workloadIdentity(resources[0])].MatchLabels)
+	require.Len(t, selectors[workloadIdentity(resources[1])].MatchLabels, 1) // a single label tells other-a apart
+}
+
 func TestPoliciesSynthesizerAPIFatalError(t *testing.T) {
 	dirPath1 := filepath.Join(getTestsDir(), "k8s_wordpress_example")
 	dirPath2 := filepath.Join(getTestsDir(), "badPath")
@@ -229,11 +1278,26 @@ func TestExtractConnectionsCustomWalk2(t *testing.T) {
 	dirPath := filepath.Join(getTestsDir(), "sockshop")
 	synthesizer := NewPoliciesSynthesizer(WithWalkFn(filepath.WalkDir))
 	resources, conns, errs := synthesizer.extractConnectionsFromFolderPaths([]string{dirPath})
-	require.Len(t, errs, 0)
+	require.Len(t, errs, 2) // carts and user services target ports which their deployments do not declare
+	noTargetPort := &TargetPortNotFoundError{}
+	for _, err := range errs {
+		require.True(t, errors.As(err.Error(), &noTargetPort))
+		require.False(t, err.IsSevere())
+	}
 	require.Len(t, conns, 15)
 	require.Len(t, resources, 14)
 }
 
+// newWorkload returns a workload with the given identity and pod labels, for tests which need no manifests
+func newWorkload(namespace, kind, name string, labels map[string]string) *Resource {
+	res := &Resource{}
+	res.Resource.Namespace = namespace
+	res.Resource.Kind = kind
+	res.Resource.Name = name
+	res.Resource.Labels = labels
+	return res
+}
+
 func readLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
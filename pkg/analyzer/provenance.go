@@ -0,0 +1,117 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	explanationAnnotationPrefix = "np-guard.io/"
+	workloadAnnotation          = explanationAnnotationPrefix + "workload"
+	explanationAnnotation       = explanationAnnotationPrefix + "explanation"
+	ingressRuleAnnotation       = explanationAnnotationPrefix + "ingress-rule-%d"
+	egressRuleAnnotation        = explanationAnnotationPrefix + "egress-rule-%d"
+
+	probeReason = "kubelet probes the workload's containers"
+	dnsReason   = "the workload resolves the DNS names of its egress destinations"
+)
+
+// provenanceAnnotationsOf returns the annotations of the given workload's NetworkPolicy, naming the workload,
+// and explaining why each of the policy's rules is required. Returns nil if provenance annotations were not requested.
+func (ps *PoliciesSynthesizer) provenanceAnnotationsOf(deployConn *deploymentConnectivity) map[string]string {
+	if !ps.provenanceAnnotations {
+		return nil
+	}
+	annotations := map[string]string{workloadAnnotation: workloadDescription(&deployConn.Resource)}
+	for i, reasons := range deployConn.ingressReasons {
+		annotations[fmt.Sprintf(ingressRuleAnnotation, i)] = strings.Join(reasons, "; ")
+	}
+	for i, reasons := range deployConn.egressReasons {
+		annotations[fmt.Sprintf(egressRuleAnnotation, i)] = strings.Join(reasons, "; ")
+	}
+	return annotations
+}
+
+// defaultDenyProvenanceAnnotations returns the annotations of the default-deny NetworkPolicy of the given namespace.
+// Returns nil if provenance annotations were not requested.
+func (ps *PoliciesSynthesizer) defaultDenyProvenanceAnnotations(namespace string) map[string]string {
+	if !ps.provenanceAnnotations {
+		return nil
+	}
+	return map[string]string{
+		explanationAnnotation: fmt.Sprintf("denies all traffic of the pods in namespace %s, which no other policy allows",
+			namespaceOrDefault(namespace)),
+	}
+}
+
+// resourceDescription returns the kind, namespace and name of a resource, and the file in which it is defined
+func resourceDescription(kind, namespace, name, filePath string) string {
+	return fmt.Sprintf("%s %s (%s)", kind, namespacedName(namespace, name), filePath)
+}
+
+func workloadDescription(res *Resource) string {
+	return resourceDescription(res.Resource.Kind, res.Resource.Namespace, res.Resource.Name, res.Resource.FilePath)
+}
+
+func serviceDescription(svc *Service) string {
+	return resourceDescription(service, svc.Resource.Namespace, svc.Resource.Name, svc.Resource.FilePath)
+}
+
+// connectionReason explains a connection between two workloads by the addresses in the source's manifest,
+// which refer to the Service in front of the target workload
+func connectionReason(conn *Connections) string {
+	origins := make([]string, 0, len(conn.Origins))
+	for i := range conn.Origins {
+		origins = append(origins, conn.Origins[i].String())
+	}
+	reason := fmt.Sprintf("%s refers to %s", workloadDescription(conn.Source), serviceDescription(conn.Link))
+	if len(origins) > 0 {
+		reason += " in " + strings.Join(origins, ", ")
+	}
+	return reason
+}
+
+// exposureReason explains why a source-less Service is reachable on the given ports from the given ingress source,
+// or from any source if the Service is exposed outside the cluster (source is nil).
+// The resources exposing the ports (e.g., an Ingress or a Route) are named, if known.
+func exposureReason(svc *Service, source *IngressSource, ports []SvcNetworkAttr) string {
+	exposedBy := []string{}
+	for i := range ports {
+		for _, resource := range ports[i].exposedBy {
+			exposedBy = appendUnique(exposedBy, resource)
+		}
+	}
+	by := ""
+	if len(exposedBy) > 0 {
+		by = " by " + strings.Join(exposedBy, ", ")
+	}
+
+	switch {
+	case source == nil && (svc.Resource.Type == core.ServiceTypeLoadBalancer || svc.Resource.Type == core.ServiceTypeNodePort):
+		return fmt.Sprintf("%s is of type %s", serviceDescription(svc), svc.Resource.Type)
+	case source == nil:
+		return fmt.Sprintf("%s is exposed outside the cluster%s", serviceDescription(svc), by)
+	case source.Namespace == "" && len(source.PodLabels) == 0:
+		return fmt.Sprintf("%s is exposed to all pods in the cluster%s", serviceDescription(svc), by)
+	case source.Namespace == "":
+		return fmt.Sprintf("%s is exposed%s through the ingress pods labeled %s in all namespaces", serviceDescription(svc), by,
+			k8slabels.SelectorFromSet(source.PodLabels))
+	case len(source.PodLabels) == 0:
+		return fmt.Sprintf("%s is exposed%s through the ingress pods in namespace %s", serviceDescription(svc), by, source.Namespace)
+	}
+	return fmt.Sprintf("%s is exposed%s through the ingress pods labeled %s in namespace %s", serviceDescription(svc), by,
+		k8slabels.SelectorFromSet(source.PodLabels), source.Namespace)
+}
+
+func externalDestinationReason(dest *ExternalDestination) string {
+	return fmt.Sprintf("the workload connects to the external host %s", dest.Host)
+}
@@ -10,7 +10,13 @@ import (
 	"fmt"
 	"slices"
 
+	corev1 "k8s.io/api/core/v1"
+	network "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/resource"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
 
 	"github.com/np-guard/netpol-analyzer/pkg/manifests/fsscanner"
 )
@@ -31,29 +37,56 @@ const (
 	ingress               string = "Ingress"
 	httpRoute             string = "HTTPRoute"
 	grpcRoute             string = "GRPCRoute"
+	tcpRoute              string = "TCPRoute"
+	tlsRoute              string = "TLSRoute"
+	udpRoute              string = "UDPRoute"
+	gateway               string = "Gateway"
+	referenceGrant        string = "ReferenceGrant"
+	rollout               string = "Rollout"
+	deploymentConfig      string = "DeploymentConfig"
+	networkPolicy         string = "NetworkPolicy"
+	knativeService        string = "KnativeService" // the kind with which Knative Services are identified as workloads
 )
 
-var (
-	acceptedK8sKinds = []string{pod, replicaSet, replicationController, deployment, daemonSet, statefulSet, job, cronJob,
-		service, configmap, route, ingress, httpRoute, grpcRoute}
-)
+const knativeServingGroup = "serving.knative.dev" // Knative Services share their kind with K8s Services
 
 // resourceAccumulator is used to locate all relevant K8s resources in given file-system directories
 // and to convert them into the internal structs, used for later processing.
 type resourceAccumulator struct {
 	logger       Logger
 	stopOn1stErr bool
+	extractors   *extractorRegistry
+
+	workloads        []*Resource                      // accumulates all workload resources found
+	services         []*Service                       // accumulates all service resources found
+	configmaps       []*cfgMap                        // accumulates all ConfigMap resources found
+	servicesToExpose servicesToExpose                 // stores which services should be later exposed
+	gateways         map[types.NamespacedName]string  // the GatewayClass name of each Gateway found
+	referenceGrants  []*gatewayv1beta1.ReferenceGrant // accumulates all ReferenceGrant resources found
+	crossNsBackends  []*crossNamespaceBackend         // Service ports exposed by routes in other namespaces, pending a grant check
+
+	virtualServices  []*istioVirtualService  // accumulates all Istio VirtualService resources found
+	destinationRules []*istioDestinationRule // accumulates all Istio DestinationRule resources found
+	serviceEntries   []*istioServiceEntry    // accumulates all Istio ServiceEntry resources found
+
+	networkPolicies []*network.NetworkPolicy // accumulates all existing NetworkPolicy resources found
+}
 
-	workloads        []*Resource      // accumulates all workload resources found
-	services         []*Service       // accumulates all service resources found
-	configmaps       []*cfgMap        // accumulates all ConfigMap resources found
-	servicesToExpose servicesToExpose // stores which services should be later exposed
+// crossNamespaceBackend is a Service port exposed by a Gateway API route in another namespace.
+// Such a port is only exposed if a ReferenceGrant in the Service's namespace permits the reference.
+type crossNamespaceBackend struct {
+	routeKind      string
+	routeNamespace string
+	routeName      string
+	filePath       string
+	port           *ExposedServicePort
 }
 
-func newResourceAccumulator(logger Logger, failFast bool) *resourceAccumulator {
-	res := resourceAccumulator{logger: logger, stopOn1stErr: failFast}
+func newResourceAccumulator(logger Logger, failFast bool, extractors *extractorRegistry) *resourceAccumulator {
+	res := resourceAccumulator{logger: logger, stopOn1stErr: failFast, extractors: extractors}
 
 	res.servicesToExpose = servicesToExpose{}
+	res.gateways = map[types.NamespacedName]string{}
 
 	return &res
 }
@@ -111,55 +144,168 @@ func (ra *resourceAccumulator) parseInfos(infos []*resource.Info) []FileProcessi
 // parseInfo takes an Info object, parses it into a K8s resource and puts it into one of the 3 struct slices:
 // the workload resource slice, the Service resource slice and the ConfigMaps resource slice
 // It also updates the set of services to be exposed when parsing Ingress or OpenShift Routes
+// Resources are matched by their full GroupVersionKind. Workloads and exposing resources are parsed using the extractors
+// registered for their GroupVersionKind. Resources of a supported kind, but with an unsupported API version are reported.
 func (ra *resourceAccumulator) parseInfo(info *resource.Info) error {
 	if info == nil || info.Object == nil {
 		return fmt.Errorf("a bad Info object - Object field is Nil")
 	}
 
-	kind := info.Object.GetObjectKind().GroupVersionKind().Kind
-	if !slices.Contains(acceptedK8sKinds, kind) {
-		msg := fmt.Sprintf("skipping object with type: %s", kind)
-		resourcePath := info.Source
-		if resourcePath != "" {
-			msg = fmt.Sprintf("in file: %s, %s", resourcePath, msg)
-		}
-		ra.logger.Infof(msg)
-		return nil
+	gvk := info.Object.GetObjectKind().GroupVersionKind()
+	if wk, ok := ra.extractors.workloadKind(gvk); ok {
+		return ra.parseWorkload(info, wk)
+	}
+	if extractor, ok := ra.extractors.exposureExtractor(gvk); ok {
+		return ra.parseExposure(info, extractor)
 	}
 
 	var err error
-	switch kind {
-	case service:
+	switch {
+	case gvk == corev1.SchemeGroupVersion.WithKind(service):
 		var svc *Service
 		svc, err = k8sServiceFromInfo(info)
 		if err == nil {
 			ra.services = append(ra.services, svc)
 		}
-	case route:
-		err = ocRouteFromInfo(info, ra.servicesToExpose)
-	case ingress:
-		err = k8sIngressFromInfo(info, ra.servicesToExpose)
-	case httpRoute:
-		err = gatewayHTTPRouteFromInfo(info, ra.servicesToExpose)
-	case grpcRoute:
-		err = gatewayGRPCRouteFromInfo(info, ra.servicesToExpose)
-	case configmap:
+	case gvk == corev1.SchemeGroupVersion.WithKind(configmap):
 		var cfgmap *cfgMap
 		cfgmap, err = k8sConfigmapFromInfo(info)
 		if err == nil {
 			ra.configmaps = append(ra.configmaps, cfgmap)
 		}
+	case gvk == gatewayv1.SchemeGroupVersion.WithKind(gateway) || gvk == gatewayV1beta1.WithKind(gateway):
+		err = ra.parseGateway(info)
+	case gvk == gatewayV1beta1.WithKind(referenceGrant) || gvk == gatewayV1alpha2.WithKind(referenceGrant):
+		if grant := parseResourceFromInfo[gatewayv1beta1.ReferenceGrant](info); grant != nil {
+			ra.referenceGrants = append(ra.referenceGrants, grant)
+		} else {
+			err = fmt.Errorf("failed to parse ReferenceGrant resource")
+		}
+	case gvk == network.SchemeGroupVersion.WithKind(networkPolicy):
+		if netpol := parseResourceFromInfo[network.NetworkPolicy](info); netpol != nil {
+			ra.networkPolicies = append(ra.networkPolicies, netpol)
+		} else {
+			err = fmt.Errorf("failed to parse NetworkPolicy resource")
+		}
+	case isIstioNetworkingKind(gvk):
+		err = ra.parseIstioResource(info, gvk.Kind)
+	case ra.extractors.isUnsupportedVersion(gvk):
+		err = &UnsupportedAPIVersionError{gvk.Kind, gvk.GroupVersion().String(), ra.extractors.supportedVersions(gvk.Kind)}
 	default:
-		var wl *Resource
-		wl, err = k8sWorkloadObjectFromInfo(info)
-		if err == nil {
-			ra.workloads = append(ra.workloads, wl)
+		msg := fmt.Sprintf("skipping object with type: %s", gvk.Kind)
+		resourcePath := info.Source
+		if resourcePath != "" {
+			msg = fmt.Sprintf("in file: %s, %s", resourcePath, msg)
 		}
+		ra.logger.Infof(msg)
 	}
 
 	return err
 }
 
+// parseWorkload adds the workload in the given Info object, as well as the Service implicitly created for it (if any)
+func (ra *resourceAccumulator) parseWorkload(info *resource.Info, wk *workloadKind) error {
+	wl, err := k8sWorkloadObjectFromInfo(info, wk.extract)
+	if err != nil {
+		return err
+	}
+	if wk.kind != "" {
+		wl.Resource.Kind = wk.kind
+	}
+	ra.workloads = append(ra.workloads, wl)
+
+	if wk.impliedService != nil {
+		if obj, ok := info.Object.(*unstructured.Unstructured); ok {
+			svc := wk.impliedService(obj)
+			svc.Resource.FilePath = info.Source
+			ra.services = append(ra.services, svc)
+		}
+	}
+	return nil
+}
+
+// parseExposure updates the set of services to be exposed, based on the Service ports exposed by the resource in the given Info object
+func (ra *resourceAccumulator) parseExposure(info *resource.Info, extract ExposureExtractor) error {
+	obj, ok := info.Object.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("failed to parse %s resource", info.Object.GetObjectKind().GroupVersionKind().Kind)
+	}
+	exposedPorts, err := extract(obj)
+	if err != nil {
+		return err
+	}
+	gvk := obj.GroupVersionKind()
+	for i := range exposedPorts {
+		exposedPort := &exposedPorts[i]
+		exposedPort.exposedBy = resourceDescription(gvk.Kind, obj.GetNamespace(), obj.GetName(), info.Source)
+		if gvk.Group == gatewayv1.GroupName && exposedPort.Namespace != obj.GetNamespace() {
+			backend := crossNamespaceBackend{gvk.Kind, obj.GetNamespace(), obj.GetName(), info.Source, exposedPort}
+			ra.crossNsBackends = append(ra.crossNsBackends, &backend)
+			continue
+		}
+		ra.servicesToExpose.appendPort(exposedPort)
+	}
+	return nil
+}
+
+// exposeGrantedBackends updates the set of services to be exposed with the cross-namespace backends of Gateway API routes,
+// which are permitted by a ReferenceGrant. A warning is returned for each route backend which is not permitted.
+// It should only be called after ALL calls to getRelevantK8sResources successfully returned
+func (ra *resourceAccumulator) exposeGrantedBackends() []FileProcessingError {
+	warnings := []FileProcessingError{}
+	reported := map[string]bool{}
+	for _, backend := range ra.crossNsBackends {
+		if ra.isReferenceGranted(backend) {
+			ra.servicesToExpose.appendPort(backend.port)
+			continue
+		}
+		routeName := types.NamespacedName{Namespace: backend.routeNamespace, Name: backend.routeName}.String()
+		svcName := types.NamespacedName{Namespace: backend.port.Namespace, Name: backend.port.ServiceName}.String()
+		if key := backend.routeKind + "/" + routeName + "->" + svcName; !reported[key] {
+			reported[key] = true
+			warning := referenceNotGranted(backend.routeKind, routeName, svcName, backend.filePath)
+			warnings = appendAndLogNewError(warnings, warning, ra.logger)
+		}
+	}
+	return warnings
+}
+
+// isReferenceGranted checks whether a ReferenceGrant in the backend's namespace permits routes
+// of the backend's route kind and namespace to refer to the backend's Service
+func (ra *resourceAccumulator) isReferenceGranted(backend *crossNamespaceBackend) bool {
+	for _, grant := range ra.referenceGrants {
+		if grant.Namespace != backend.port.Namespace {
+			continue
+		}
+		fromGranted := slices.ContainsFunc(grant.Spec.From, func(from gatewayv1beta1.ReferenceGrantFrom) bool {
+			return string(from.Group) == gatewayv1.GroupName && string(from.Kind) == backend.routeKind &&
+				string(from.Namespace) == backend.routeNamespace
+		})
+		toGranted := slices.ContainsFunc(grant.Spec.To, func(to gatewayv1beta1.ReferenceGrantTo) bool {
+			return to.Group == "" && string(to.Kind) == service && (to.Name == nil || string(*to.Name) == backend.port.ServiceName)
+		})
+		if fromGranted && toGranted {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGateway records the GatewayClass of the Gateway in the given Info object.
+// This later allows determining which pods route the traffic of the Services exposed through this Gateway.
+func (ra *resourceAccumulator) parseGateway(info *resource.Info) error {
+	obj, ok := info.Object.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("failed to parse Gateway resource")
+	}
+	className, _, err := unstructured.NestedString(obj.Object, "spec", "gatewayClassName")
+	if err != nil {
+		return err
+	}
+	ra.gateways[types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}] = className
+	return nil
+}
+
 // inlineConfigMapRefsAsEnvs appends to the Envs of each given resource the ConfigMap values it is referring to
 // It should only be called after ALL calls to getRelevantK8sResources successfully returned
 func (ra *resourceAccumulator) inlineConfigMapRefsAsEnvs() []FileProcessingError {
@@ -172,12 +318,14 @@ func (ra *resourceAccumulator) inlineConfigMapRefsAsEnvs() []FileProcessingError
 	for _, res := range ra.workloads {
 		// inline the envFrom field in PodSpec->containers
 		for _, cfgMapRef := range res.Resource.ConfigMapRefs {
-			configmapFullName := res.Resource.Namespace + "/" + cfgMapRef
+			configmapFullName := res.Resource.Namespace + "/" + cfgMapRef.Name
 			if cfgMap, ok := cfgMapsByName[configmapFullName]; ok {
-				for _, v := range cfgMap.Data {
-					if netAddr, ok := networkAddressFromStr(v); ok {
-						res.Resource.NetworkAddrs = append(res.Resource.NetworkAddrs, netAddr)
-					}
+				usage := "envFrom"
+				if cfgMapRef.Container == "" {
+					usage = "volume"
+				}
+				for k, v := range cfgMap.Data {
+					res.addManifestValue(v, cfgMapRef.Container, fmt.Sprintf("configmap %s key %s (%s)", cfgMapRef.Name, k, usage))
 				}
 			} else {
 				parseErrors = appendAndLogNewError(parseErrors, configMapNotFound(configmapFullName, res.Resource.Name), ra.logger)
@@ -193,9 +341,8 @@ func (ra *resourceAccumulator) inlineConfigMapRefsAsEnvs() []FileProcessingError
 				continue
 			}
 			if val, ok := cfgMap.Data[cfgMapKeyRef.Key]; ok {
-				if netAddr, ok := networkAddressFromStr(val); ok {
-					res.Resource.NetworkAddrs = append(res.Resource.NetworkAddrs, netAddr)
-				}
+				field := fmt.Sprintf("configmap %s key %s (env %s)", cfgMapKeyRef.Name, cfgMapKeyRef.Key, cfgMapKeyRef.EnvName)
+				res.addManifestValue(val, cfgMapKeyRef.Container, field)
 			} else {
 				err := configMapKeyNotFound(cfgMapKeyRef.Name, cfgMapKeyRef.Key, res.Resource.Name)
 				parseErrors = appendAndLogNewError(parseErrors, err, ra.logger)
@@ -205,10 +352,35 @@ func (ra *resourceAccumulator) inlineConfigMapRefsAsEnvs() []FileProcessingError
 	return parseErrors
 }
 
+// checkHostNetworkWorkloads returns a warning for each workload whose pods use the host network.
+// NetworkPolicies do not apply to such pods, so policies synthesized for them will not be enforced.
+func (ra *resourceAccumulator) checkHostNetworkWorkloads() []FileProcessingError {
+	warnings := []FileProcessingError{}
+	for _, res := range ra.workloads {
+		if res.Resource.HostNetwork {
+			warnings = appendAndLogNewError(warnings, hostNetworkWorkload(res.Resource.Name, res.Resource.FilePath), ra.logger)
+		}
+	}
+	return warnings
+}
+
+// scannedNamespaces returns the namespaces of the scanned workloads and Services
+func (ra *resourceAccumulator) scannedNamespaces() map[string]bool {
+	namespaces := map[string]bool{}
+	for _, res := range ra.workloads {
+		namespaces[namespaceOrDefault(res.Resource.Namespace)] = true
+	}
+	for _, svc := range ra.services {
+		namespaces[namespaceOrDefault(svc.Resource.Namespace)] = true
+	}
+	return namespaces
+}
+
 // exposeServices changes the exposure of services pointed by resources such as Route or Ingress.
 // This will ensure that the network policy for their workloads will allow ingress from all the cluster or from the outside internet.
+// The given ingress sources determine from which pods the traffic to each exposed Service port is expected.
 // It should only be called after ALL calls to getRelevantK8sResources successfully returned
-func (ra *resourceAccumulator) exposeServices() {
+func (ra *resourceAccumulator) exposeServices(sources *ingressSources) {
 	for _, svc := range ra.services {
 		exposedServicesInNamespace, ok := ra.servicesToExpose[svc.Resource.Namespace]
 		if !ok {
@@ -221,8 +393,15 @@ func (ra *resourceAccumulator) exposeServices() {
 		for i := range svc.Resource.Network {
 			port := &svc.Resource.Network[i]
 			for _, portToExpose := range portsToExpose {
-				if port.equals(portToExpose) {
+				if portToExpose.matchesPort(port) && portToExpose.matchesProtocol(port.Protocol) {
 					port.exposeToCluster = true
+					port.addHTTPPaths(portToExpose.HTTPPaths)
+					if portToExpose.exposedBy != "" {
+						port.exposedBy = appendUnique(port.exposedBy, portToExpose.exposedBy)
+					}
+					for _, source := range sources.sourcesOf(portToExpose, ra.gateways) {
+						port.addIngressSource(source)
+					}
 				}
 			}
 		}
@@ -8,7 +8,10 @@ package analyzer
 
 import (
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 
 	core "k8s.io/api/core/v1"
 	network "k8s.io/api/networking/v1"
@@ -19,34 +22,80 @@ import (
 const (
 	networkAPIVersion = "networking.k8s.io/v1"
 	networkPolicyKind = "NetworkPolicy"
+	anyIPv4CIDR       = "0.0.0.0/0"
+
+	namespaceNameLabel = "kubernetes.io/metadata.name" // the label K8s puts on each namespace, holding its name
 )
 
 type deploymentConnectivity struct {
 	Resource
-	ingressConns []network.NetworkPolicyIngressRule
-	egressConns  []network.NetworkPolicyEgressRule
+	policyName       string                // the base name of the workload's policies
+	podSelector      *metaV1.LabelSelector // selects the workload's pods in policies and in rule peers
+	ingressConns     []network.NetworkPolicyIngressRule
+	ingressHTTPPaths [][]string // for each ingress rule, the HTTP paths it allows (nil means all paths)
+	ingressReasons   [][]string // for each ingress rule, why it is required
+	egressConns      []network.NetworkPolicyEgressRule
+	egressReasons    [][]string // for each egress rule, why it is required
+	externalDests    []*ExternalDestination
 }
 
 func (deployConn *deploymentConnectivity) addIngressRule(
-	peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort) {
+	peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort, reason string) {
+	deployConn.addIngressRuleWithHTTPPaths(peers, ports, nil, reason)
+}
+
+// addIngressRuleWithHTTPPaths adds an ingress rule, which only allows HTTP requests with the given paths (nil means all paths)
+func (deployConn *deploymentConnectivity) addIngressRuleWithHTTPPaths(
+	peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort, httpPaths []string, reason string) {
 	rule := network.NetworkPolicyIngressRule{From: peers, Ports: ports}
-	for _, existingRule := range deployConn.ingressConns {
+	for i, existingRule := range deployConn.ingressConns {
 		if reflect.DeepEqual(existingRule, rule) {
+			deployConn.ingressHTTPPaths[i] = mergeHTTPPaths(deployConn.ingressHTTPPaths[i], httpPaths)
+			deployConn.ingressReasons[i] = appendUnique(deployConn.ingressReasons[i], reason)
 			return
 		}
 	}
 	deployConn.ingressConns = append(deployConn.ingressConns, rule)
+	deployConn.ingressHTTPPaths = append(deployConn.ingressHTTPPaths, httpPaths)
+	deployConn.ingressReasons = append(deployConn.ingressReasons, []string{reason})
+}
+
+// mergeHTTPPaths returns the union of two sets of HTTP paths, where nil stands for all paths
+func mergeHTTPPaths(paths, otherPaths []string) []string {
+	if paths == nil || otherPaths == nil {
+		return nil
+	}
+	for _, path := range otherPaths {
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// exposedHTTPPaths returns the union of the HTTP paths exposed in the given ports, or nil if one of the ports exposes all paths
+func exposedHTTPPaths(ports []SvcNetworkAttr) []string {
+	paths := []string{}
+	for i := range ports {
+		paths = mergeHTTPPaths(paths, ports[i].exposedHTTPPaths())
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return paths
 }
 
 func (deployConn *deploymentConnectivity) addEgressRule(
-	peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort) {
+	peers []network.NetworkPolicyPeer, ports []network.NetworkPolicyPort, reason string) {
 	rule := network.NetworkPolicyEgressRule{To: peers, Ports: ports}
-	for _, existingRule := range deployConn.egressConns {
+	for i, existingRule := range deployConn.egressConns {
 		if reflect.DeepEqual(existingRule, rule) {
+			deployConn.egressReasons[i] = appendUnique(deployConn.egressReasons[i], reason)
 			return
 		}
 	}
 	deployConn.egressConns = append(deployConn.egressConns, rule)
+	deployConn.egressReasons = append(deployConn.egressReasons, []string{reason})
 }
 
 // Generate a default-deny NetworkPolicy for the given namespace
@@ -76,32 +125,44 @@ func getNsDefaultDenyPolicy(namespace string) *network.NetworkPolicy {
 // Generate default-deny NetworkPolicy for each namespace of the given resources
 func getNsDefaultDenyPolicies(resources []*Resource) []*network.NetworkPolicy {
 	denyNetpols := []*network.NetworkPolicy{}
-	namespaces := map[string]bool{}
-	for _, res := range resources {
-		namespace := res.Resource.Namespace
-		if _, ok := namespaces[namespace]; !ok {
-			namespaces[namespace] = true
-			denyNetpols = append(denyNetpols, getNsDefaultDenyPolicy(namespace))
-		}
+	for _, namespace := range workloadNamespaces(resources) {
+		denyNetpols = append(denyNetpols, getNsDefaultDenyPolicy(namespace))
 	}
 	return denyNetpols
 }
 
 func (ps *PoliciesSynthesizer) synthNetpols(resources []*Resource, connections []*Connections) []*network.NetworkPolicy {
-	deployConnectivity := determineConnectivityPerDeployment(connections)
+	deployConnectivity := determineConnectivityPerDeployment(resources, connections, ps.probeSourcePeers(), ps.podSelectors(resources))
 	netpols := ps.buildNetpolPerDeployment(deployConnectivity)
-	netpols = append(netpols, getNsDefaultDenyPolicies(resources)...)
+	if !ps.baselineAdminPolicy { // otherwise, default-deny is achieved by a BaselineAdminNetworkPolicy
+		for _, denyNetpol := range getNsDefaultDenyPolicies(resources) {
+			denyNetpol.Annotations = ps.defaultDenyProvenanceAnnotations(denyNetpol.Namespace)
+			netpols = append(netpols, denyNetpol)
+		}
+	}
 	return netpols
 }
 
-func determineConnectivityPerDeployment(connections []*Connections) []*deploymentConnectivity {
+// probeSourcePeers returns the peers from which kubelet probe traffic should be allowed (if any)
+func (ps *PoliciesSynthesizer) probeSourcePeers() []network.NetworkPolicyPeer {
+	peers := []network.NetworkPolicyPeer{}
+	for _, cidr := range ps.probeCIDRs {
+		peers = append(peers, network.NetworkPolicyPeer{IPBlock: &network.IPBlock{CIDR: cidr}})
+	}
+	return peers
+}
+
+// determineConnectivityPerDeployment computes the ingress and egress rules of each workload.
+// The pods of each workload are selected using the given selector labels (if given) or using all the workload's labels.
+func determineConnectivityPerDeployment(resources []*Resource, connections []*Connections,
+	probeSources []network.NetworkPolicyPeer, selectors map[string]*metaV1.LabelSelector) []*deploymentConnectivity {
 	deploysConnectivity := map[string]*deploymentConnectivity{}
 	for _, conn := range connections {
-		srcDeploy := findOrAddDeploymentConn(conn.Source, deploysConnectivity)
-		dstDeploy := findOrAddDeploymentConn(conn.Target, deploysConnectivity)
-		targetPorts := toNetpolPorts(conn.Link.Resource.Network, srcDeploy == nil && !conn.Link.Resource.ExposeExternally)
+		srcDeploy := findOrAddDeploymentConn(conn.Source, deploysConnectivity, selectors)
+		dstDeploy := findOrAddDeploymentConn(conn.Target, deploysConnectivity, selectors)
+		targetPorts := toNetpolPorts(conn.Link.Resource.Network, conn.Target, srcDeploy == nil && !conn.Link.Resource.ExposeExternally)
 		if conn.Source != nil && len(conn.Source.Resource.UsedPorts) > 0 {
-			targetPorts = toNetpolPorts(conn.Source.Resource.UsedPorts, false)
+			targetPorts = toNetpolPorts(conn.Source.Resource.UsedPorts, conn.Target, false)
 		}
 		if len(targetPorts) == 0 {
 			continue
@@ -109,42 +170,147 @@ func determineConnectivityPerDeployment(connections []*Connections) []*deploymen
 
 		if srcDeploy != nil {
 			netpolPeer := getNetpolPeer(srcDeploy, dstDeploy)
-			srcDeploy.addEgressRule([]network.NetworkPolicyPeer{netpolPeer}, targetPorts)
+			srcDeploy.addEgressRule([]network.NetworkPolicyPeer{netpolPeer}, targetPorts, connectionReason(conn))
 		}
 
 		switch {
 		case conn.Link.Resource.ExposeExternally:
-			dstDeploy.addIngressRule([]network.NetworkPolicyPeer{}, targetPorts) // allowing traffic from all sources
+			// allowing traffic from all sources
+			dstDeploy.addIngressRule([]network.NetworkPolicyPeer{}, targetPorts, exposureReason(conn.Link, nil, conn.Link.Resource.Network))
 		case srcDeploy == nil:
-			peer := network.NetworkPolicyPeer{NamespaceSelector: &metaV1.LabelSelector{}}
-			dstDeploy.addIngressRule([]network.NetworkPolicyPeer{peer}, targetPorts) // allowing traffic from all cluster sources
+			sources, portsPerSource := exposedPortsBySource(conn.Link.Resource.Network)
+			for i := range sources {
+				ports := toNetpolPorts(portsPerSource[i], conn.Target, false)
+				if len(ports) == 0 {
+					continue
+				}
+				peers := []network.NetworkPolicyPeer{sources[i].netpolPeer()}
+				paths := exposedHTTPPaths(portsPerSource[i])
+				// allowing traffic from ingress sources
+				dstDeploy.addIngressRuleWithHTTPPaths(peers, ports, paths, exposureReason(conn.Link, &sources[i], portsPerSource[i]))
+			}
 		default:
 			netpolPeer := getNetpolPeer(dstDeploy, srcDeploy)
-			dstDeploy.addIngressRule([]network.NetworkPolicyPeer{netpolPeer}, targetPorts) // allow traffic only from this specific source
+			// allow traffic only from this specific source
+			dstDeploy.addIngressRule([]network.NetworkPolicyPeer{netpolPeer}, targetPorts, connectionReason(conn))
+		}
+	}
+
+	if len(probeSources) > 0 {
+		for _, res := range resources {
+			probePorts := toNetpolProbePorts(res.Resource.ProbePorts)
+			if len(probePorts) > 0 {
+				deploy := findOrAddDeploymentConn(res, deploysConnectivity, selectors)
+				deploy.addIngressRule(probeSources, probePorts, probeReason) // allow kubelet probes
+			}
 		}
 	}
+	addExternalDestinations(resources, deploysConnectivity, selectors)
 
+	policyNames := workloadPolicyNames(resources)
 	retSlice := []*deploymentConnectivity{}
 	for _, deployConn := range deploysConnectivity {
+		deployConn.policyName = policyNames[workloadIdentity(&deployConn.Resource)]
 		retSlice = append(retSlice, deployConn)
 	}
-	// sort by name
+	// sort by policy name, then by namespace
 	sort.Slice(retSlice, func(i, j int) bool {
-		return retSlice[i].Resource.Resource.Name < retSlice[j].Resource.Resource.Name
+		if retSlice[i].policyName != retSlice[j].policyName {
+			return retSlice[i].policyName < retSlice[j].policyName
+		}
+		return retSlice[i].Resource.Resource.Namespace < retSlice[j].Resource.Resource.Namespace
 	})
 	return retSlice
 }
 
-func findOrAddDeploymentConn(resource *Resource, deployConns map[string]*deploymentConnectivity) *deploymentConnectivity {
+// workloadPolicyNames returns the base name of the policies of each given workload (keyed by workloadIdentity()).
+// This is the workload's name, followed by its kind if another workload in the same namespace has the same name.
+// If the resulting name is still taken by another workload in the namespace, a counter is appended to it.
+func workloadPolicyNames(resources []*Resource) map[string]string {
+	kindsPerName := map[string][]string{}
+	for _, res := range resources {
+		nsName := namespacedName(res.Resource.Namespace, res.Resource.Name)
+		if !slices.Contains(kindsPerName[nsName], res.Resource.Kind) {
+			kindsPerName[nsName] = append(kindsPerName[nsName], res.Resource.Kind)
+		}
+	}
+
+	policyNames := map[string]string{}
+	takenNames := map[string]bool{} // keyed by namespacedName()
+	ambiguous := []*Resource{}
+	for _, res := range resources { // names of unambiguous workloads are kept as is
+		if len(kindsPerName[namespacedName(res.Resource.Namespace, res.Resource.Name)]) > 1 {
+			ambiguous = append(ambiguous, res)
+			continue
+		}
+		policyNames[workloadIdentity(res)] = res.Resource.Name
+		takenNames[namespacedName(res.Resource.Namespace, res.Resource.Name)] = true
+	}
+	for _, res := range ambiguous {
+		if _, ok := policyNames[workloadIdentity(res)]; ok {
+			continue // the same workload appears more than once
+		}
+		baseName := res.Resource.Name + "-" + strings.ToLower(res.Resource.Kind)
+		policyName := baseName
+		for i := 2; takenNames[namespacedName(res.Resource.Namespace, policyName)]; i++ {
+			policyName = baseName + "-" + strconv.Itoa(i)
+		}
+		policyNames[workloadIdentity(res)] = policyName
+		takenNames[namespacedName(res.Resource.Namespace, policyName)] = true
+	}
+	return policyNames
+}
+
+// addExternalDestinations records the external destinations each workload connects to,
+// whether declared by Istio ServiceEntries or inferred from the workload's network addresses
+func addExternalDestinations(resources []*Resource, deployConns map[string]*deploymentConnectivity,
+	selectors map[string]*metaV1.LabelSelector) {
+	for _, res := range resources {
+		for _, dests := range [][]ExternalDestination{res.Resource.ExternalDestinations, res.Resource.InferredExternalDestinations} {
+			for i := range dests {
+				deploy := findOrAddDeploymentConn(res, deployConns, selectors)
+				deploy.externalDests = append(deploy.externalDests, &dests[i])
+			}
+		}
+	}
+}
+
+func toNetpolExternalPeers(dest *ExternalDestination) []network.NetworkPolicyPeer {
+	cidrs := dest.Addresses
+	if len(cidrs) == 0 {
+		cidrs = []string{anyIPv4CIDR} // the destination's addresses are unknown
+	}
+	peers := make([]network.NetworkPolicyPeer, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		peers = append(peers, network.NetworkPolicyPeer{IPBlock: &network.IPBlock{CIDR: cidr}})
+	}
+	return peers
+}
+
+func toNetpolExternalPorts(dest *ExternalDestination) []network.NetworkPolicyPort {
+	if dest.Port == 0 {
+		return nil // all ports
+	}
+	protocol := protocolOrDefault(dest.Protocol)
+	port := intstr.FromInt(dest.Port)
+	return []network.NetworkPolicyPort{{Protocol: &protocol, Port: &port}}
+}
+
+func findOrAddDeploymentConn(resource *Resource, deployConns map[string]*deploymentConnectivity,
+	selectors map[string]*metaV1.LabelSelector) *deploymentConnectivity {
 	if resource == nil || resource.Resource.Name == "" {
 		return nil
 	}
-	if deployConn, found := deployConns[resource.Resource.Name]; found {
+	id := workloadIdentity(resource)
+	if deployConn, found := deployConns[id]; found {
 		return deployConn
 	}
 
-	deploy := deploymentConnectivity{Resource: *resource}
-	deployConns[resource.Resource.Name] = &deploy
+	deploy := deploymentConnectivity{Resource: *resource, policyName: resource.Resource.Name, podSelector: selectors[id]}
+	if deploy.podSelector == nil {
+		deploy.podSelector = &metaV1.LabelSelector{MatchLabels: resource.Resource.Labels}
+	}
+	deployConns[id] = &deploy
 	return &deploy
 }
 
@@ -153,7 +319,7 @@ func getNetpolPeer(netpolDeploy, otherDeploy *deploymentConnectivity) network.Ne
 	if netpolDeploy.Resource.Resource.Namespace != otherDeploy.Resource.Resource.Namespace {
 		if otherDeploy.Resource.Resource.Namespace != "" {
 			netpolPeer.NamespaceSelector = &metaV1.LabelSelector{
-				MatchLabels: map[string]string{"kubernetes.io/metadata.name": otherDeploy.Resource.Resource.Namespace},
+				MatchLabels: map[string]string{namespaceNameLabel: otherDeploy.Resource.Resource.Namespace},
 			}
 		} // if otherDeploy has no namespace specified, we assume it is in the same namespace as the netpolDeploy
 	}
@@ -161,22 +327,23 @@ func getNetpolPeer(netpolDeploy, otherDeploy *deploymentConnectivity) network.Ne
 }
 
 func getDeployConnSelector(deployConn *deploymentConnectivity) *metaV1.LabelSelector {
-	return &metaV1.LabelSelector{MatchLabels: deployConn.Resource.Resource.Labels}
+	return deployConn.podSelector.DeepCopy()
 }
 
-func toNetpolPorts(ports []SvcNetworkAttr, exposedOnly bool) []network.NetworkPolicyPort {
+// toNetpolPorts converts the given service ports to NetworkPolicy ports, using the target ports as resolved for the target workload.
+// Ports whose named target ports cannot be resolved are skipped, as no traffic is forwarded to them
+// (a warning is issued for them when discovering the connections).
+func toNetpolPorts(ports []SvcNetworkAttr, target *Resource, exposedOnly bool) []network.NetworkPolicyPort {
 	netpolPorts := make([]network.NetworkPolicyPort, 0, len(ports))
-	for _, port := range ports {
+	for i := range ports {
+		port := &ports[i]
 		if exposedOnly && !port.exposeToCluster {
 			continue
 		}
-		protocol := port.Protocol
-		if protocol == "" {
-			protocol = core.ProtocolTCP
-		}
-		portNum := port.TargetPort
-		if portNum.Type == intstr.Int && portNum.IntVal == 0 {
-			portNum = intstr.FromInt(port.Port)
+		protocol := protocolOrDefault(port.Protocol)
+		portNum, ok := target.resolveTargetPort(port)
+		if !ok && portNum.Type == intstr.String {
+			continue
 		}
 		netpolPort := network.NetworkPolicyPort{
 			Protocol: &protocol,
@@ -187,21 +354,28 @@ func toNetpolPorts(ports []SvcNetworkAttr, exposedOnly bool) []network.NetworkPo
 	return netpolPorts
 }
 
+func toNetpolProbePorts(probePorts []intstr.IntOrString) []network.NetworkPolicyPort {
+	netpolPorts := make([]network.NetworkPolicyPort, 0, len(probePorts))
+	for i := range probePorts {
+		protocol := core.ProtocolTCP
+		netpolPorts = append(netpolPorts, network.NetworkPolicyPort{Protocol: &protocol, Port: &probePorts[i]})
+	}
+	return netpolPorts
+}
+
 func (ps *PoliciesSynthesizer) buildNetpolPerDeployment(deployConnectivity []*deploymentConnectivity) []*network.NetworkPolicy {
 	netpols := make([]*network.NetworkPolicy, 0, len(deployConnectivity))
 	for _, deployConn := range deployConnectivity {
-		if len(deployConn.egressConns) > 0 { // add a rule to allow egress DNS traffic (inside the cluster)
-			allClusterPeers := []network.NetworkPolicyPeer{{NamespaceSelector: &metaV1.LabelSelector{}}}
-			deployConn.addEgressRule(allClusterPeers, []network.NetworkPolicyPort{getDNSPort(&ps.dnsPort)})
-		}
+		ps.addExternalAndDNSEgressRules(deployConn)
 		netpol := network.NetworkPolicy{
 			TypeMeta: metaV1.TypeMeta{
 				Kind:       networkPolicyKind,
 				APIVersion: networkAPIVersion,
 			},
 			ObjectMeta: metaV1.ObjectMeta{
-				Name:      deployConn.Resource.Resource.Name + "-netpol",
-				Namespace: deployConn.Resource.Resource.Namespace,
+				Name:        deployConn.policyName + "-netpol",
+				Namespace:   deployConn.Resource.Resource.Namespace,
+				Annotations: ps.provenanceAnnotationsOf(deployConn),
 			},
 			Spec: network.NetworkPolicySpec{
 				PodSelector: *getDeployConnSelector(deployConn),
@@ -215,6 +389,18 @@ func (ps *PoliciesSynthesizer) buildNetpolPerDeployment(deployConnectivity []*de
 	return netpols
 }
 
+// addExternalAndDNSEgressRules allows egress to the external destinations the workload connects to,
+// and egress DNS traffic (inside the cluster) if the workload has any egress connections
+func (ps *PoliciesSynthesizer) addExternalAndDNSEgressRules(deployConn *deploymentConnectivity) {
+	for _, dest := range deployConn.externalDests {
+		deployConn.addEgressRule(toNetpolExternalPeers(dest), toNetpolExternalPorts(dest), externalDestinationReason(dest))
+	}
+	if len(deployConn.egressConns) > 0 {
+		allClusterPeers := []network.NetworkPolicyPeer{{NamespaceSelector: &metaV1.LabelSelector{}}}
+		deployConn.addEgressRule(allClusterPeers, []network.NetworkPolicyPort{getDNSPort(&ps.dnsPort)}, dnsReason)
+	}
+}
+
 func getDNSPort(portNum *intstr.IntOrString) network.NetworkPolicyPort {
 	udp := core.ProtocolUDP
 	return network.NetworkPolicyPort{
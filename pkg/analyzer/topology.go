@@ -0,0 +1,203 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	topologyExternalNode  = "external"   // stands for the addresses outside the cluster
+	topologyInClusterNode = "in-cluster" // stands for the pods in the cluster, which may connect to Services exposed inside the cluster
+)
+
+// topology is the graph of the discovered connectivity: workloads grouped by namespace, and edges labeled by Services and ports
+type topology struct {
+	namespaces []string            // sorted
+	workloads  map[string][]string // the (sorted) identities of the workloads in each namespace
+	edges      []topologyEdge      // sorted by source, target and service
+}
+
+// topologyEdge is the traffic from a workload (or from the external or in-cluster node) to a workload, through a Service
+type topologyEdge struct {
+	source, target, service string
+	ports                   []string
+	sourceless              bool   // the source is not a workload, but the Service is exposed outside the application
+	exposedBy               string // the resources (e.g., an Ingress or a Route) exposing the Service ports of a source-less edge
+}
+
+// DOTFromConnections renders the given connections as a Graphviz DOT graph. Workloads are grouped into a cluster per namespace,
+// and each edge is labeled by the Service through which the connection is made and its ports. Services exposed outside the cluster
+// (including by Ingress, Route or Gateway API resources, which are named in the edge label) are connected to an "external" node,
+// and Services exposed only inside the cluster to an "in-cluster" node, using dashed edges.
+func DOTFromConnections(conns []*Connections) string {
+	topo := buildTopology(conns)
+	var sb strings.Builder
+	sb.WriteString("digraph topology {\n\trankdir=LR\n\tnode [shape=box]\n")
+	for _, namespace := range topo.namespaces {
+		fmt.Fprintf(&sb, "\tsubgraph %s {\n\t\tlabel=%s\n", strconv.Quote("cluster_"+namespace), strconv.Quote(namespace))
+		for _, workload := range topo.workloads[namespace] {
+			fmt.Fprintf(&sb, "\t\t%s [label=%s]\n", strconv.Quote(workload), strconv.Quote(topologyNodeLabel(workload)))
+		}
+		sb.WriteString("\t}\n")
+	}
+	for _, node := range topo.sourcelessNodes() {
+		fmt.Fprintf(&sb, "\t%s [shape=ellipse, style=dashed]\n", strconv.Quote(node))
+	}
+	for i := range topo.edges {
+		edge := &topo.edges[i]
+		style := ""
+		if edge.sourceless {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&sb, "\t%s -> %s [label=%s%s]\n", strconv.Quote(edge.source), strconv.Quote(edge.target),
+			strconv.Quote(edge.label()), style)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// MermaidFromConnections renders the given connections as a Mermaid flowchart. Workloads are grouped into a subgraph per namespace,
+// and each edge is labeled by the Service through which the connection is made and its ports. Services exposed outside the cluster
+// (including by Ingress, Route or Gateway API resources, which are named in the edge label) are connected to an "external" node,
+// and Services exposed only inside the cluster to an "in-cluster" node, using dotted edges.
+func MermaidFromConnections(conns []*Connections) string {
+	topo := buildTopology(conns)
+	nodeIDs := map[string]string{} // Mermaid node IDs cannot contain spaces or slashes
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	for i, namespace := range topo.namespaces {
+		fmt.Fprintf(&sb, "    subgraph ns%d[\"%s\"]\n", i, namespace)
+		for _, workload := range topo.workloads[namespace] {
+			nodeIDs[workload] = "w" + strconv.Itoa(len(nodeIDs))
+			fmt.Fprintf(&sb, "        %s[\"%s\"]\n", nodeIDs[workload], topologyNodeLabel(workload))
+		}
+		sb.WriteString("    end\n")
+	}
+	for _, node := range topo.sourcelessNodes() {
+		nodeIDs[node] = node
+		fmt.Fprintf(&sb, "    %s((\"%s\"))\n", node, node)
+	}
+	for i := range topo.edges {
+		edge := &topo.edges[i]
+		arrow := "-->"
+		if edge.sourceless {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&sb, "    %s %s|\"%s\"| %s\n", nodeIDs[edge.source], arrow, edge.label(), nodeIDs[edge.target])
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// buildTopology collects the workloads and the edges of the given connections.
+// The edges of connections with the same source, target and Service (e.g., defined in several files) are merged.
+// Service ports exposed by a resource such as an Ingress, a Route or a Gateway API route get an edge from the external node,
+// and ports exposed only inside the cluster (e.g., for Prometheus scraping) get an edge from the in-cluster node.
+func buildTopology(conns []*Connections) *topology {
+	topo := topology{workloads: map[string][]string{}}
+	addWorkload := func(res *Resource) {
+		namespace := namespaceOrDefault(res.Resource.Namespace)
+		if _, ok := topo.workloads[namespace]; !ok {
+			topo.namespaces = append(topo.namespaces, namespace)
+		}
+		topo.workloads[namespace] = appendUnique(topo.workloads[namespace], workloadIdentity(res))
+	}
+	addEdge := func(edge topologyEdge, ports []SvcNetworkAttr) {
+		idx := slices.IndexFunc(topo.edges, func(other topologyEdge) bool {
+			return other.source == edge.source && other.target == edge.target && other.service == edge.service &&
+				other.exposedBy == edge.exposedBy
+		})
+		if idx < 0 {
+			topo.edges = append(topo.edges, edge)
+			idx = len(topo.edges) - 1
+		}
+		topo.edges[idx].ports = mergePortStrings(topo.edges[idx].ports, ports)
+	}
+
+	for _, conn := range conns {
+		addWorkload(conn.Target)
+		edge := topologyEdge{target: workloadIdentity(conn.Target), service: conn.Link.Resource.Name}
+		if conn.Source != nil {
+			addWorkload(conn.Source)
+			edge.source = workloadIdentity(conn.Source)
+			addEdge(edge, connectionPorts(conn))
+			continue
+		}
+
+		edge.sourceless = true
+		if conn.Link.Resource.ExposeExternally {
+			edge.source = topologyExternalNode
+			addEdge(edge, conn.Link.Resource.Network)
+			continue
+		}
+		for i := range conn.Link.Resource.Network {
+			port := &conn.Link.Resource.Network[i]
+			switch {
+			case len(port.exposedBy) > 0:
+				edge.source, edge.exposedBy = topologyExternalNode, topologyExposingResources(port.exposedBy)
+			case port.exposeToCluster:
+				edge.source, edge.exposedBy = topologyInClusterNode, ""
+			default:
+				continue
+			}
+			addEdge(edge, conn.Link.Resource.Network[i:i+1])
+		}
+	}
+
+	slices.Sort(topo.namespaces)
+	for _, workloads := range topo.workloads {
+		slices.Sort(workloads)
+	}
+	slices.SortFunc(topo.edges, func(edge1, edge2 topologyEdge) int {
+		return strings.Compare(edge1.source+" "+edge1.target+" "+edge1.service+" "+edge1.exposedBy,
+			edge2.source+" "+edge2.target+" "+edge2.service+" "+edge2.exposedBy)
+	})
+	return &topo
+}
+
+// sourcelessNodes returns the nodes which are not workloads, but are the sources of some edges
+func (topo *topology) sourcelessNodes() []string {
+	nodes := []string{}
+	for _, node := range []string{topologyExternalNode, topologyInClusterNode} {
+		if slices.ContainsFunc(topo.edges, func(edge topologyEdge) bool { return edge.source == node }) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (edge *topologyEdge) label() string {
+	label := edge.service
+	if len(edge.ports) > 0 {
+		label += " " + strings.Join(edge.ports, ",")
+	}
+	if edge.exposedBy != "" {
+		label += " via " + edge.exposedBy
+	}
+	return label
+}
+
+// topologyExposingResources returns the kinds and names of the given exposing resources (without their files),
+// e.g., "Route shop/web, Ingress shop/web"
+func topologyExposingResources(exposedBy []string) string {
+	names := make([]string, 0, len(exposedBy))
+	for _, resource := range exposedBy {
+		name, _, _ := strings.Cut(resource, " (") // drop the file in which the resource is defined
+		names = appendUnique(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// topologyNodeLabel returns the label of a workload node, which is already placed in its namespace: its kind and name
+func topologyNodeLabel(workload string) string {
+	kind, qualifiedName, _ := strings.Cut(workload, " ")
+	_, name, _ := strings.Cut(qualifiedName, "/")
+	return kind + " " + name
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2020- IBM Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package analyzer
+
+import (
+	"bytes"
+	_ "embed" // for embedding the HTML report template
+	"encoding/json"
+	"html/template"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	network "k8s.io/api/networking/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+//go:embed topology_report.html.tmpl
+var topologyReportTemplateText string
+
+var topologyReportTemplate = template.Must(template.New("topology_report").Parse(topologyReportTemplateText))
+
+// TopologyReport is the data of an interactive topology report: the workloads (along with their network addresses,
+// the Services selecting them and their synthesized NetworkPolicies) and the connections between them.
+type TopologyReport struct {
+	Namespaces []string         `json:"namespaces"`
+	Workloads  []ReportWorkload `json:"workloads"`
+	Edges      []ReportEdge     `json:"edges"`
+}
+
+// ReportWorkload is a workload in a topology report
+type ReportWorkload struct {
+	ID        string   `json:"id"` // the workload's kind, namespace and name (e.g., "Deployment shop/api")
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses,omitempty"` // the network addresses found in the workload's manifest
+	Services  []string `json:"services,omitempty"`  // the Services through which connections reach the workload
+	Policies  string   `json:"policies,omitempty"`  // the synthesized NetworkPolicies selecting the workload, in YAML format
+}
+
+// ReportEdge is the traffic from a workload, or from the "external" or "in-cluster" node, to a workload through a Service
+type ReportEdge struct {
+	Source     string   `json:"source"` // a workload ID, "external" or "in-cluster"
+	Target     string   `json:"target"`
+	Service    string   `json:"service"`
+	Ports      []string `json:"ports,omitempty"`
+	Sourceless bool     `json:"sourceless,omitempty"` // the source is not a workload, but the Service is exposed outside the application
+	ExposedBy  string   `json:"exposed_by,omitempty"` // the resources (e.g., an Ingress or a Route) exposing the Service ports, if any
+}
+
+// TopologyReportFromInfos returns the topology report of the connections discovered while processing K8s resources
+// in the given slice of Info objects, and of the NetworkPolicies synthesized for them.
+func (ps *PoliciesSynthesizer) TopologyReportFromInfos(infos []*resource.Info) (*TopologyReport, error) {
+	resAcc, errs := ps.accumulateResourcesFromInfos(infos)
+	return ps.topologyReport(resAcc, errs)
+}
+
+// TopologyReportFromFolderPath returns the topology report of the connections discovered while processing K8s resources
+// under the provided directory or one of its subdirectories (recursively), and of the NetworkPolicies synthesized for them.
+func (ps *PoliciesSynthesizer) TopologyReportFromFolderPath(dirPath string) (*TopologyReport, error) {
+	return ps.TopologyReportFromFolderPaths([]string{dirPath})
+}
+
+// TopologyReportFromFolderPaths returns the topology report of the connections discovered while processing K8s resources
+// under the provided directories or one of their subdirectories (recursively), and of the NetworkPolicies synthesized for them.
+func (ps *PoliciesSynthesizer) TopologyReportFromFolderPaths(dirPaths []string) (*TopologyReport, error) {
+	resAcc, errs := ps.accumulateResourcesFromFolderPaths(dirPaths)
+	return ps.topologyReport(resAcc, errs)
+}
+
+func (ps *PoliciesSynthesizer) topologyReport(resAcc *resourceAccumulator, errs []FileProcessingError) (*TopologyReport, error) {
+	var report *TopologyReport
+	if !stopProcessing(ps.stopOnError, errs) {
+		resources, connections, connErrs := ps.extractConnections(resAcc)
+		errs = append(errs, connErrs...)
+		if !stopProcessing(ps.stopOnError, errs) {
+			report = TopologyReportFromConnections(resources, connections, ps.synthNetpols(resources, connections))
+		}
+	}
+
+	ps.errors = errs
+	if err := hasFatalError(errs); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// TopologyReportFromConnections builds the topology report of the given workloads, the connections between them,
+// and the NetworkPolicies synthesized for them. Workloads defined in several files are merged.
+func TopologyReportFromConnections(resources []*Resource, conns []*Connections, policies []*network.NetworkPolicy) *TopologyReport {
+	topo := buildTopology(conns)
+	report := TopologyReport{Namespaces: []string{}, Workloads: []ReportWorkload{}, Edges: []ReportEdge{}}
+	for _, res := range append(slices.Clone(resources), connectedWorkloads(conns)...) {
+		id := workloadIdentity(res)
+		idx := slices.IndexFunc(report.Workloads, func(workload ReportWorkload) bool { return workload.ID == id })
+		if idx < 0 {
+			peer := workloadPeer(res)
+			report.Workloads = append(report.Workloads, ReportWorkload{ID: id, Kind: res.Resource.Kind,
+				Namespace: peer.Namespace, Name: res.Resource.Name, Policies: selectingPoliciesYAML(policies, &peer)})
+			report.Namespaces = appendUnique(report.Namespaces, peer.Namespace)
+			idx = len(report.Workloads) - 1
+		}
+		for _, addr := range res.Resource.NetworkAddrs {
+			report.Workloads[idx].Addresses = appendUnique(report.Workloads[idx].Addresses, addr)
+		}
+	}
+	slices.Sort(report.Namespaces)
+	slices.SortFunc(report.Workloads, func(workload1, workload2 ReportWorkload) int {
+		return strings.Compare(workload1.ID, workload2.ID)
+	})
+
+	for i := range topo.edges {
+		edge := &topo.edges[i]
+		report.Edges = append(report.Edges, ReportEdge{Source: edge.source, Target: edge.target, Service: edge.service,
+			Ports: edge.ports, Sourceless: edge.sourceless, ExposedBy: edge.exposedBy})
+		for j := range report.Workloads {
+			if report.Workloads[j].ID == edge.target {
+				report.Workloads[j].Services = appendUnique(report.Workloads[j].Services, edge.service)
+			}
+		}
+	}
+	return &report
+}
+
+// connectedWorkloads returns the sources and targets of the given connections
+func connectedWorkloads(conns []*Connections) []*Resource {
+	workloads := []*Resource{}
+	for _, conn := range conns {
+		if conn.Source != nil {
+			workloads = append(workloads, conn.Source)
+		}
+		workloads = append(workloads, conn.Target)
+	}
+	return workloads
+}
+
+// selectingPoliciesYAML returns the given policies which select the given pods, as a multi-document YAML
+func selectingPoliciesYAML(policies []*network.NetworkPolicy, pods *FlowPeer) string {
+	docs := []string{}
+	for _, policy := range policies {
+		if !policySelects(policy, pods) {
+			continue
+		}
+		// NetworkPolicies only have json field tags, so they are converted to generic maps before being marshaled into YAML
+		buf, err := json.Marshal(policy)
+		if err != nil {
+			continue
+		}
+		var policyMap map[string]interface{}
+		if err = json.Unmarshal(buf, &policyMap); err != nil {
+			continue
+		}
+		if buf, err = yaml.Marshal(policyMap); err == nil {
+			docs = append(docs, string(buf))
+		}
+	}
+	return strings.Join(docs, "---\n")
+}
+
+// HTML returns a self-contained interactive HTML page showing the report, which requires no network access.
+// The workloads can be filtered by namespace, and clicking a workload shows its addresses, Services and NetworkPolicies.
+func (report *TopologyReport) HTML() string {
+	var buf bytes.Buffer
+	_ = topologyReportTemplate.Execute(&buf, report) // the template is valid, and writing to a buffer does not fail
+	return buf.String()
+}
@@ -7,7 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package analyzer
 
 import (
+	"fmt"
+	"slices"
+
 	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -16,35 +20,148 @@ type cfgMap struct {
 	Data     map[string]string
 }
 
+// cfgMapRef refers to a ConfigMap, whose values are all used by a container (envFrom) or mounted as a volume (empty Container)
+type cfgMapRef struct {
+	Name      string
+	Container string
+}
+
 type cfgMapKeyRef struct {
-	Name string
-	Key  string
+	Name      string
+	Key       string
+	Container string
+	EnvName   string // the name of the environment variable whose value is taken from the ConfigMap
 }
 
 // Resource is an abstraction of a k8s workload resource (e.g., pod, deployment).
 // It also stores additional information that is later being used in the analysis
 type Resource struct {
 	Resource struct {
-		Name               string            `json:"name,omitempty"`
-		Namespace          string            `json:"namespace,omitempty"`
-		Labels             map[string]string `json:"labels,omitempty"`
-		ServiceAccountName string            `json:"serviceaccountname,omitempty"`
-		FilePath           string            `json:"filepath,omitempty"`
-		Kind               string            `json:"kind,omitempty"`
+		Name               string                `json:"name,omitempty"`
+		Namespace          string                `json:"namespace,omitempty"`
+		Labels             map[string]string     `json:"labels,omitempty"`
+		Selector           *metaV1.LabelSelector `json:"-"` // the workload's own spec.selector; nil if the workload has none
+		ServiceAccountName string                `json:"serviceaccountname,omitempty"`
+		FilePath           string                `json:"filepath,omitempty"`
+		Kind               string                `json:"kind,omitempty"`
 		Image              struct {
 			ID string `json:"id,omitempty"`
 		} `json:"image"`
 		NetworkAddrs     []string
-		ConfigMapRefs    []string       `json:"-"`
-		ConfigMapKeyRefs []cfgMapKeyRef `json:"-"`
+		URLSchemes       map[string]string `json:"-"` // the scheme of each network address which is written as a URL (e.g., "https://host/")
+		AddressOrigins   []AddressOrigin   `json:"-"` // where in the workload's manifest each network address was found
+		RejectedAddrs    []AddressOrigin   `json:"-"` // hosts in the workload's manifest which are not valid DNS-1123 subdomains
+		ConfigMapRefs    []cfgMapRef       `json:"-"`
+		ConfigMapKeyRefs []cfgMapKeyRef    `json:"-"`
 		UsedPorts        []SvcNetworkAttr
+		ContainerPorts   []ContainerPortAttr  `json:"container_ports,omitempty"`
+		ProbePorts       []intstr.IntOrString `json:"probe_ports,omitempty"`
+		HostNetwork      bool                 `json:"host_network,omitempty"`
+
+		ExternalDestinations []ExternalDestination `json:"external_destinations,omitempty"`
+		// the destinations of addresses which refer to no Service (nor ServiceEntry), and whose hosts are outside the cluster
+		InferredExternalDestinations []ExternalDestination `json:"-"`
 	} `json:"resource,omitempty"`
 }
 
+// ExternalDestination is used to store information about a destination outside the cluster, to which a workload connects
+type ExternalDestination struct {
+	Host      string          `json:"host,omitempty"`
+	Addresses []string        `json:"addresses,omitempty"` // the CIDRs of the destination; empty if unknown
+	Port      int             `json:"port,omitempty"`      // zero means any port
+	Protocol  corev1.Protocol `json:"protocol,omitempty"`
+}
+
+// AddressOrigin describes where in a workload's manifest a network address was found
+type AddressOrigin struct {
+	Address   string `json:"address"`
+	Container string `json:"container,omitempty"` // empty for addresses in ConfigMaps which are mounted as volumes
+	Field     string `json:"field"`               // e.g., "env DB_HOST", "args[1]" or "configmap db-config key DB_HOST (envFrom)"
+}
+
+func (origin *AddressOrigin) String() string {
+	if origin.Container == "" {
+		return fmt.Sprintf("%s: %q", origin.Field, origin.Address)
+	}
+	return fmt.Sprintf("container %s, %s: %q", origin.Container, origin.Field, origin.Address)
+}
+
+// addManifestValue checks whether a value, found in the given container field of the workload's manifest, holds a network address.
+// If so, the address is recorded together with its origin. Otherwise, a host in the value which is not a valid DNS-1123 subdomain
+// (e.g., "Redis_Master:6379") is recorded as rejected, so it can later be explained why the value is ignored.
+func (r1 *Resource) addManifestValue(value, container, field string) {
+	if netAddr, ok := networkAddressFromStr(value); ok {
+		r1.Resource.NetworkAddrs = append(r1.Resource.NetworkAddrs, netAddr)
+		if scheme, ok := urlSchemeOf(value, netAddr); ok {
+			if r1.Resource.URLSchemes == nil {
+				r1.Resource.URLSchemes = map[string]string{}
+			}
+			r1.Resource.URLSchemes[netAddr] = scheme
+		}
+		r1.Resource.AddressOrigins = append(r1.Resource.AddressOrigins, AddressOrigin{Address: netAddr, Container: container, Field: field})
+	} else if host, ok := invalidHostFromStr(value); ok {
+		r1.Resource.RejectedAddrs = append(r1.Resource.RejectedAddrs, AddressOrigin{Address: host, Container: container, Field: field})
+	}
+}
+
+// addressOrigins returns the origins of the given network address in the workload's manifest
+func (r1 *Resource) addressOrigins(addr string) []AddressOrigin {
+	origins := []AddressOrigin{}
+	for i := range r1.Resource.AddressOrigins {
+		if r1.Resource.AddressOrigins[i].Address == addr {
+			origins = append(origins, r1.Resource.AddressOrigins[i])
+		}
+	}
+	return origins
+}
+
+// equals checks whether both resources are the same workload, i.e., have the same workloadIdentity()
 func (r1 *Resource) equals(r2 *Resource) bool {
-	return r1.Resource.Name == r2.Resource.Name &&
-		r1.Resource.Namespace == r2.Resource.Namespace &&
-		r1.Resource.Kind == r2.Resource.Kind
+	return workloadIdentity(r1) == workloadIdentity(r2)
+}
+
+// resolveTargetPort returns the workload port to which traffic sent to the given service port is forwarded.
+// A missing target port defaults to the service port, and a named target port is resolved using the workload's container ports.
+// The returned bool is false if none of the workload's container ports matches the target port,
+// or if the target port is named but the workload declares no container ports to resolve it against.
+func (r1 *Resource) resolveTargetPort(port *SvcNetworkAttr) (intstr.IntOrString, bool) {
+	targetPort := port.TargetPort
+	if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+		targetPort = intstr.FromInt(port.Port)
+	}
+	if len(r1.Resource.ContainerPorts) == 0 {
+		// workload does not declare its ports - a numbered target port is assumed to be open, but a named one cannot be resolved
+		return targetPort, targetPort.Type == intstr.Int
+	}
+
+	for _, containerPort := range r1.Resource.ContainerPorts {
+		if containerPort.matches(&targetPort, port.Protocol) {
+			return intstr.FromInt(containerPort.Port), true
+		}
+	}
+	return targetPort, false
+}
+
+// ContainerPortAttr is used to store information about a port, declared by one of the workload's containers
+type ContainerPortAttr struct {
+	Name     string          `json:"name,omitempty"`
+	Port     int             `json:"port,omitempty"`
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	HostPort int             `json:"host_port,omitempty"`
+}
+
+func (cp *ContainerPortAttr) matches(port *intstr.IntOrString, protocol corev1.Protocol) bool {
+	if port.Type == intstr.String {
+		return cp.Name != "" && cp.Name == port.StrVal
+	}
+	return cp.Port == int(port.IntVal) && protocolOrDefault(cp.Protocol) == protocolOrDefault(protocol)
+}
+
+func protocolOrDefault(protocol corev1.Protocol) corev1.Protocol {
+	if protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return protocol
 }
 
 // SvcNetworkAttr is used to store port information
@@ -54,6 +171,40 @@ type SvcNetworkAttr struct {
 	TargetPort      intstr.IntOrString `json:"target_port,omitempty"`
 	Protocol        corev1.Protocol    `json:"protocol,omitempty"`
 	exposeToCluster bool
+	ingressSources  []IngressSource // the pods from which an exposed port should accept traffic; empty means all cluster pods
+	httpPaths       []string        // regular expressions of the HTTP paths routed to an exposed port; empty means all paths
+	anyHTTPPath     bool            // true if some resource routes requests with any HTTP path to the exposed port
+	exposedBy       []string        // the resources exposing the port (see ExposedServicePort)
+}
+
+// addHTTPPaths records the HTTP paths of requests routed to the exposed port (an empty slice stands for all paths)
+func (port *SvcNetworkAttr) addHTTPPaths(paths []string) {
+	if len(paths) == 0 {
+		port.anyHTTPPath = true
+		return
+	}
+	for _, path := range paths {
+		if !slices.Contains(port.httpPaths, path) {
+			port.httpPaths = append(port.httpPaths, path)
+		}
+	}
+}
+
+// exposedHTTPPaths returns the HTTP paths of requests routed to the exposed port. Returns nil if requests with any path are routed.
+func (port *SvcNetworkAttr) exposedHTTPPaths() []string {
+	if port.anyHTTPPath || len(port.httpPaths) == 0 {
+		return nil
+	}
+	return port.httpPaths
+}
+
+func (port *SvcNetworkAttr) addIngressSource(source IngressSource) {
+	for i := range port.ingressSources {
+		if port.ingressSources[i].equals(&source) {
+			return
+		}
+	}
+	port.ingressSources = append(port.ingressSources, source)
 }
 
 // Service is used to store information about a K8s Service
@@ -68,6 +219,30 @@ type Service struct {
 		Network          []SvcNetworkAttr   `json:"network,omitempty"`
 		ExposeExternally bool               `json:"-"`
 	} `json:"resource,omitempty"`
+
+	hostAliases []string            // additional hosts through which the Service is reached (e.g., Istio VirtualService hosts)
+	meshSubsets []map[string]string // if not empty, mesh traffic only reaches the Service's pods with one of these label sets
+}
+
+func (svc *Service) addHostAlias(host string) {
+	if !slices.Contains(svc.hostAliases, host) {
+		svc.hostAliases = append(svc.hostAliases, host)
+	}
+}
+
+// reachesWorkload checks whether traffic sent to the Service from within the mesh may reach the given workload
+func (svc *Service) reachesWorkload(res *Resource) bool {
+	if len(svc.meshSubsets) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(svc.meshSubsets, func(subsetLabels map[string]string) bool {
+		for k, v := range subsetLabels {
+			if res.Resource.Labels[k] != v {
+				return false
+			}
+		}
+		return true
+	})
 }
 
 // Connections represents a connection from a source workload to a target workload using via a service.
@@ -75,17 +250,19 @@ type Connections struct {
 	Source *Resource `json:"source,omitempty"`
 	Target *Resource `json:"target"`
 	Link   *Service  `json:"link"`
+	// Origins lists where in the source's manifest the addresses which refer to the link were found
+	Origins []AddressOrigin `json:"-"`
 }
 
 // A map from namespaces to a map of service names in each namespaces, which we want to expose within the cluster.
 // For each service we hold the ports that should be exposed
-type servicesToExpose map[string]map[string][]*intstr.IntOrString
+type servicesToExpose map[string]map[string][]*ExposedServicePort
 
-func (ste servicesToExpose) appendPort(namespace, svcName string, port *intstr.IntOrString) {
-	svcPortsMap, ok := ste[namespace]
+func (ste servicesToExpose) appendPort(port *ExposedServicePort) {
+	svcPortsMap, ok := ste[port.Namespace]
 	if !ok {
-		ste[namespace] = map[string][]*intstr.IntOrString{}
-		svcPortsMap = ste[namespace]
+		ste[port.Namespace] = map[string][]*ExposedServicePort{}
+		svcPortsMap = ste[port.Namespace]
 	}
-	svcPortsMap[svcName] = append(svcPortsMap[svcName], port)
+	svcPortsMap[port.ServiceName] = append(svcPortsMap[port.ServiceName], port)
 }
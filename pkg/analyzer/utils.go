@@ -6,6 +6,10 @@ SPDX-License-Identifier: Apache-2.0
 
 package analyzer
 
+import "slices"
+
+const defaultNamespace = "default"
+
 func stopProcessing(stopOn1stErr bool, errs []FileProcessingError) bool {
 	for idx := range errs {
 		if errs[idx].IsFatal() || stopOn1stErr && errs[idx].IsSevere() {
@@ -21,3 +25,65 @@ func appendAndLogNewError(errs []FileProcessingError, newErr *FileProcessingErro
 	errs = append(errs, *newErr)
 	return errs
 }
+
+// namespaceOrDefault returns the given namespace, or the default namespace if the given namespace is empty
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return defaultNamespace
+	}
+	return namespace
+}
+
+// namespacedName returns the given name, qualified by the given namespace (or by the default namespace if it is empty)
+func namespacedName(namespace, name string) string {
+	return namespaceOrDefault(namespace) + "/" + name
+}
+
+// workloadNamespaces returns the namespaces of the given resources, each only once and in order of first appearance.
+// A resource with no namespace is in the default namespace, which is returned as an empty string if the first
+// resource in it has no namespace (so that the policies generated for the namespace do not name it either).
+func workloadNamespaces(resources []*Resource) []string {
+	namespaces := []string{}
+	seen := map[string]bool{}
+	for _, res := range resources {
+		namespace := res.Resource.Namespace
+		if !seen[namespaceOrDefault(namespace)] {
+			seen[namespaceOrDefault(namespace)] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// sortedWorkloadNamespaces returns the names of the namespaces of the given resources (see workloadNamespaces), sorted
+func sortedWorkloadNamespaces(resources []*Resource) []string {
+	namespaces := workloadNamespaces(resources)
+	for i := range namespaces {
+		namespaces[i] = namespaceOrDefault(namespaces[i])
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// workloadIdentity identifies a workload by its kind, namespace (a missing namespace is the default one) and name,
+// e.g., "Deployment default/api". It is used both for keying workloads and for naming them in the output.
+func workloadIdentity(res *Resource) string {
+	return res.Resource.Kind + " " + namespacedName(res.Resource.Namespace, res.Resource.Name)
+}
+
+// connectionPorts returns the ports of the given connection between workloads: the ports its source uses, if known,
+// and otherwise the ports of its Service
+func connectionPorts(conn *Connections) []SvcNetworkAttr {
+	if len(conn.Source.Resource.UsedPorts) > 0 {
+		return conn.Source.Resource.UsedPorts
+	}
+	return conn.Link.Resource.Network
+}
+
+// appendUnique appends the given string to the given strings, unless it is already one of them
+func appendUnique(strs []string, str string) []string {
+	if slices.Contains(strs, str) {
+		return strs
+	}
+	return append(strs, str)
+}